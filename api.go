@@ -3,10 +3,33 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/canthefason/irc-k/audit"
+	"github.com/canthefason/irc-k/auth"
 	"github.com/canthefason/irc-k/client"
 	"github.com/canthefason/irc-k/common"
 	"github.com/canthefason/irc-k/config"
+	"github.com/canthefason/irc-k/delivery"
+	"github.com/canthefason/irc-k/filter"
+	"github.com/canthefason/irc-k/follow"
+	"github.com/canthefason/irc-k/formatting"
+	"github.com/canthefason/irc-k/health"
+	"github.com/canthefason/irc-k/logger"
+	"github.com/canthefason/irc-k/metrics"
+	"github.com/canthefason/irc-k/notification"
+	"github.com/canthefason/irc-k/paste"
+	"github.com/canthefason/irc-k/ratelimit"
+	"github.com/canthefason/irc-k/search"
+	"github.com/canthefason/irc-k/unread"
+	"github.com/canthefason/irc-k/webhook"
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/binding"
 	"github.com/martini-contrib/render"
@@ -14,28 +37,240 @@ import (
 )
 
 var (
-	ErrNotSet  = errors.New("not set")
-	ErrUnknown = errors.New("unknown error")
-	connMap    map[string]*client.Connection
+	ErrNotSet      = errors.New("not set")
+	ErrUnknown     = errors.New("unknown error")
+	ErrInvalidTime = errors.New("invalid time")
+	connMap        map[string]*client.Connection
+	// locks of nicknames held while connecting
+	connLocks map[string]*sync.Mutex
+	// guards connMap and connLocks
+	connMu sync.Mutex
+
+	// authenticator is nil when authentication is disabled
+	authenticator auth.Authenticator
+	bindings      auth.Bindings
+
+	limiter *ratelimit.Limiter
+	filters *filter.Chain
+	tracker *delivery.Tracker
+	index   *search.Index
+	hooks   *webhook.Store
+	rules   *notification.Store
+	readers *unread.Store
+	follows *follow.Store
+	records *audit.Log
+
+	// dispatcher replays failed webhook deliveries
+	dispatcher *webhook.Dispatcher
+	// bots routes incoming webhook messages to feeder bots
+	bots *webhook.Bots
+
+	// outboxes of feeder bots by their names
+	outboxes   map[string]*webhook.Outbox
+	outboxesMu sync.Mutex
+
+	// pastes is nil unless long messages are pasted
+	pastes *paste.FileStore
+
+	requestDuration = metrics.NewHistogram("irck_http_request_duration_seconds",
+		"Latency of api requests", nil, "method", "route", "status")
 )
 
 func init() {
 	// set up a goroutine to read commands from stdin
 	connMap = make(map[string]*client.Connection)
+	connLocks = make(map[string]*sync.Mutex)
+	outboxes = make(map[string]*webhook.Outbox)
 }
 
 func main() {
+	var err error
+	authenticator, err = auth.New(&config.Conf.Auth)
+	if err != nil {
+		panic(err)
+	}
+	bindings = auth.NewBindings(config.Conf.Identity)
+
+	limiter, err = ratelimit.NewLimiter(config.Conf.RateLimit)
+	if err != nil {
+		panic(err)
+	}
+
+	if config.Conf.Message.Long == common.LONG_PASTE {
+		pastes, err = paste.NewFileStore(&config.Conf.Paste)
+		if err != nil {
+			panic(err)
+		}
+		go prunePastes()
+	}
+
+	filters, err = filter.NewChain(config.Conf.Filter)
+	if err != nil {
+		panic(err)
+	}
+
+	redisConn := common.Initialize(&config.Conf.Redis)
+	filters.Quarantine = filter.NewQuarantine(redisConn)
+	tracker = delivery.NewTracker(redisConn)
+	index = search.NewIndex(redisConn, &config.Conf.Search)
+	hooks = webhook.NewStore(redisConn)
+	dispatcher = webhook.NewDispatcher(hooks)
+	bots = webhook.NewBots(redisConn)
+	rules = notification.NewStore(redisConn)
+	readers = unread.NewStore(redisConn)
+	readers.Index = index
+	follows = follow.NewStore(redisConn)
+	records = audit.NewLog(redisConn)
+	health.Register("redis", common.PingRedis)
+	health.Register("queue", common.PingQueue)
+
 	m := martini.Classic()
+	m.MapTo(logger.New("api"), (*logger.Logger)(nil))
+	m.Use(instrument)
 	m.Use(render.Renderer())
-	m.Post("/sendMessage", binding.Json(MessageRequest{}), sendMessage)
-	m.Post("/join", binding.Json(ChannelRequest{}), join)
+	m.Post("/sendMessage", audited(audit.ACTION_SEND_MESSAGE), authenticate,
+		binding.Json(MessageRequest{}), sendMessage)
+	m.Post("/join", audited(audit.ACTION_JOIN), authenticate, binding.Json(ChannelRequest{}), join)
+	m.Get("/messages/:id", authenticate, messageStatus)
+	m.Get("/pastes/:id", authenticate, showPaste)
+	m.Get("/search", authenticate, searchMessages)
+	m.Post("/webhooks", authenticate, authorizeWebhook, binding.Json(WebhookRequest{}), registerWebhook)
+	m.Get("/webhooks", authenticate, listWebhooks)
+	m.Get("/webhooks/dead-letters", authenticate, authorizeAdmin, listDeadLetters)
+	m.Post("/webhooks/dead-letters/:id/replay", authenticate, authorizeAdmin, replayDeadLetter)
+	m.Delete("/webhooks/:id", authenticate, removeWebhook)
+	m.Get("/quarantine", authenticate, authorizeAdmin, listQuarantine)
+	m.Post("/quarantine/:id/release", authenticate, authorizeAdmin, releaseQuarantined)
+	m.Post("/hooks", authenticate, binding.Json(IncomingRequest{}), registerIncoming)
+	m.Get("/hooks", authenticate, listIncoming)
+	m.Delete("/hooks/:token", authenticate, removeIncoming)
+	m.Post("/hooks/:token", audited(audit.ACTION_HOOK), postIncoming)
+	m.Post("/notifications/rules", authenticate, binding.Json(RuleRequest{}), addRule)
+	m.Get("/notifications/rules", authenticate, listRules)
+	m.Delete("/notifications/rules/:id", authenticate, removeRule)
+	m.Get("/notifications", authenticate, listNotifications)
+	m.Get("/follows", authenticate, listFollows)
+	m.Post("/follows", authenticate, binding.Json(FollowRequest{}), followChannel)
+	m.Delete("/follows/:channel", authenticate, unfollowChannel)
+	m.Get("/unread", authenticate, unreadCounts)
+	m.Post("/unread/:channel", authenticate, binding.Json(ReadRequest{}), markRead)
+	m.Post("/channels/:channel/topic", audited(audit.ACTION_TOPIC), authenticate,
+		binding.Json(ModerationRequest{}), setTopic)
+	m.Post("/channels/:channel/kick", audited(audit.ACTION_KICK), authenticate,
+		binding.Json(ModerationRequest{}), kickUser)
+	m.Post("/channels/:channel/ban", audited(audit.ACTION_BAN), authenticate,
+		binding.Json(ModerationRequest{}), banMask)
+	m.Post("/channels/:channel/unban", audited(audit.ACTION_UNBAN), authenticate,
+		binding.Json(ModerationRequest{}), unbanMask)
+	m.Post("/channels/:channel/mode", audited(audit.ACTION_MODE), authenticate,
+		binding.Json(ModerationRequest{}), setMode)
+	m.Post("/channels/:channel/invite", audited(audit.ACTION_INVITE), authenticate,
+		binding.Json(ModerationRequest{}), inviteUser)
+	m.Get("/audit", authenticate, authorizeAdmin, queryAudit)
+	m.Get("/metrics", metrics.Handler().ServeHTTP)
+	m.Get("/healthz", health.LivenessHandler)
+	m.Get("/readyz", health.ReadinessHandler)
 
 	m.Run()
 }
 
+// instrument measures request latency. Matched route pattern is used as
+// label instead of request path for keeping label values bounded
+func instrument(c martini.Context, res http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	c.Next()
+
+	route := "unmatched"
+	if v := c.Get(reflect.TypeOf((*martini.Route)(nil)).Elem()); v.IsValid() {
+		route = v.Interface().(martini.Route).Pattern()
+	}
+
+	status := res.(martini.ResponseWriter).Status()
+	requestDuration.Observe(time.Since(start).Seconds(),
+		req.Method, route, strconv.Itoa(status))
+}
+
+// authenticate maps authenticated user to request context. Empty user is
+// mapped when authentication is disabled
+func authenticate(c martini.Context, req *http.Request, r render.Render, log logger.Logger) {
+	if authenticator == nil {
+		c.Map(auth.User(""))
+		return
+	}
+
+	user, err := authenticator.Authenticate(req)
+	if err != nil {
+		log.Info("Could not authenticate request: %s", err)
+		fail(r, err)
+		return
+	}
+
+	c.Map(user)
+}
+
+// authorize checks whether user is allowed to use given nickname
+func authorize(user auth.User, nickname string) error {
+	if authenticator == nil {
+		return nil
+	}
+
+	if !bindings.Allowed(user, nickname) {
+		return auth.ErrForbidden
+	}
+
+	return nil
+}
+
+// authorizeAdmin allows only configured admins. All users are allowed
+// when authentication is disabled
+func authorizeAdmin(r render.Render, log logger.Logger, user auth.User) {
+	if authenticator == nil {
+		return
+	}
+
+	if !config.Conf.Auth.IsAdmin(user) {
+		log.Warn("%s is not allowed to use admin endpoints", user)
+		fail(r, auth.ErrForbidden)
+	}
+}
+
+// authorizeWebhook allows only admins and configured webhook users to
+// register webhooks
+func authorizeWebhook(r render.Render, log logger.Logger, user auth.User) {
+	if authenticator == nil || config.Conf.Auth.IsAdmin(user) || config.Conf.Webhook.Allowed(string(user)) {
+		return
+	}
+
+	log.Warn("%s is not allowed to register webhooks", user)
+	fail(r, auth.ErrForbidden)
+}
+
+// audited maps an audit entry of action to request context, and records
+// it with the response status after request is handled. Handlers
+// describe the irc side of the action. It runs before authentication, so
+// that rejected requests are recorded as well
+func audited(action string) martini.Handler {
+	return func(c martini.Context, res http.ResponseWriter, log logger.Logger) {
+		e := &audit.Entry{Action: action}
+		c.Map(e)
+		c.Next()
+
+		if v := c.Get(reflect.TypeOf(auth.User(""))); v.IsValid() && e.User == "" {
+			e.User = v.String()
+		}
+
+		e.Status = res.(martini.ResponseWriter).Status()
+		if err := records.Record(e); err != nil {
+			log.Error("Could not record %s action of %s: %s", action, e.User, err)
+		}
+	}
+}
+
 type Response struct {
 	Success bool     `json:"response"`
 	Errors  []string `json:"errors"`
+	// ID is set for accepted messages
+	ID string `json:"id,omitempty"`
 }
 
 func NewResponse(success bool, errors ...error) Response {
@@ -48,23 +283,42 @@ func NewResponse(success bool, errors ...error) Response {
 }
 
 func fail(r render.Render, errors ...error) {
-	var status int
-
 	if len(errors) == 0 {
 		r.JSON(400, NewResponse(false, ErrUnknown))
 		return
 	}
 
-	switch errors[0] {
+	r.JSON(statusOf(errors[0]), NewResponse(false, errors...))
+}
+
+// statusOf returns http status code of the given error
+func statusOf(err error) int {
+	var status int
+
+	switch err {
+	case auth.ErrUnauthorized, auth.ErrInvalidToken, auth.ErrTokenExpired:
+		status = 401
+	case auth.ErrForbidden, filter.ErrFiltered, client.ErrNotOperator, client.ErrNotOnChannel:
+		status = 403
+	case delivery.ErrNotFound, paste.ErrNotFound, webhook.ErrNotFound, notification.ErrNotFound,
+		follow.ErrNotFound, search.ErrNotFound, filter.ErrNotFound, client.ErrNoSuchNick,
+		client.ErrNoSuchChannel, client.ErrUserNotInChannel:
+		status = 404
 	case client.ErrTimeout:
 		status = 408
+	case client.ErrUserOnChannel:
+		status = 409
+	case common.ErrMessageTooLong, webhook.ErrPayloadTooLarge:
+		status = 413
+	case ratelimit.ErrRateLimited:
+		status = 429
 	case client.ErrInternal:
 		status = 500
 	default:
 		status = 400
 	}
 
-	r.JSON(status, NewResponse(false, errors...))
+	return status
 }
 
 func success(r render.Render) {
@@ -75,6 +329,78 @@ type MessageRequest struct {
 	Nickname string `json:"nickname"  binding:"required" validate:"nonzero" `
 	Body     string `json:"body" binding:"required" validate:"nonzero"`
 	Channel  string `json:"channel" binding:"required" validate:"nonzero"`
+	// Callback is notified with delivery status changes of message
+	Callback string `json:"callback"`
+	// Format of body. markdown bodies are converted into irc formatting
+	// codes, and plain bodies are stripped from them
+	Format string `json:"format"`
+}
+
+// WebhookRequest registers url for messages of channel. Optional filters
+// narrow down the delivered messages
+type WebhookRequest struct {
+	Channel  string `json:"channel" binding:"required"`
+	URL      string `json:"url" binding:"required"`
+	Pattern  string `json:"pattern"`
+	Nickname string `json:"nickname"`
+	Mention  string `json:"mention"`
+	// Secret is generated when it is not set
+	Secret string `json:"secret"`
+}
+
+// IncomingRequest creates an incoming webhook which posts to channel
+// via feeder bots
+type IncomingRequest struct {
+	Channel string `json:"channel" binding:"required"`
+	// Bot name of the sender feeder. Configured bot name is used when
+	// it is not set
+	Bot string `json:"bot"`
+	// Template renders json payloads. See webhook.Incoming
+	Template string `json:"template"`
+	Format   string `json:"format"`
+}
+
+// RuleRequest creates a notification rule. At least one of nickname,
+// keywords and pattern must be set
+type RuleRequest struct {
+	Nickname string                   `json:"nickname"`
+	Keywords []string                 `json:"keywords"`
+	Pattern  string                   `json:"pattern"`
+	Channels []string                 `json:"channels"`
+	Quiet    *notification.QuietHours `json:"quietHours"`
+	Webhook  string                   `json:"webhook"`
+	Secret   string                   `json:"secret"`
+}
+
+// ReadRequest marks a channel read until the given message. Current
+// time is used when time is not set. Mentions of nickname are counted
+type ReadRequest struct {
+	MsgID    string    `json:"msgid"`
+	Time     time.Time `json:"time"`
+	Nickname string    `json:"nickname"`
+}
+
+// FollowRequest follows a channel, or updates its settings. Mentions of
+// nickname are counted in unread counts
+type FollowRequest struct {
+	Channel  string          `json:"channel" binding:"required"`
+	Nickname string          `json:"nickname"`
+	Settings follow.Settings `json:"settings"`
+}
+
+// ModerationRequest runs a moderation operation on channel via the irc
+// connection of nickname. Fields are used depending on the operation
+type ModerationRequest struct {
+	Nickname string `json:"nickname" binding:"required"`
+	Topic    string `json:"topic"`
+	// Target is the kicked or invited nickname
+	Target string `json:"target"`
+	Reason string `json:"reason"`
+	// Mask is the banned or unbanned hostmask (e.g. *!*@example.com)
+	Mask string `json:"mask"`
+	// Modes are set in +/- form, and Args are their arguments
+	Modes string   `json:"modes"`
+	Args  []string `json:"args"`
 }
 
 type ChannelRequest struct {
@@ -82,13 +408,18 @@ type ChannelRequest struct {
 	Nickname string `json:"nickname" binding:"required" validate:"nonzero"`
 }
 
-func (mr *MessageRequest) mapToMessage() *common.Message {
+func (mr *MessageRequest) mapToMessage() (*common.Message, error) {
+	body, err := formatting.ToIRC(mr.Body, mr.Format)
+	if err != nil {
+		return nil, err
+	}
+
 	m := new(common.Message)
 	m.Nickname = mr.Nickname
-	m.Body = mr.Body
+	m.Body = body
 	m.Channel = mr.Channel
 
-	return m
+	return m, nil
 }
 
 func mapValidatorError(err error) error {
@@ -111,7 +442,9 @@ func parseValidatorErrors(errs map[string][]error) []error {
 	return errors
 }
 
-func sendMessage(_ martini.Params, mr MessageRequest, r render.Render) {
+func sendMessage(_ martini.Params, mr MessageRequest, e *audit.Entry, r render.Render, log logger.Logger,
+	user auth.User) {
+	e.Describe(mr.Nickname, mr.Channel, "", mr.Body)
 	valid, errs := validator.Validate(mr)
 	if !valid {
 		errors := parseValidatorErrors(errs)
@@ -119,22 +452,631 @@ func sendMessage(_ martini.Params, mr MessageRequest, r render.Render) {
 		return
 	}
 
+	m, err := mr.mapToMessage()
+	if err != nil {
+		fail(r, err)
+		return
+	}
+
+	if mr.Callback != "" {
+		if err := delivery.ValidateCallback(mr.Callback); err != nil {
+			fail(r, err)
+			return
+		}
+	}
+
+	log = log.New("nickname", mr.Nickname).New("channel", mr.Channel)
+	if err := authorize(user, mr.Nickname); err != nil {
+		log.Warn("%s is not allowed to use nickname", user)
+		fail(r, err)
+		return
+	}
+
+	if d := filters.Check(m, filter.DIRECTION_OUTBOUND); d.Blocked() {
+		fail(r, filter.ErrFiltered)
+		return
+	}
+
+	// limits are checked before connecting, so that limited users do not
+	// open connections. Tokens are given back when connection fails
+	keys := limitKeys(user, &mr)
+	wait, err := limiter.Reserve(keys...)
+	if err != nil {
+		log.Info("Message is rate limited for %s", wait)
+		r.Header().Set("Retry-After", strconv.Itoa(ratelimit.RetryAfter(wait)))
+		fail(r, err)
+		return
+	}
+
 	conn, err := Connect(mr.Nickname)
 	if err != nil {
+		limiter.Release(keys...)
+		log.Warn("Could not connect: %s", err)
 		fail(r, err)
 		return
 	}
 
-	m := mr.mapToMessage()
+	if _, err := tracker.Create(m, string(user), mr.Callback); err != nil {
+		limiter.Release(keys...)
+		log.Error("Could not create delivery status: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	res := NewResponse(true)
+	res.ID = m.ID
+	log = log.New("id", m.ID)
+
+	if wait > 0 {
+		// queued messages are delivered after reserved delay
+		time.AfterFunc(wait, func() {
+			if err := conn.SendMessage(m); err != nil {
+				log.Warn("Could not send queued message: %s", err)
+			}
+		})
+		r.JSON(202, res)
+		return
+	}
+
 	if err := conn.SendMessage(m); err != nil {
+		log.Warn("Could not send message: %s", err)
+		r.JSON(statusOf(err), Response{Success: false, Errors: []string{err.Error()}, ID: m.ID})
+		return
+	}
+
+	r.JSON(200, res)
+}
+
+// searchMessages responds with messages matching q query parameter.
+// See search.ParseQuery for query syntax
+func searchMessages(req *http.Request, r render.Render, log logger.Logger) {
+	q, err := search.ParseQuery(req.URL.Query().Get("q"))
+	if err != nil {
+		fail(r, err)
+		return
+	}
+
+	limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+	hits, err := index.Search(q, limit)
+	if err != nil {
+		log.Error("Could not search messages: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	r.JSON(200, hits)
+}
+
+// registerWebhook stores a webhook of user, and responds with the hook
+// including its signing secret
+func registerWebhook(wr WebhookRequest, r render.Render, log logger.Logger, user auth.User) {
+	h := &webhook.Hook{
+		User:     string(user),
+		Channel:  wr.Channel,
+		URL:      wr.URL,
+		Pattern:  wr.Pattern,
+		Nickname: wr.Nickname,
+		Mention:  wr.Mention,
+		Secret:   wr.Secret,
+	}
+
+	if err := hooks.Register(h); err != nil {
+		if err != webhook.ErrInvalidURL && err != webhook.ErrInvalidPattern && err != webhook.ErrPrivateAddress &&
+			err != common.ErrChannelNotSet {
+			log.Error("Could not register webhook: %s", err)
+			err = client.ErrInternal
+		}
+		fail(r, err)
+		return
+	}
+
+	r.JSON(201, h)
+}
+
+// listWebhooks responds with webhooks of user
+func listWebhooks(r render.Render, log logger.Logger, user auth.User) {
+	list, err := hooks.List(string(user))
+	if err != nil {
+		log.Error("Could not list webhooks: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	r.JSON(200, list)
+}
+
+// listDeadLetters responds with the newest failed webhook deliveries.
+// At most 100 deliveries are returned by default
+func listDeadLetters(req *http.Request, r render.Render, log logger.Logger) {
+	count, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+	if count <= 0 || count > webhook.DEAD_LETTER_SIZE {
+		count = 100
+	}
+
+	letters, err := hooks.DeadLetters(count)
+	if err != nil {
+		log.Error("Could not list dead letters: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	r.JSON(200, letters)
+}
+
+// replayDeadLetter delivers a failed webhook delivery again
+func replayDeadLetter(params martini.Params, r render.Render, log logger.Logger) {
+	if err := dispatcher.Replay(params["id"]); err != nil {
+		if err != webhook.ErrNotFound {
+			log.Error("Could not replay dead letter: %s", err)
+			err = client.ErrInternal
+		}
+		fail(r, err)
+		return
+	}
+
+	r.JSON(202, NewResponse(true))
+}
+
+// listQuarantine responds with the newest quarantined messages
+func listQuarantine(req *http.Request, r render.Render, log logger.Logger) {
+	count, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+	if count <= 0 || count > filter.QUARANTINE_SIZE {
+		count = 100
+	}
+
+	entries, err := filters.Quarantine.List(count)
+	if err != nil {
+		log.Error("Could not list quarantined messages: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	r.JSON(200, entries)
+}
+
+// releaseQuarantined removes a quarantined message and delivers it.
+// Inbound messages are published to channel subscribers, and outbound
+// messages are sent to their channel
+func releaseQuarantined(params martini.Params, r render.Render, log logger.Logger) {
+	e, err := filters.Quarantine.Take(params["id"])
+	if err != nil {
+		if err != filter.ErrNotFound {
+			log.Error("Could not release quarantined message: %s", err)
+			err = client.ErrInternal
+		}
+		fail(r, err)
+		return
+	}
+
+	log = log.New("channel", e.Channel).New("id", e.ID)
+	m := e.Message
+	if e.Direction == filter.DIRECTION_INBOUND {
+		err = common.Send(m)
+	} else {
+		var conn *client.Connection
+		if conn, err = Connect(m.Nickname); err == nil {
+			err = conn.SendMessage(&m)
+		}
+	}
+
+	if err != nil {
+		log.Warn("Could not deliver released message: %s", err)
+		fail(r, err)
+		return
+	}
+
+	success(r)
+}
+
+// removeWebhook removes a webhook. When authentication is enabled, users
+// can only remove their own webhooks
+func removeWebhook(params martini.Params, r render.Render, user auth.User) {
+	h, err := hooks.Get(params["id"])
+	if err != nil {
+		fail(r, err)
+		return
+	}
+
+	if authenticator != nil && h.User != string(user) {
+		fail(r, webhook.ErrNotFound)
+		return
+	}
+
+	if err := hooks.Remove(h.ID); err != nil {
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	success(r)
+}
+
+// registerIncoming creates an incoming webhook of user, and responds
+// with the hook including its token
+func registerIncoming(ir IncomingRequest, r render.Render, log logger.Logger, user auth.User) {
+	h := &webhook.Incoming{
+		User:     string(user),
+		Channel:  ir.Channel,
+		Bot:      ir.Bot,
+		Template: ir.Template,
+		Format:   ir.Format,
+	}
+	if h.Bot == "" {
+		h.Bot = config.Conf.IRC.BotName
+	}
+
+	// messages of bots without running feeders are never sent
+	running, err := bots.IsRunning(h.Bot)
+	if err != nil {
+		log.Error("Could not check bot: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	if !running {
+		fail(r, webhook.ErrUnknownBot)
+		return
+	}
+
+	if err := hooks.RegisterIncoming(h); err != nil {
+		if err != webhook.ErrInvalidTemplate && err != formatting.ErrInvalidFormat && err != common.ErrChannelNotSet {
+			log.Error("Could not register incoming webhook: %s", err)
+			err = client.ErrInternal
+		}
+		fail(r, err)
+		return
+	}
+
+	r.JSON(201, h)
+}
+
+// listIncoming responds with incoming webhooks of user
+func listIncoming(r render.Render, log logger.Logger, user auth.User) {
+	list, err := hooks.ListIncoming(string(user))
+	if err != nil {
+		log.Error("Could not list incoming webhooks: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	r.JSON(200, list)
+}
+
+// removeIncoming removes an incoming webhook. When authentication is
+// enabled, users can only remove their own webhooks
+func removeIncoming(params martini.Params, r render.Render, user auth.User) {
+	h, err := hooks.GetIncoming(params["token"])
+	if err != nil {
+		fail(r, err)
+		return
+	}
+
+	if authenticator != nil && h.User != string(user) {
+		fail(r, webhook.ErrNotFound)
+		return
+	}
+
+	if err := hooks.RemoveIncoming(h.Token); err != nil {
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	success(r)
+}
+
+// postIncoming renders payload of an incoming webhook, and queues it
+// into the outbox of its feeder bot. Token of the hook authenticates
+// the request
+func postIncoming(params martini.Params, req *http.Request, e *audit.Entry, r render.Render, log logger.Logger) {
+	h, err := hooks.GetIncoming(params["token"])
+	if err != nil {
+		fail(r, err)
+		return
+	}
+	// messages of incoming webhooks are recorded as actions of hook owners
+	e.User = h.User
+	e.Describe(h.Bot, h.Channel, "", "")
+
+	payload, err := ioutil.ReadAll(io.LimitReader(req.Body, webhook.MAX_PAYLOAD_SIZE+1))
+	if err != nil {
+		fail(r, webhook.ErrInvalidPayload)
+		return
+	}
+
+	body, err := h.Render(req.Header.Get("Content-Type"), payload)
+	if err != nil {
+		fail(r, err)
+		return
+	}
+	e.PayloadHash = audit.Hash(body)
+
+	log = log.New("bot", h.Bot).New("channel", h.Channel)
+	wait, err := limiter.Reserve(
+		ratelimit.Key{Scope: ratelimit.SCOPE_CHANNEL, Name: h.Channel},
+		ratelimit.Key{Scope: ratelimit.SCOPE_NETWORK, Name: config.Conf.IRC.Server},
+	)
+	if err != nil {
+		log.Info("Webhook message is rate limited for %s", wait)
+		r.Header().Set("Retry-After", strconv.Itoa(ratelimit.RetryAfter(wait)))
+		fail(r, err)
+		return
+	}
+
+	m := &common.Message{Nickname: h.Bot, Body: body, Channel: h.Channel}
+	if d := filters.Check(m, filter.DIRECTION_OUTBOUND); d.Blocked() {
+		fail(r, filter.ErrFiltered)
+		return
+	}
+
+	if _, err := tracker.Create(m, h.User, ""); err != nil {
+		log.Error("Could not create delivery status: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	// messages are sent by the feeder bot which joined the channel, since
+	// channels can reject messages of users who are not on the channel
+	bot, err := bots.Route(h.Bot, h.Channel)
+	if err != nil {
+		log.Error("Could not route webhook message: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	outbox := outboxOf(bot)
+	post := func() {
+		if err := outbox.Post(m); err != nil {
+			log.New("id", m.ID).Error("Could not queue webhook message: %s", err)
+			tracker.Update(m.ID, common.STATUS_FAILED, err.Error())
+		}
+	}
+
+	res := NewResponse(true)
+	res.ID = m.ID
+	// messages are sent asynchronously by feeder bots
+	if wait > 0 {
+		time.AfterFunc(wait, post)
+	} else {
+		post()
+	}
+
+	r.JSON(202, res)
+}
+
+// outboxOf returns outbox of the feeder bots with the given name
+func outboxOf(bot string) *webhook.Outbox {
+	outboxesMu.Lock()
+	defer outboxesMu.Unlock()
+
+	outbox, ok := outboxes[bot]
+	if !ok {
+		outbox = webhook.NewOutbox(&config.Conf.Redis, bot)
+		outboxes[bot] = outbox
+	}
+
+	return outbox
+}
+
+// addRule stores a notification rule of user
+func addRule(rr RuleRequest, r render.Render, log logger.Logger, user auth.User) {
+	rule := &notification.Rule{
+		User:     string(user),
+		Nickname: rr.Nickname,
+		Keywords: rr.Keywords,
+		Pattern:  rr.Pattern,
+		Channels: rr.Channels,
+		Quiet:    rr.Quiet,
+		Webhook:  rr.Webhook,
+		Secret:   rr.Secret,
+	}
+
+	if err := rules.Add(rule); err != nil {
+		switch err {
+		case notification.ErrEmptyRule, notification.ErrInvalidPattern,
+			notification.ErrInvalidQuietHours, webhook.ErrInvalidURL:
+		default:
+			log.Error("Could not add notification rule: %s", err)
+			err = client.ErrInternal
+		}
+		fail(r, err)
+		return
+	}
+
+	r.JSON(201, rule)
+}
+
+// listRules responds with notification rules of user
+func listRules(r render.Render, log logger.Logger, user auth.User) {
+	list, err := rules.List(string(user))
+	if err != nil {
+		log.Error("Could not list notification rules: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	r.JSON(200, list)
+}
+
+// removeRule removes a notification rule. When authentication is
+// enabled, users can only remove their own rules
+func removeRule(params martini.Params, r render.Render, user auth.User) {
+	rule, err := rules.Get(params["id"])
+	if err != nil {
+		fail(r, err)
+		return
+	}
+
+	if authenticator != nil && rule.User != string(user) {
+		fail(r, notification.ErrNotFound)
+		return
+	}
+
+	if err := rules.Remove(rule.ID); err != nil {
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	success(r)
+}
+
+// listNotifications responds with the newest notifications of user.
+// limit query parameter sets their count
+func listNotifications(req *http.Request, r render.Render, log logger.Logger, user auth.User) {
+	limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+	if limit <= 0 || limit > notification.STREAM_SIZE {
+		limit = 50
+	}
+
+	list, err := rules.Notifications(string(user), limit)
+	if err != nil {
+		log.Error("Could not list notifications: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	r.JSON(200, list)
+}
+
+// queryAudit responds with audit entries, newest first. Entries are
+// filtered with user, nickname, action, channel, since and until query
+// parameters. since and until are in RFC3339 format
+func queryAudit(req *http.Request, r render.Render, log logger.Logger) {
+	params := req.URL.Query()
+	q := &audit.Query{
+		User:     params.Get("user"),
+		Nickname: params.Get("nickname"),
+		Action:   params.Get("action"),
+		Channel:  params.Get("channel"),
+	}
+	q.Limit, _ = strconv.Atoi(params.Get("limit"))
+
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			fail(r, fmt.Errorf("%s %s", name, ErrInvalidTime))
+			return
+		}
+		*t = parsed
+	}
+
+	entries, err := records.Query(q)
+	if err != nil {
+		log.Error("Could not query audit log: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	r.JSON(200, entries)
+}
+
+// unreadCounts responds with unread message and mention counts of user
+// in channels marked read before
+func unreadCounts(r render.Render, log logger.Logger, user auth.User) {
+	counts, err := readers.Counts(string(user))
+	if err != nil {
+		log.Error("Could not get unread counts: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	r.JSON(200, counts)
+}
+
+// markRead sets read marker of user in channel
+func markRead(params martini.Params, rr ReadRequest, r render.Render, log logger.Logger, user auth.User) {
+	if rr.Nickname != "" {
+		if err := authorize(user, rr.Nickname); err != nil {
+			fail(r, err)
+			return
+		}
+	}
+
+	marker := &unread.Marker{MsgID: rr.MsgID, Time: rr.Time}
+	err := readers.MarkRead(string(user), rr.Nickname, params["channel"], marker)
+	if err == search.ErrNotFound {
 		fail(r, err)
 		return
 	}
 
+	if err != nil {
+		log.New("channel", params["channel"]).Error("Could not mark channel read: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
 	success(r)
 }
 
-func join(_ martini.Params, cr ChannelRequest, r render.Render) {
+// prunePastes periodically removes expired pastes
+func prunePastes() {
+	log := logger.New("api")
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := pastes.Prune(); err != nil {
+			log.Warn("Could not prune pastes: %s", err)
+		}
+	}
+}
+
+// showPaste responds with body of a pasted message
+func showPaste(params martini.Params, r render.Render) {
+	if pastes == nil {
+		fail(r, paste.ErrNotFound)
+		return
+	}
+
+	body, err := pastes.Get(params["id"])
+	if err != nil {
+		fail(r, err)
+		return
+	}
+
+	r.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	r.Data(200, []byte(body))
+}
+
+// messageStatus responds with delivery status of a message. When
+// authentication is enabled, users can only see their own messages
+func messageStatus(params martini.Params, r render.Render, user auth.User) {
+	s, err := tracker.Get(params["id"])
+	if err != nil {
+		fail(r, err)
+		return
+	}
+
+	if authenticator != nil && s.User != string(user) {
+		fail(r, delivery.ErrNotFound)
+		return
+	}
+
+	r.JSON(200, s)
+}
+
+// limitKeys returns the rate limit keys of api user, nickname, channel
+// and network which a message is limited by
+func limitKeys(user auth.User, mr *MessageRequest) []ratelimit.Key {
+	keys := []ratelimit.Key{
+		{Scope: ratelimit.SCOPE_NICKNAME, Name: mr.Nickname},
+		{Scope: ratelimit.SCOPE_CHANNEL, Name: mr.Channel},
+		{Scope: ratelimit.SCOPE_NETWORK, Name: config.Conf.IRC.Server},
+	}
+	if user != "" {
+		keys = append(keys, ratelimit.Key{Scope: ratelimit.SCOPE_USER, Name: string(user)})
+	}
+
+	return keys
+}
+
+// join follows channel for user. It is kept for clients which do not
+// use follows endpoint
+func join(_ martini.Params, cr ChannelRequest, e *audit.Entry, r render.Render, log logger.Logger, user auth.User) {
+	e.Describe(cr.Nickname, cr.Name, "", "")
 	valid, errs := validator.Validate(cr)
 	if !valid {
 		errors := parseValidatorErrors(errs)
@@ -142,28 +1084,241 @@ func join(_ martini.Params, cr ChannelRequest, r render.Render) {
 		return
 	}
 
-	s := client.NewSubscriber(&config.Conf.Redis)
-	if err := s.Subscribe(cr.Name); err != nil {
+	fr := FollowRequest{Channel: cr.Name, Nickname: cr.Nickname}
+	if _, err := followFor(user, &fr, log); err != nil {
+		fail(r, err)
+		return
+	}
+
+	success(r)
+}
+
+// listFollows responds with channels followed by user
+func listFollows(r render.Render, log logger.Logger, user auth.User) {
+	list, err := follows.List(string(user))
+	if err != nil {
+		log.Error("Could not list follows: %s", err)
+		fail(r, client.ErrInternal)
+		return
+	}
+
+	r.JSON(200, list)
+}
+
+// followChannel follows channel for user, and responds with the follow
+func followChannel(fr FollowRequest, r render.Render, log logger.Logger, user auth.User) {
+	f, err := followFor(user, &fr, log)
+	if err != nil {
+		fail(r, err)
+		return
+	}
+
+	r.JSON(200, f)
+}
+
+// unfollowChannel removes channel from follows of user
+func unfollowChannel(params martini.Params, r render.Render, log logger.Logger, user auth.User) {
+	log = log.New("channel", params["channel"])
+	if err := follows.Unfollow(string(user), params["channel"]); err != nil {
+		if err != follow.ErrNotFound {
+			log.Error("Could not unfollow channel: %s", err)
+			err = client.ErrInternal
+		}
+		fail(r, err)
+		return
+	}
+
+	if err := readers.Remove(string(user), params["channel"]); err != nil {
+		log.Warn("Could not remove unread counts: %s", err)
+	}
+
+	success(r)
+}
+
+// followFor follows channel for user. Unread messages of new and
+// unmuted channels are counted from now on
+func followFor(user auth.User, fr *FollowRequest, log logger.Logger) (*follow.Follow, error) {
+	if fr.Nickname != "" {
+		if err := authorize(user, fr.Nickname); err != nil {
+			log.New("nickname", fr.Nickname).Warn("%s is not allowed to use nickname", user)
+			return nil, err
+		}
+	}
+
+	if err := formatting.Validate(fr.Settings.Format); err != nil {
+		return nil, err
+	}
+
+	log = log.New("channel", fr.Channel)
+	existing, err := follows.Get(string(user), fr.Channel)
+	if err != nil && err != follow.ErrNotFound {
+		log.Error("Could not get follow: %s", err)
+		return nil, client.ErrInternal
+	}
+
+	f, err := follows.Follow(string(user), fr.Channel, fr.Settings)
+	if err == common.ErrChannelNotSet {
+		return nil, err
+	}
+
+	if err != nil {
+		log.Error("Could not follow channel: %s", err)
+		return nil, client.ErrInternal
+	}
+
+	switch {
+	case f.Settings.Muted:
+		err = readers.Remove(string(user), f.Channel)
+	case existing == nil || existing.Settings.Muted:
+		err = readers.MarkRead(string(user), fr.Nickname, f.Channel, &unread.Marker{})
+	}
+	if err != nil {
+		log.Warn("Could not update unread counts: %s", err)
+	}
+
+	return f, nil
+}
+
+// setTopic sets topic of channel
+func setTopic(params martini.Params, mr ModerationRequest, e *audit.Entry, r render.Render, log logger.Logger,
+	user auth.User) {
+	e.Describe(mr.Nickname, params["channel"], "", mr.Topic)
+	moderate(params["channel"], &mr, r, log, user, func(conn *client.Connection, channel string) error {
+		return conn.Topic(channel, mr.Topic)
+	})
+}
+
+// kickUser removes target from channel
+func kickUser(params martini.Params, mr ModerationRequest, e *audit.Entry, r render.Render, log logger.Logger,
+	user auth.User) {
+	e.Describe(mr.Nickname, params["channel"], mr.Target, mr.Reason)
+	moderate(params["channel"], &mr, r, log, user, func(conn *client.Connection, channel string) error {
+		return conn.Kick(channel, mr.Target, mr.Reason)
+	})
+}
+
+// banMask adds mask to ban list of channel
+func banMask(params martini.Params, mr ModerationRequest, e *audit.Entry, r render.Render, log logger.Logger,
+	user auth.User) {
+	e.Describe(mr.Nickname, params["channel"], mr.Mask, "")
+	moderate(params["channel"], &mr, r, log, user, func(conn *client.Connection, channel string) error {
+		return conn.Ban(channel, mr.Mask)
+	})
+}
+
+// unbanMask removes mask from ban list of channel
+func unbanMask(params martini.Params, mr ModerationRequest, e *audit.Entry, r render.Render, log logger.Logger,
+	user auth.User) {
+	e.Describe(mr.Nickname, params["channel"], mr.Mask, "")
+	moderate(params["channel"], &mr, r, log, user, func(conn *client.Connection, channel string) error {
+		return conn.Unban(channel, mr.Mask)
+	})
+}
+
+// setMode sets channel modes
+func setMode(params martini.Params, mr ModerationRequest, e *audit.Entry, r render.Render, log logger.Logger,
+	user auth.User) {
+	e.Describe(mr.Nickname, params["channel"], strings.Join(append([]string{mr.Modes}, mr.Args...), " "), "")
+	moderate(params["channel"], &mr, r, log, user, func(conn *client.Connection, channel string) error {
+		return conn.Mode(channel, mr.Modes, mr.Args...)
+	})
+}
+
+// inviteUser invites target to channel
+func inviteUser(params martini.Params, mr ModerationRequest, e *audit.Entry, r render.Render, log logger.Logger,
+	user auth.User) {
+	e.Describe(mr.Nickname, params["channel"], mr.Target, "")
+	moderate(params["channel"], &mr, r, log, user, func(conn *client.Connection, channel string) error {
+		return conn.Invite(channel, mr.Target)
+	})
+}
+
+// moderate runs operation via the connection of requested nickname.
+// Irc error replies of the operation are responded as errors
+func moderate(channel string, mr *ModerationRequest, r render.Render, log logger.Logger, user auth.User,
+	operation func(conn *client.Connection, channel string) error) {
+	log = log.New("nickname", mr.Nickname).New("channel", channel)
+	if err := authorize(user, mr.Nickname); err != nil {
+		log.Warn("%s is not allowed to use nickname", user)
+		fail(r, err)
+		return
+	}
+
+	conn, err := Connect(mr.Nickname)
+	if err != nil {
+		log.Warn("Could not connect: %s", err)
+		fail(r, err)
+		return
+	}
+
+	if err := operation(conn, channel); err != nil {
+		log.Info("Could not moderate channel: %s", err)
 		fail(r, err)
+		return
 	}
 
 	success(r)
 }
 
+// Connect returns the connection of nickname, and connects it when it is
+// not connected yet. Connections of a nickname are established one at a
+// time, so that its concurrent requests share the same connection, and
+// other nicknames are not blocked while connecting
 func Connect(nickname string) (*client.Connection, error) {
+	l := connLock(nickname)
+	l.Lock()
+	defer l.Unlock()
+
+	connMu.Lock()
 	conn, ok := connMap[nickname]
+	connMu.Unlock()
 	if ok {
 		return conn, nil
 	}
 
+	// connections outlive requests, so they log without request fields
 	conn = client.NewConnection()
 	conn.Nickname = nickname
 	conn.Server = config.Conf.IRC.Server
+	conn.Buffer = config.Conf.Connection
+	conn.Message = config.Conf.Message
+	conn.Encodings = config.Conf.Encoding
+	conn.Capabilities = config.Conf.IRC.Capability
+	if pastes != nil {
+		conn.Paste = pastes
+	}
+	connLog := logger.New("api").New("nickname", nickname)
+	conn.Delivery = func(id, status, reason string) {
+		if err := tracker.Update(id, status, reason); err != nil {
+			connLog.New("id", id).Warn("Could not update delivery status: %s", err)
+		}
+	}
+	conn.Sent = func(id, line string) {
+		if err := tracker.Sent(id, line); err != nil {
+			connLog.New("id", id).Warn("Could not update delivery status: %s", err)
+		}
+	}
 	if err := conn.Connect(); err != nil {
 		return nil, err
 	}
 
+	connMu.Lock()
 	connMap[nickname] = conn
+	connMu.Unlock()
 
 	return conn, nil
 }
+
+// connLock returns the connection lock of nickname
+func connLock(nickname string) *sync.Mutex {
+	connMu.Lock()
+	defer connMu.Unlock()
+
+	l, ok := connLocks[nickname]
+	if !ok {
+		l = new(sync.Mutex)
+		connLocks[nickname] = l
+	}
+
+	return l
+}
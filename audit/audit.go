@@ -0,0 +1,218 @@
+// Package audit records irc actions initiated via the api.
+//
+// Entries are stored in a redis sorted set scored by an increasing
+// sequence number. Stored entries are not changed, but the oldest ones
+// are removed when the log exceeds AUDIT_SIZE entries. Queries page by
+// sequence numbers, so appended and removed entries do not shift pages.
+// Message bodies and other payloads are not stored, only their sha256
+// hashes are kept for matching them with known payloads.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"gopkg.in/redis.v2"
+)
+
+const (
+	// sorted set of entries scored by sequence numbers
+	AUDIT_KEY = "audit"
+
+	// sequence number of the last entry
+	AUDIT_SEQ_KEY = "audit:seq"
+
+	// maximum number of stored entries
+	AUDIT_SIZE = 100000
+
+	ACTION_SEND_MESSAGE = "sendMessage"
+	ACTION_JOIN         = "join"
+	ACTION_HOOK         = "hook"
+	ACTION_TOPIC        = "topic"
+	ACTION_KICK         = "kick"
+	ACTION_BAN          = "ban"
+	ACTION_UNBAN        = "unban"
+	ACTION_MODE         = "mode"
+	ACTION_INVITE       = "invite"
+
+	RESULT_SUCCESS  = "success"
+	RESULT_ACCEPTED = "accepted"
+	RESULT_FAILURE  = "failure"
+
+	// number of entries returned when query limit is not set
+	DEFAULT_LIMIT = 100
+
+	// maximum number of entries returned by a query
+	MAX_LIMIT = 1000
+
+	// number of entries read from redis at once while querying
+	QUERY_PAGE = 500
+)
+
+// Entry is an api request which initiates an irc action
+type Entry struct {
+	ID string `json:"id"`
+	// User is the authenticated api user. Owners of incoming webhooks
+	// are used for webhook messages. It is empty for requests rejected
+	// by authentication
+	User     string `json:"user"`
+	Nickname string `json:"nickname,omitempty"`
+	Action   string `json:"action"`
+	Channel  string `json:"channel,omitempty"`
+	// Target is the nickname or mask of moderation actions
+	Target      string `json:"target,omitempty"`
+	PayloadHash string `json:"payloadHash,omitempty"`
+	// Status is the http status code of the response
+	Status    int       `json:"status"`
+	Result    string    `json:"result"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Describe sets the irc side of the action. Payload is hashed when it
+// is not empty
+func (e *Entry) Describe(nickname, channel, target, payload string) {
+	e.Nickname = nickname
+	e.Channel = common.NormalizeChannel(channel)
+	e.Target = target
+	if payload != "" {
+		e.PayloadHash = Hash(payload)
+	}
+}
+
+// Hash returns hex encoded sha256 hash of payload
+func Hash(payload string) string {
+	sum := sha256.Sum256([]byte(payload))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// ResultOf returns the result of an http status code
+func ResultOf(status int) string {
+	switch {
+	case status == 202:
+		return RESULT_ACCEPTED
+	case status >= 200 && status < 300:
+		return RESULT_SUCCESS
+	default:
+		return RESULT_FAILURE
+	}
+}
+
+// Query filters entries. Empty fields match all entries
+type Query struct {
+	User     string
+	Nickname string
+	Action   string
+	Channel  string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// Match checks whether entry satisfies all conditions of the query
+func (q *Query) Match(e *Entry) bool {
+	switch {
+	case q.User != "" && q.User != e.User:
+		return false
+	case q.Nickname != "" && !strings.EqualFold(q.Nickname, e.Nickname):
+		return false
+	case q.Action != "" && q.Action != e.Action:
+		return false
+	case q.Channel != "" && common.NormalizeChannel(q.Channel) != e.Channel:
+		return false
+	case !q.Since.IsZero() && e.CreatedAt.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && e.CreatedAt.After(q.Until):
+		return false
+	}
+
+	return true
+}
+
+// Log keeps entries in redis
+type Log struct {
+	redisConn *redis.Client
+}
+
+// NewLog creates an audit log with the given redis connection
+func NewLog(r *redis.Client) *Log {
+	return &Log{redisConn: r}
+}
+
+// Record appends entry to the log. Id, result and creation time are
+// set. Oldest entries are removed when log exceeds AUDIT_SIZE
+func (l *Log) Record(e *Entry) error {
+	e.ID = common.NewID(8)
+	e.Result = ResultOf(e.Status)
+	e.CreatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	seq, err := l.redisConn.Incr(common.KeyWithPrefix(AUDIT_SEQ_KEY)).Result()
+	if err != nil {
+		return err
+	}
+
+	_, err = l.redisConn.Pipelined(func(p *redis.Pipeline) error {
+		p.ZAdd(common.KeyWithPrefix(AUDIT_KEY), redis.Z{Score: float64(seq), Member: string(data)})
+		p.ZRemRangeByRank(common.KeyWithPrefix(AUDIT_KEY), 0, -AUDIT_SIZE-1)
+
+		return nil
+	})
+
+	return err
+}
+
+// Query returns entries matching q, newest appended first. Api processes
+// append entries with their own clocks, so creation times are not in
+// order and the whole log is read for time ranges
+func (l *Log) Query(q *Query) ([]*Entry, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DEFAULT_LIMIT
+	}
+	if limit > MAX_LIMIT {
+		limit = MAX_LIMIT
+	}
+
+	key := common.KeyWithPrefix(AUDIT_KEY)
+	entries := make([]*Entry, 0)
+	// pages are read below the sequence number of the last read entry
+	opt := redis.ZRangeByScore{Max: "+inf", Min: "-inf", Count: QUERY_PAGE}
+	for {
+		page, err := l.redisConn.ZRevRangeByScoreWithScores(key, opt).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, z := range page {
+			e := new(Entry)
+			if err := json.Unmarshal([]byte(z.Member), e); err != nil {
+				return nil, err
+			}
+
+			if !q.Match(e) {
+				continue
+			}
+
+			entries = append(entries, e)
+			if len(entries) == limit {
+				return entries, nil
+			}
+		}
+
+		if len(page) < QUERY_PAGE {
+			return entries, nil
+		}
+
+		opt.Max = "(" + strconv.FormatFloat(page[len(page)-1].Score, 'f', -1, 64)
+	}
+}
@@ -0,0 +1,128 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"gopkg.in/redis.v2"
+)
+
+func TestMatch(t *testing.T) {
+	now := time.Now()
+	e := &Entry{User: "muppets", Nickname: "Kermit", Action: ACTION_KICK, Channel: "muppets", CreatedAt: now}
+
+	tests := []struct {
+		q     Query
+		match bool
+	}{
+		{Query{}, true},
+		{Query{User: "muppets", Nickname: "kermit", Channel: "#Muppets"}, true},
+		{Query{User: "swedish"}, false},
+		{Query{Action: ACTION_BAN}, false},
+		{Query{Since: now.Add(-time.Minute), Until: now.Add(time.Minute)}, true},
+		{Query{Since: now.Add(time.Minute)}, false},
+		{Query{Until: now.Add(-time.Minute)}, false},
+	}
+
+	for i, test := range tests {
+		if match := test.q.Match(e); match != test.match {
+			t.Errorf("Expected %t but got %t for query %d", test.match, match, i)
+		}
+	}
+}
+
+func TestResultOf(t *testing.T) {
+	tests := map[int]string{
+		200: RESULT_SUCCESS,
+		202: RESULT_ACCEPTED,
+		403: RESULT_FAILURE,
+		500: RESULT_FAILURE,
+	}
+
+	for status, expected := range tests {
+		if result := ResultOf(status); result != expected {
+			t.Errorf("Expected %s but got %s for %d", expected, result, status)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	e := new(Entry)
+	e.Describe("kermit", "#Muppets", "", "hello")
+
+	if e.Channel != "muppets" {
+		t.Errorf("Expected muppets but got %s", e.Channel)
+	}
+
+	expected := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if e.PayloadHash != expected {
+		t.Errorf("Expected %s but got %s", expected, e.PayloadHash)
+	}
+}
+
+func TestLog(t *testing.T) {
+	conf := &common.RedisConf{Server: "localhost", Port: "6379", DB: 3}
+	if env := os.Getenv("REDIS_HOST"); env != "" {
+		conf.Server = env
+	}
+
+	if env := os.Getenv("REDIS_PORT"); env != "" {
+		conf.Port = env
+	}
+
+	redisConn := common.NewRedis(conf)
+	defer redisConn.Close()
+	defer redisConn.Del(common.KeyWithPrefix(AUDIT_KEY), common.KeyWithPrefix(AUDIT_SEQ_KEY))
+
+	l := NewLog(redisConn)
+	entries := []*Entry{
+		{User: "muppets", Nickname: "kermit", Action: ACTION_SEND_MESSAGE, Channel: "muppets", Status: 200},
+		{User: "muppets", Nickname: "piggy", Action: ACTION_KICK, Channel: "muppets", Target: "fozzie", Status: 403},
+		{User: "muppets", Nickname: "kermit", Action: ACTION_JOIN, Channel: "kitchen", Status: 200},
+	}
+	for _, e := range entries {
+		if err := l.Record(e); err != nil {
+			t.Fatalf("Expected nil but got %s", err)
+		}
+	}
+
+	res, err := l.Query(&Query{Nickname: "kermit"})
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if len(res) != 2 {
+		t.Fatalf("Expected 2 but got %d", len(res))
+	}
+
+	if res[0].Action != ACTION_JOIN {
+		t.Errorf("Expected %s but got %s", ACTION_JOIN, res[0].Action)
+	}
+
+	res, err = l.Query(&Query{Limit: 1, Channel: "muppets"})
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if len(res) != 1 || res[0].Result != RESULT_FAILURE {
+		t.Errorf("Expected failed kick but got %v", res)
+	}
+
+	// entries of another process may be appended with an earlier time
+	late, _ := json.Marshal(&Entry{ID: "late", User: "muppets", Action: ACTION_JOIN,
+		CreatedAt: time.Now().UTC().Add(-time.Hour)})
+	seq := redisConn.Incr(common.KeyWithPrefix(AUDIT_SEQ_KEY)).Val()
+	redisConn.ZAdd(common.KeyWithPrefix(AUDIT_KEY), redis.Z{Score: float64(seq), Member: string(late)})
+
+	res, err = l.Query(&Query{Since: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if len(res) != len(entries) {
+		t.Errorf("Expected %d but got %d", len(entries), len(res))
+	}
+}
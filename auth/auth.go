@@ -0,0 +1,172 @@
+// Package auth authenticates api requests and binds authenticated
+// application users to the irc nicknames they are allowed to use.
+//
+// Credentials are read from "Authorization: Bearer <credential>" header,
+// or from "X-Api-Key" header. Supported authentication modes are
+//
+// static: credentials are api keys, each configured as "user:key"
+//
+// hmac: credentials are tokens signed with a shared secret (see HMACTokens)
+//
+// jwt: credentials are HS256 signed json web tokens, and "sub" claim
+// holds the user
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	MODE_NONE   = "none"
+	MODE_STATIC = "static"
+	MODE_HMAC   = "hmac"
+	MODE_JWT    = "jwt"
+
+	// wildcard nickname allows users to use any nickname
+	ANY_NICKNAME = "*"
+)
+
+var (
+	ErrUnauthorized  = errors.New("unauthorized")
+	ErrForbidden     = errors.New("forbidden")
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrTokenExpired  = errors.New("token expired")
+	ErrInvalidMode   = errors.New("invalid authentication mode")
+	ErrInvalidKey    = errors.New("invalid api key definition")
+	ErrSecretNotSet  = errors.New("secret not set")
+	ErrKeyNotDefined = errors.New("api key not defined")
+)
+
+// User is the authenticated application user
+type User string
+
+// Authenticator returns the application user of a request
+type Authenticator interface {
+	Authenticate(req *http.Request) (User, error)
+}
+
+// Conf holds authentication settings
+type Conf struct {
+	Mode string
+	// api keys in user:key format, used in static mode
+	Key []string
+	// shared secret used in hmac and jwt modes
+	Secret string
+	// users allowed to use admin endpoints
+	Admin []string
+}
+
+// IsAdmin checks whether user is a configured admin
+func (c *Conf) IsAdmin(user User) bool {
+	for _, admin := range c.Admin {
+		if admin == string(user) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IdentityConf holds nicknames of an application user
+type IdentityConf struct {
+	Nickname []string
+}
+
+// New creates authenticator of the configured mode. It returns nil
+// authenticator when authentication is disabled
+func New(c *Conf) (Authenticator, error) {
+	switch c.Mode {
+	case "", MODE_NONE:
+		return nil, nil
+	case MODE_STATIC:
+		return NewStaticKeys(c.Key)
+	case MODE_HMAC:
+		if c.Secret == "" {
+			return nil, ErrSecretNotSet
+		}
+		return &HMACTokens{Secret: []byte(c.Secret)}, nil
+	case MODE_JWT:
+		if c.Secret == "" {
+			return nil, ErrSecretNotSet
+		}
+		return &JWT{Key: []byte(c.Secret)}, nil
+	default:
+		return nil, ErrInvalidMode
+	}
+}
+
+// credential returns the credential of request
+func credential(req *http.Request) string {
+	if h := req.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+
+	return req.Header.Get("X-Api-Key")
+}
+
+// StaticKeys authenticates requests with pre-shared api keys
+type StaticKeys map[string]User
+
+// NewStaticKeys creates static keys from user:key definitions
+func NewStaticKeys(defs []string) (StaticKeys, error) {
+	if len(defs) == 0 {
+		return nil, ErrKeyNotDefined
+	}
+
+	keys := make(StaticKeys)
+	for _, def := range defs {
+		parts := strings.SplitN(def, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, ErrInvalidKey
+		}
+		keys[parts[1]] = User(parts[0])
+	}
+
+	return keys, nil
+}
+
+func (s StaticKeys) Authenticate(req *http.Request) (User, error) {
+	key := credential(req)
+	if key == "" {
+		return "", ErrUnauthorized
+	}
+
+	// keys are compared in constant time for preventing timing attacks
+	for k, user := range s {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return user, nil
+		}
+	}
+
+	return "", ErrUnauthorized
+}
+
+// Bindings holds nicknames of each application user
+type Bindings map[User][]string
+
+// NewBindings creates bindings from identity settings keyed by user
+func NewBindings(identities map[string]*IdentityConf) Bindings {
+	b := make(Bindings)
+	for user, identity := range identities {
+		if identity == nil {
+			continue
+		}
+		b[User(user)] = identity.Nickname
+	}
+
+	return b
+}
+
+// Allowed checks whether user is allowed to use nickname
+func (b Bindings) Allowed(user User, nickname string) bool {
+	for _, n := range b[user] {
+		if n == ANY_NICKNAME || strings.EqualFold(n, nickname) {
+			return true
+		}
+	}
+
+	return false
+}
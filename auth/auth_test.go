@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func request(credential string) *http.Request {
+	req, _ := http.NewRequest("POST", "/sendMessage", nil)
+	if credential != "" {
+		req.Header.Set("Authorization", "Bearer "+credential)
+	}
+
+	return req
+}
+
+func signJWT(key []byte, header, claims string) string {
+	enc := base64.RawURLEncoding
+	payload := enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString([]byte(claims))
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(payload))
+
+	return payload + "." + enc.EncodeToString(m.Sum(nil))
+}
+
+func TestNew(t *testing.T) {
+	a, err := New(&Conf{Mode: MODE_NONE})
+	if a != nil || err != nil {
+		t.Errorf("Expected nil authenticator but got %v, %v", a, err)
+	}
+
+	if _, err := New(&Conf{Mode: "kerberos"}); err != ErrInvalidMode {
+		t.Errorf("Expected %s but got %s", ErrInvalidMode, err)
+	}
+
+	if _, err := New(&Conf{Mode: MODE_JWT}); err != ErrSecretNotSet {
+		t.Errorf("Expected %s but got %s", ErrSecretNotSet, err)
+	}
+
+	if _, err := New(&Conf{Mode: MODE_STATIC, Key: []string{"kermit"}}); err != ErrInvalidKey {
+		t.Errorf("Expected %s but got %s", ErrInvalidKey, err)
+	}
+}
+
+func TestStaticKeys(t *testing.T) {
+	a, err := New(&Conf{Mode: MODE_STATIC, Key: []string{"kermit:frog", "piggy:pig"}})
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	user, err := a.Authenticate(request("pig"))
+	if err != nil || user != "piggy" {
+		t.Errorf("Expected piggy but got %s, %v", user, err)
+	}
+
+	req := request("")
+	req.Header.Set("X-Api-Key", "frog")
+	user, err = a.Authenticate(req)
+	if err != nil || user != "kermit" {
+		t.Errorf("Expected kermit but got %s, %v", user, err)
+	}
+
+	if _, err := a.Authenticate(request("bear")); err != ErrUnauthorized {
+		t.Errorf("Expected %s but got %s", ErrUnauthorized, err)
+	}
+
+	if _, err := a.Authenticate(request("")); err != ErrUnauthorized {
+		t.Errorf("Expected %s but got %s", ErrUnauthorized, err)
+	}
+}
+
+func TestHMACTokens(t *testing.T) {
+	h := &HMACTokens{Secret: []byte("muppets")}
+
+	token := h.Sign("gonzo:the-great", time.Now().Add(time.Hour))
+	user, err := h.Authenticate(request(token))
+	if err != nil || user != "gonzo:the-great" {
+		t.Errorf("Expected gonzo:the-great but got %s, %v", user, err)
+	}
+
+	token = h.Sign("gonzo", time.Now().Add(-time.Hour))
+	if _, err := h.Authenticate(request(token)); err != ErrTokenExpired {
+		t.Errorf("Expected %s but got %s", ErrTokenExpired, err)
+	}
+
+	forged := (&HMACTokens{Secret: []byte("sesame")}).Sign("gonzo", time.Now().Add(time.Hour))
+	if _, err := h.Authenticate(request(forged)); err != ErrInvalidToken {
+		t.Errorf("Expected %s but got %s", ErrInvalidToken, err)
+	}
+}
+
+func TestJWT(t *testing.T) {
+	key := []byte("muppets")
+	j := &JWT{Key: key}
+	header := `{"alg":"HS256","typ":"JWT"}`
+
+	token := signJWT(key, header, `{"sub":"fozzie","exp":4102444800}`)
+	user, err := j.Authenticate(request(token))
+	if err != nil || user != "fozzie" {
+		t.Errorf("Expected fozzie but got %s, %v", user, err)
+	}
+
+	token = signJWT(key, header, `{"sub":"fozzie","exp":946684800}`)
+	if _, err := j.Authenticate(request(token)); err != ErrTokenExpired {
+		t.Errorf("Expected %s but got %s", ErrTokenExpired, err)
+	}
+
+	token = signJWT([]byte("sesame"), header, `{"sub":"fozzie"}`)
+	if _, err := j.Authenticate(request(token)); err != ErrInvalidToken {
+		t.Errorf("Expected %s but got %s", ErrInvalidToken, err)
+	}
+
+	token = signJWT(key, `{"alg":"none"}`, `{"sub":"fozzie"}`)
+	if _, err := j.Authenticate(request(token)); err != ErrInvalidToken {
+		t.Errorf("Expected %s but got %s", ErrInvalidToken, err)
+	}
+}
+
+func TestBindings(t *testing.T) {
+	b := NewBindings(map[string]*IdentityConf{
+		"kermit": {Nickname: []string{"kermit", "kermit_"}},
+		"admin":  {Nickname: []string{ANY_NICKNAME}},
+	})
+
+	if !b.Allowed("kermit", "Kermit_") {
+		t.Error("Expected kermit to be allowed to use Kermit_")
+	}
+
+	if b.Allowed("kermit", "piggy") {
+		t.Error("Expected kermit not to be allowed to use piggy")
+	}
+
+	if !b.Allowed("admin", "piggy") {
+		t.Error("Expected admin to be allowed to use piggy")
+	}
+
+	if b.Allowed("animal", "animal") {
+		t.Error("Expected unknown user not to be allowed")
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	c := &Conf{Admin: []string{"kermit"}}
+
+	if !c.IsAdmin("kermit") {
+		t.Error("Expected kermit to be admin")
+	}
+
+	if c.IsAdmin("piggy") {
+		t.Error("Expected piggy not to be admin")
+	}
+}
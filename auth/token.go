@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HMACTokens authenticates requests with tokens in
+// base64url(user:expiry).hex(hmac-sha256) format. Expiry is a unix
+// timestamp, and hmac is computed over the encoded part.
+type HMACTokens struct {
+	Secret []byte
+}
+
+// Sign creates a token of user valid until given expiry
+func (h *HMACTokens) Sign(user User, expiry time.Time) string {
+	payload := base64.URLEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%s:%d", user, expiry.Unix())))
+
+	return payload + "." + hex.EncodeToString(h.mac(payload))
+}
+
+func (h *HMACTokens) Authenticate(req *http.Request) (User, error) {
+	token := credential(req)
+	if token == "" {
+		return "", ErrUnauthorized
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", ErrInvalidToken
+	}
+
+	sig, err := hex.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, h.mac(parts[0])) {
+		return "", ErrInvalidToken
+	}
+
+	data, err := base64.URLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	// user names can contain colons, so expiry is read after the last one
+	payload := string(data)
+	idx := strings.LastIndex(payload, ":")
+	if idx < 1 {
+		return "", ErrInvalidToken
+	}
+
+	expiry, err := strconv.ParseInt(payload[idx+1:], 10, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if time.Now().Unix() > expiry {
+		return "", ErrTokenExpired
+	}
+
+	return User(payload[:idx]), nil
+}
+
+func (h *HMACTokens) mac(payload string) []byte {
+	m := hmac.New(sha256.New, h.Secret)
+	m.Write([]byte(payload))
+
+	return m.Sum(nil)
+}
+
+// JWT authenticates requests with HS256 signed json web tokens.
+// Subject claim is used as user, and exp and nbf claims are validated
+// when they exist.
+type JWT struct {
+	Key []byte
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+}
+
+func (j *JWT) Authenticate(req *http.Request) (User, error) {
+	token := credential(req)
+	if token == "" {
+		return "", ErrUnauthorized
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidToken
+	}
+
+	header := jwtHeader{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", ErrInvalidToken
+	}
+
+	// only HS256 is supported. checking algorithm prevents "none"
+	// algorithm tokens from being accepted
+	if header.Alg != "HS256" {
+		return "", ErrInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	m := hmac.New(sha256.New, j.Key)
+	m.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, m.Sum(nil)) {
+		return "", ErrInvalidToken
+	}
+
+	claims := jwtClaims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", ErrInvalidToken
+	}
+
+	now := time.Now().Unix()
+	if claims.ExpiresAt != 0 && now > claims.ExpiresAt {
+		return "", ErrTokenExpired
+	}
+
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return "", ErrInvalidToken
+	}
+
+	if claims.Subject == "" {
+		return "", ErrInvalidToken
+	}
+
+	return User(claims.Subject), nil
+}
+
+// decodeSegment decodes base64url encoded json segment of a token
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,325 @@
+// Package chanlog writes channel events to log files on disk.
+//
+// Each channel has a log file per day, and events are written in irssi
+// like text format, as json lines, or both. Files of previous days are
+// gzip compressed, and files older than the retention period are removed
+// while maintaining the log directory. Dates are in UTC.
+package chanlog
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+
+	// log files are placed under a directory per channel by default
+	DEFAULT_LAYOUT = "{channel}/{date}"
+
+	DATE_FORMAT = "2006-01-02"
+)
+
+var (
+	ErrDirNotSet     = errors.New("channel log directory not set")
+	ErrInvalidFormat = errors.New("invalid channel log format")
+	ErrInvalidLayout = errors.New("invalid channel log layout")
+	ErrInvalidDays   = errors.New("invalid retention days")
+)
+
+// extensions of log files by format
+var extensions = map[string]string{
+	FORMAT_TEXT: ".log",
+	FORMAT_JSON: ".jsonl",
+}
+
+// Validate checks directory, formats, layout and retention days
+func Validate(c *common.ChanLogConf) error {
+	if c.Dir == "" {
+		return ErrDirNotSet
+	}
+
+	for _, format := range c.Format {
+		if _, ok := extensions[format]; !ok {
+			return ErrInvalidFormat
+		}
+	}
+
+	layout := c.Layout
+	if layout == "" {
+		layout = DEFAULT_LAYOUT
+	}
+
+	if !strings.Contains(layout, "{channel}") ||
+		!(strings.Contains(layout, "{date}") || strings.Contains(layout, "{day}")) ||
+		filepath.IsAbs(layout) || strings.Contains(layout, "..") {
+		return ErrInvalidLayout
+	}
+
+	if c.Retention < 0 {
+		return ErrInvalidDays
+	}
+
+	return nil
+}
+
+// logFile is an open log file of a channel
+type logFile struct {
+	file *os.File
+	date string
+}
+
+// Writer writes channel events to the daily log files
+type Writer struct {
+	conf common.ChanLogConf
+
+	mu sync.Mutex
+	// open files by their paths
+	files map[string]*logFile
+
+	// used for mocking time in tests
+	now func() time.Time
+}
+
+// NewWriter validates settings and creates log directory
+func NewWriter(c *common.ChanLogConf) (*Writer, error) {
+	if err := Validate(c); err != nil {
+		return nil, err
+	}
+
+	conf := *c
+	if len(conf.Format) == 0 {
+		conf.Format = []string{FORMAT_TEXT}
+	}
+
+	if conf.Layout == "" {
+		conf.Layout = DEFAULT_LAYOUT
+	}
+
+	if err := os.MkdirAll(conf.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Writer{conf: conf, files: make(map[string]*logFile), now: time.Now}, nil
+}
+
+// Message writes a received channel message
+func (w *Writer) Message(m common.Message) error {
+	return w.Event(common.MessageEvent(m))
+}
+
+// Event writes event into the log files of its channel. Files of the
+// previous day are closed when the day changes
+func (w *Writer) Event(e common.Event) error {
+	if e.Time.IsZero() {
+		e.Time = w.now()
+	}
+	e.Time = e.Time.UTC()
+	e.Channel = logChannel(e.Channel)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, format := range w.conf.Format {
+		var line []byte
+		switch format {
+		case FORMAT_TEXT:
+			line = []byte(Text(e) + "\n")
+		case FORMAT_JSON:
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			line = append(data, '\n')
+		}
+
+		f, err := w.open(e, format)
+		if err != nil {
+			return err
+		}
+
+		if _, err := f.Write(line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// open returns the log file of event in format. Files are selected by
+// the write time, so that history messages do not reopen previous days.
+// It must be called with lock held
+func (w *Writer) open(e common.Event, format string) (*os.File, error) {
+	now := w.now().UTC()
+	date := now.Format(DATE_FORMAT)
+	path := w.path(e.Channel, now) + extensions[format]
+
+	if lf, ok := w.files[path]; ok && lf.date == date {
+		return lf.file, nil
+	}
+
+	// files of the previous days are closed
+	for p, lf := range w.files {
+		if lf.date != date {
+			lf.file.Close()
+			delete(w.files, p)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w.files[path] = &logFile{file: file, date: date}
+
+	if format == FORMAT_TEXT {
+		if _, err := io.WriteString(file, "--- Log opened "+now.Format(time.ANSIC)+"\n"); err != nil {
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+// path returns the log file path of channel at t without extension
+func (w *Writer) path(channel string, t time.Time) string {
+	r := strings.NewReplacer(
+		"{channel}", channel,
+		"{date}", t.Format(DATE_FORMAT),
+		"{year}", t.Format("2006"),
+		"{month}", t.Format("01"),
+		"{day}", t.Format("02"),
+	)
+
+	return filepath.Join(w.conf.Dir, filepath.FromSlash(r.Replace(w.conf.Layout)))
+}
+
+// Maintain compresses log files of previous days when compression is
+// enabled, and removes the files older than retention days
+func (w *Writer) Maintain() error {
+	w.mu.Lock()
+	open := make(map[string]bool)
+	for path := range w.files {
+		open[path] = true
+	}
+	w.mu.Unlock()
+
+	today := w.now().UTC().Truncate(24 * time.Hour)
+	expiry := today.AddDate(0, 0, -w.conf.Retention)
+
+	return filepath.Walk(w.conf.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || open[path] || !isLog(path) {
+			return nil
+		}
+
+		if w.conf.Retention > 0 && info.ModTime().Before(expiry) {
+			return os.Remove(path)
+		}
+
+		if w.conf.Compress && !strings.HasSuffix(path, ".gz") && info.ModTime().Before(today) {
+			return compress(path)
+		}
+
+		return nil
+	})
+}
+
+// Close closes open log files
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var err error
+	for path, lf := range w.files {
+		if cerr := lf.file.Close(); cerr != nil {
+			err = cerr
+		}
+		delete(w.files, path)
+	}
+
+	return err
+}
+
+// isLog checks whether path is a plain or compressed log file
+func isLog(path string) bool {
+	path = strings.TrimSuffix(path, ".gz")
+	for _, ext := range extensions {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compress replaces file with its gzip compressed copy. Modification
+// time is kept for retention
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(dst.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// logChannel returns the normalized channel name used in log paths. Path
+// separators and leading dots are replaced
+func logChannel(channel string) string {
+	channel = strings.NewReplacer("/", "_", "\\", "_").Replace(common.NormalizeChannel(channel))
+	if channel == "" || strings.HasPrefix(channel, ".") {
+		channel = "_" + channel
+	}
+
+	return channel
+}
@@ -0,0 +1,154 @@
+package chanlog
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		conf common.ChanLogConf
+		err  error
+	}{
+		{common.ChanLogConf{Dir: "/tmp"}, nil},
+		{common.ChanLogConf{}, ErrDirNotSet},
+		{common.ChanLogConf{Dir: "/tmp", Format: []string{FORMAT_TEXT, "html"}}, ErrInvalidFormat},
+		{common.ChanLogConf{Dir: "/tmp", Layout: "{year}/{month}/{channel}-{day}"}, nil},
+		{common.ChanLogConf{Dir: "/tmp", Layout: "{channel}"}, ErrInvalidLayout},
+		{common.ChanLogConf{Dir: "/tmp", Layout: "../{channel}/{date}"}, ErrInvalidLayout},
+		{common.ChanLogConf{Dir: "/tmp", Retention: -1}, ErrInvalidDays},
+	}
+
+	for _, test := range tests {
+		if err := Validate(&test.conf); err != test.err {
+			t.Errorf("Expected %v but got %v", test.err, err)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	tm := time.Date(2015, 3, 1, 9, 5, 0, 0, time.UTC)
+	tests := []struct {
+		e        common.Event
+		expected string
+	}{
+		{common.Event{Type: common.EVENT_MESSAGE, Nickname: "kermit", Text: "hi ho"}, "09:05 <kermit> hi ho"},
+		{common.Event{Type: common.EVENT_JOIN, Nickname: "piggy"}, "09:05 -!- piggy has joined #muppets"},
+		{common.Event{Type: common.EVENT_KICK, Nickname: "piggy", Target: "kermit", Text: "hi-yah"},
+			"09:05 -!- kermit was kicked from #muppets by piggy [hi-yah]"},
+		{common.Event{Type: common.EVENT_NICK, Nickname: "fozzie", Target: "fozzie_"},
+			"09:05 -!- fozzie is now known as fozzie_"},
+		{common.Event{Type: common.EVENT_MODE, Nickname: "kermit", Text: "+o fozzie"},
+			"09:05 -!- mode/#muppets [+o fozzie] by kermit"},
+	}
+
+	for _, test := range tests {
+		test.e.Channel = "muppets"
+		test.e.Time = tm
+		if line := Text(test.e); line != test.expected {
+			t.Errorf("Expected %s but got %s", test.expected, line)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chanlog")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewWriter(&common.ChanLogConf{Dir: dir, Format: []string{FORMAT_TEXT, FORMAT_JSON}, Compress: true, Retention: 7})
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+	defer w.Close()
+
+	now := time.Date(2015, 3, 1, 23, 59, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	m := common.Message{Nickname: "kermit", Channel: "Muppets", Body: "hi ho", Time: now}
+	if err := w.Message(m); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	now = now.Add(time.Hour)
+	if err := w.Event(common.Event{Type: common.EVENT_JOIN, Channel: "#muppets", Nickname: "piggy", Time: now}); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	yesterday := filepath.Join(dir, "muppets", "2015-03-01.log")
+	data, err := ioutil.ReadFile(yesterday)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if !strings.HasSuffix(string(data), "23:59 <kermit> hi ho\n") {
+		t.Errorf("Expected message line but got %s", data)
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, "muppets", "2015-03-02.jsonl"))
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if !strings.Contains(string(data), `"type":"join"`) {
+		t.Errorf("Expected join event but got %s", data)
+	}
+
+	// file times are used for compression and retention
+	old := filepath.Join(dir, "muppets", "2015-02-01.log")
+	if err := ioutil.WriteFile(old, []byte("old"), 0644); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	for path, tm := range map[string]time.Time{yesterday: now.Add(-2 * time.Hour), old: now.AddDate(0, -1, 0)} {
+		if err := os.Chtimes(path, tm, tm); err != nil {
+			t.Fatalf("Expected nil but got %s", err)
+		}
+	}
+
+	if err := w.Maintain(); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Expected expired file to be removed but got %v", err)
+	}
+
+	f, err := os.Open(yesterday + ".gz")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	data, err = ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if !strings.Contains(string(data), "<kermit> hi ho") {
+		t.Errorf("Expected compressed log but got %s", data)
+	}
+
+	if _, err := os.Stat(yesterday); !os.IsNotExist(err) {
+		t.Errorf("Expected compressed file to be removed but got %v", err)
+	}
+
+	// open files are not compressed
+	if _, err := os.Stat(filepath.Join(dir, "muppets", "2015-03-02.log")); err != nil {
+		t.Errorf("Expected nil but got %s", err)
+	}
+}
@@ -0,0 +1,34 @@
+package chanlog
+
+import (
+	"fmt"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+// Text formats event as an irssi log line
+func Text(e common.Event) string {
+	ts := e.Time.UTC().Format("15:04")
+	channel := "#" + e.Channel
+
+	switch e.Type {
+	case common.EVENT_MESSAGE:
+		return fmt.Sprintf("%s <%s> %s", ts, e.Nickname, e.Text)
+	case common.EVENT_JOIN:
+		return fmt.Sprintf("%s -!- %s has joined %s", ts, e.Nickname, channel)
+	case common.EVENT_PART:
+		return fmt.Sprintf("%s -!- %s has left %s [%s]", ts, e.Nickname, channel, e.Text)
+	case common.EVENT_QUIT:
+		return fmt.Sprintf("%s -!- %s has quit [%s]", ts, e.Nickname, e.Text)
+	case common.EVENT_KICK:
+		return fmt.Sprintf("%s -!- %s was kicked from %s by %s [%s]", ts, e.Target, channel, e.Nickname, e.Text)
+	case common.EVENT_NICK:
+		return fmt.Sprintf("%s -!- %s is now known as %s", ts, e.Nickname, e.Target)
+	case common.EVENT_TOPIC:
+		return fmt.Sprintf("%s -!- %s changed the topic of %s to: %s", ts, e.Nickname, channel, e.Text)
+	case common.EVENT_MODE:
+		return fmt.Sprintf("%s -!- mode/%s [%s] by %s", ts, channel, e.Text, e.Nickname)
+	default:
+		return fmt.Sprintf("%s -!- %s %s %s", ts, e.Nickname, e.Type, e.Text)
+	}
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	irc "github.com/fluffle/goirc/client"
+)
+
+// batches which are not ended by the server are closed after this long
+const BATCH_TIMEOUT = 30 * time.Second
+
+// batch holds messages received between BATCH +ref and BATCH -ref
+type batch struct {
+	typ      string
+	target   string
+	parent   string
+	messages []common.Message
+	timer    *time.Timer
+}
+
+// batches holds open batches by their reference tags
+type batches struct {
+	mu   sync.Mutex
+	open map[string]*batch
+	// expire is called with the reference of timed out batches
+	expire func(ref string)
+}
+
+func newBatches(expire func(ref string)) *batches {
+	return &batches{open: make(map[string]*batch), expire: expire}
+}
+
+// start opens a batch. Nested batches have parent references. Batches
+// are expired when they are not ended in BATCH_TIMEOUT
+func (b *batches) start(ref, typ, target, parent string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bt := &batch{typ: typ, target: target, parent: parent}
+	if b.expire != nil {
+		bt.timer = time.AfterFunc(BATCH_TIMEOUT, func() { b.expire(ref) })
+	}
+	b.open[ref] = bt
+}
+
+// add appends message to the open batch of ref. It returns false when
+// there is no such batch
+func (b *batches) add(ref string, m common.Message) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bt, ok := b.open[ref]
+	if !ok {
+		return false
+	}
+	bt.messages = append(bt.messages, m)
+
+	return true
+}
+
+// end closes the batch of ref. Messages of nested batches are moved to
+// their parents, and nil batch is returned for them
+func (b *batches) end(ref string) *batch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bt, ok := b.open[ref]
+	if !ok {
+		return nil
+	}
+	delete(b.open, ref)
+
+	if bt.timer != nil {
+		bt.timer.Stop()
+	}
+
+	if parent, ok := b.open[bt.parent]; ok {
+		parent.messages = append(parent.messages, bt.messages...)
+		return nil
+	}
+
+	return bt
+}
+
+// handleBatch tracks batches. Messages of a batch are pushed together
+// when the batch ends. Args are +ref type [params] or -ref. Chathistory
+// batches complete backfill of their target channels
+func (c *Connection) handleBatch(conn *irc.Conn, line *irc.Line) {
+	if len(line.Args) == 0 || len(line.Args[0]) < 2 {
+		return
+	}
+
+	ref := line.Args[0][1:]
+	switch line.Args[0][0] {
+	case '+':
+		typ, target := "", ""
+		if len(line.Args) > 1 {
+			typ = line.Args[1]
+		}
+		if len(line.Args) > 2 {
+			target = line.Args[2]
+		}
+		c.batches.start(ref, typ, target, c.tags.pop(line.Raw)["batch"])
+	case '-':
+		c.endBatch(ref)
+	}
+}
+
+// endBatch closes the batch of ref and pushes its messages. It is also
+// called for expired batches, so that their messages are not lost
+func (c *Connection) endBatch(ref string) {
+	bt := c.batches.end(ref)
+	if bt == nil {
+		return
+	}
+
+	if bt.typ == BATCH_CHATHISTORY {
+		c.finishBackfill(bt.target, bt.messages)
+		return
+	}
+
+	for _, m := range bt.messages {
+		c.push(c.ircConn, m)
+	}
+}
@@ -0,0 +1,210 @@
+package client
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+// IRCv3 capabilities supported by Connection
+const (
+	CAP_SERVER_TIME  = "server-time"
+	CAP_MESSAGE_TAGS = "message-tags"
+	CAP_ACCOUNT_TAG  = "account-tag"
+	CAP_ECHO_MESSAGE = "echo-message"
+	CAP_BATCH        = "batch"
+
+	// maximum duration of capability negotiation and registration
+	REGISTRATION_TIMEOUT = 30 * time.Second
+)
+
+// DefaultCapabilities are requested when Capabilities of connection
+// is not set
+var DefaultCapabilities = []string{
+	CAP_SERVER_TIME,
+	CAP_MESSAGE_TAGS,
+	CAP_ACCOUNT_TAG,
+	CAP_ECHO_MESSAGE,
+	CAP_BATCH,
+	CAP_CHATHISTORY,
+}
+
+// capabilities holds the capability negotiation state of a connection
+type capabilities struct {
+	mu        sync.RWMutex
+	requested []string
+	// advertised capabilities and their values
+	available map[string]string
+	enabled   map[string]bool
+	// negotiation ends before registration is completed
+	negotiating bool
+}
+
+func newCapabilities(requested []string) *capabilities {
+	return &capabilities{
+		requested:   requested,
+		available:   make(map[string]string),
+		enabled:     make(map[string]bool),
+		negotiating: true,
+	}
+}
+
+// ls stores capabilities advertised in CAP LS and CAP NEW replies.
+// Capabilities can have values in name=value format
+func (c *capabilities) ls(list string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, capability := range strings.Fields(list) {
+		pair := strings.SplitN(capability, "=", 2)
+		value := ""
+		if len(pair) == 2 {
+			value = pair[1]
+		}
+		c.available[pair[0]] = value
+	}
+}
+
+// request returns requested capabilities which are advertised by server
+// and not yet enabled
+func (c *capabilities) request() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	req := make([]string, 0)
+	for _, name := range c.requested {
+		if _, ok := c.available[name]; ok && !c.enabled[name] {
+			req = append(req, name)
+		}
+	}
+
+	return req
+}
+
+// ack enables acknowledged capabilities. Capabilities prefixed with -
+// are disabled
+func (c *capabilities) ack(list string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, name := range strings.Fields(list) {
+		if strings.HasPrefix(name, "-") {
+			delete(c.enabled, name[1:])
+			continue
+		}
+		c.enabled[name] = true
+	}
+}
+
+// del removes capabilities which are no longer supported by server
+func (c *capabilities) del(list string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, name := range strings.Fields(list) {
+		delete(c.available, name)
+		delete(c.enabled, name)
+	}
+}
+
+// end marks the end of negotiation. It returns false when negotiation
+// is already ended
+func (c *capabilities) end() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	negotiating := c.negotiating
+	c.negotiating = false
+
+	return negotiating
+}
+
+func (c *capabilities) has(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.enabled[name]
+}
+
+func (c *capabilities) list() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := make([]string, 0, len(c.enabled))
+	for name := range c.enabled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// handleCap negotiates capabilities. Args of CAP replies are
+// target, subcommand, an optional * for multi-line replies, and the
+// capability list
+func (c *Connection) handleCap(conn *irc.Conn, line *irc.Line) {
+	if len(line.Args) < 3 {
+		return
+	}
+
+	list := line.Text()
+	more := len(line.Args) > 3 && line.Args[2] == "*"
+
+	switch strings.ToUpper(line.Args[1]) {
+	case "LS":
+		c.caps.ls(list)
+		if more {
+			return
+		}
+		c.requestCaps(conn)
+	case "NEW":
+		c.caps.ls(list)
+		c.requestCaps(conn)
+	case "ACK":
+		c.caps.ack(list)
+		if !more {
+			c.endCaps(conn)
+		}
+	case "NAK":
+		c.logger().Warn("Capabilities are rejected: %s", list)
+		c.endCaps(conn)
+	case "DEL":
+		c.caps.del(list)
+	}
+}
+
+// requestCaps requests available capabilities, and ends negotiation
+// when there is nothing to request
+func (c *Connection) requestCaps(conn *irc.Conn) {
+	req := c.caps.request()
+	if len(req) == 0 {
+		c.endCaps(conn)
+		return
+	}
+
+	conn.Raw("CAP REQ :" + strings.Join(req, " "))
+}
+
+// endCaps ends negotiation, so that server completes registration
+func (c *Connection) endCaps(conn *irc.Conn) {
+	if c.caps.end() {
+		conn.Raw("CAP END")
+	}
+}
+
+// HasCapability returns true when capability is enabled by server
+func (c *Connection) HasCapability(name string) bool {
+	return c.caps != nil && c.caps.has(name)
+}
+
+// EnabledCapabilities returns capabilities enabled by server
+func (c *Connection) EnabledCapabilities() []string {
+	if c.caps == nil {
+		return nil
+	}
+
+	return c.caps.list()
+}
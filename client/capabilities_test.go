@@ -0,0 +1,120 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	irc "github.com/fluffle/goirc/client"
+)
+
+func TestCapabilities(t *testing.T) {
+	c := newCapabilities(DefaultCapabilities)
+	c.ls("multi-prefix sasl=PLAIN,EXTERNAL server-time")
+	c.ls("echo-message batch")
+
+	req := c.request()
+	expected := []string{CAP_SERVER_TIME, CAP_ECHO_MESSAGE, CAP_BATCH}
+	if !reflect.DeepEqual(req, expected) {
+		t.Errorf("Expected %v but got %v", expected, req)
+	}
+
+	c.ack("server-time echo-message batch")
+	if !c.has(CAP_ECHO_MESSAGE) {
+		t.Errorf("Expected %s to be enabled", CAP_ECHO_MESSAGE)
+	}
+
+	if req := c.request(); len(req) != 0 {
+		t.Errorf("Expected no requests but got %v", req)
+	}
+
+	c.del("echo-message")
+	c.ack("-batch")
+	if !reflect.DeepEqual(c.list(), []string{CAP_SERVER_TIME}) {
+		t.Errorf("Expected %v but got %v", []string{CAP_SERVER_TIME}, c.list())
+	}
+
+	if !c.end() {
+		t.Error("Expected negotiation to be ended")
+	}
+
+	if c.end() {
+		t.Error("Expected negotiation to be ended only once")
+	}
+}
+
+func TestMessageTags(t *testing.T) {
+	raw, tags := splitTags(`@time=2015-06-02T10:20:30.123Z;msgid=abc;account=kermit;+draft/x=a\sb :kermit!k@muppets PRIVMSG #muppets :hi ho`)
+	line := irc.ParseLine(raw)
+	if line.Cmd != "PRIVMSG" || line.Nick != "kermit" {
+		t.Fatalf("Expected %s from %s but got %s from %s", "PRIVMSG", "kermit", line.Cmd, line.Nick)
+	}
+
+	if tags["msgid"] != "abc" || tags["+draft/x"] != "a b" {
+		t.Errorf("Expected tags but got %v", tags)
+	}
+
+	expected := time.Date(2015, 6, 2, 10, 20, 30, 123000000, time.UTC)
+	if tm, ok := messageTime(line, tags); !ok || !tm.Equal(expected) {
+		t.Errorf("Expected server time %s but got %s", expected, tm)
+	}
+
+	raw, tags = splitTags(":kermit!k@muppets PRIVMSG #muppets :hi ho")
+	if tags != nil {
+		t.Errorf("Expected no tags but got %v", tags)
+	}
+
+	line = irc.ParseLine(raw)
+	line.Time = expected
+	if tm, ok := messageTime(line, tags); ok || !tm.Equal(expected) {
+		t.Errorf("Expected reception time %s but got %s", expected, tm)
+	}
+}
+
+func TestTagQueue(t *testing.T) {
+	q := newTagQueue()
+	q.push("PING :irc.test", nil)
+	q.push(":kermit!k@muppets PRIVMSG #muppets :hi", map[string]string{"msgid": "1"})
+	q.push(":kermit!k@muppets PRIVMSG #muppets :hi", map[string]string{"msgid": "2"})
+
+	// identical lines get their own tags in reception order
+	for _, id := range []string{"1", "2"} {
+		if tags := q.pop(":kermit!k@muppets PRIVMSG #muppets :hi"); tags["msgid"] != id {
+			t.Errorf("Expected %s but got %v", id, tags)
+		}
+	}
+
+	if len(q.lines) != 0 {
+		t.Errorf("Expected handled lines to be discarded but got %v", q.lines)
+	}
+
+	if tags := q.pop("PING :irc.test"); tags != nil {
+		t.Errorf("Expected no tags but got %v", tags)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	b := newBatches(nil)
+	b.start("outer", "chathistory", "#muppets", "")
+	b.start("inner", "netsplit", "", "outer")
+
+	b.add("outer", common.Message{Body: "1"})
+	b.add("inner", common.Message{Body: "2"})
+	if b.add("unknown", common.Message{Body: "3"}) {
+		t.Error("Expected message of unknown batch to be rejected")
+	}
+
+	if bt := b.end("inner"); bt != nil {
+		t.Errorf("Expected nested batch to be merged but got %v", bt)
+	}
+
+	bt := b.end("outer")
+	if bt == nil || bt.typ != "chathistory" {
+		t.Fatalf("Expected %s batch but got %v", "chathistory", bt)
+	}
+
+	if len(bt.messages) != 2 || bt.messages[1].Body != "2" {
+		t.Errorf("Expected %d messages but got %v", 2, bt.messages)
+	}
+}
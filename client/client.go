@@ -5,8 +5,8 @@
 // 2. Subscribes to channel messages via Subscriber
 //
 // Connection serves as a simple irc client wrapper for sending/receiving
-// messages and joining public channels. It does not
-// support any other irc operations.
+// messages, joining public channels and moderating channels of
+// operators. It does not support any other irc operations.
 //
 // Subscriber can be considered as an irc channel feeder.
 // After subscribing a channel, user is notified for further messages.
@@ -14,11 +14,13 @@ package client
 
 import (
 	"fmt"
-	"log"
+	"io"
 	"strings"
 	"time"
 
 	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/logger"
+	"github.com/canthefason/irc-k/paste"
 	irc "github.com/fluffle/goirc/client"
 )
 
@@ -34,12 +36,76 @@ type Connection struct {
 	// received messages are piped into this channel
 	MsgChan chan common.Message
 
+	// size and overflow policy of MsgChan. By default MsgChan is unbuffered
+	// and blocks until messages are consumed
+	Buffer common.BufferConf
+
+	// line limit and long message policy of sent messages
+	Message common.MessageConf
+
+	// Paste stores long messages when paste policy is used
+	Paste paste.Store
+
+	// Encodings of received and sent messages per channel
+	Encodings common.Encodings
+
+	// IRCv3 capabilities requested from server. DefaultCapabilities are
+	// requested when it is nil
+	Capabilities []string
+
 	// freenode server definition. it must be defined as host:port
 	Server string
 
+	// PlainText disables ssl. It is only used for local servers
+	PlainText bool
+
+	// Log is used for connection logs. Entries are tagged with nickname
+	Log logger.Logger
+
+	// Delivery is notified with status changes of sent messages which
+	// have ids. Failures are detected via irc error replies
+	Delivery func(id, status, reason string)
+
+	// Sent is notified with the first irc line of sent messages which
+	// have ids, instead of notifying Delivery with sent status. Lines
+	// are used for matching echoes
+	Sent func(id, line string)
+
+	// Events is notified with membership, topic and mode changes of
+	// joined channels, and with the messages sent by connection. Channel
+	// names are given without leading #
+	Events func(e common.Event)
+
 	// irc connection
 	ircConn *irc.Conn
 
+	// bounded buffer behind MsgChan
+	buffer *common.Buffer
+
+	// recently sent messages waiting for error replies or echoes
+	pending *pendingMessages
+
+	// capability negotiation state
+	caps *capabilities
+
+	// tags of received lines
+	tags *tagQueue
+
+	// open message batches
+	batches *batches
+
+	// channels waiting for their history
+	backfills *backfills
+
+	// operators of joined channels
+	ops *operators
+
+	// ongoing moderation operation
+	moderation *moderation
+
+	// closed when registration is completed
+	registered chan struct{}
+
 	// connection result errors are piped
 	connRes chan error
 
@@ -52,6 +118,11 @@ func NewConnection() *Connection {
 	c := new(Connection)
 	c.connRes = make(chan error)
 	c.quit = make(chan bool)
+	c.Log = logger.New("client")
+	c.pending = newPendingMessages()
+	c.backfills = newBackfills()
+	c.ops = newOperators()
+	c.moderation = newModeration()
 
 	return c
 }
@@ -68,34 +139,177 @@ func (c *Connection) SendMessage(m *common.Message) error {
 		return err
 	}
 
+	if !c.Connected() {
+		sendErrors.Inc(c.Nickname)
+		c.notify(m.ID, common.STATUS_FAILED, ErrNotConnected.Error())
+		return ErrNotConnected
+	}
+
 	if err := c.Join(m.Channel); err != nil {
+		sendErrors.Inc(c.Nickname)
+		c.notify(m.ID, common.STATUS_FAILED, err.Error())
 		return err
 	}
 
 	channel := prepareChannel(m.Channel)
-	c.ircConn.Privmsg(channel, m.Body)
+	lines, err := c.prepareLines(m.Body, channel)
+	if err != nil {
+		sendErrors.Inc(c.Nickname)
+		c.notify(m.ID, common.STATUS_FAILED, err.Error())
+		return err
+	}
+
+	// pending messages are stored before sending, since their echoes
+	// can be received right after
+	if m.ID != "" {
+		c.pending.add(m.Channel, m.ID, lines[0])
+	}
+
+	// lines are sent raw, since goirc drops everything after a new line
+	encoding := c.Encodings.Of(m.Channel).Outbound
+	for _, line := range lines {
+		c.ircConn.Raw(fmt.Sprintf("PRIVMSG %s :%s", channel, encode(line, encoding)))
+	}
+
+	if m.ID != "" {
+		c.sent(m.ID, lines[0])
+	}
+
+	// sent messages are notified to Events when they are echoed, unless
+	// server does not echo them
+	if c.caps == nil || !c.caps.has(CAP_ECHO_MESSAGE) {
+		for _, line := range lines {
+			c.event(channel, common.Event{Type: common.EVENT_MESSAGE, Nickname: c.ircConn.Me().Nick,
+				Text: line, Time: time.Now().UTC()})
+		}
+	}
 
 	return nil
 }
 
+// prepareLines splits body into irc lines sent to channel, and applies
+// long message policy
+func (c *Connection) prepareLines(body, channel string) ([]string, error) {
+	me := c.ircConn.Me()
+	max := common.MaxBodyLength(me.Nick, me.Ident, me.Host, channel)
+	lines := common.SplitBody(body, max)
+	if len(lines) == 0 {
+		return nil, common.ErrBodyNotSet
+	}
+
+	if c.Message.MaxLines == 0 || len(lines) <= c.Message.MaxLines {
+		return lines, nil
+	}
+
+	switch c.Message.Long {
+	case common.LONG_REJECT:
+		return nil, common.ErrMessageTooLong
+	case common.LONG_PASTE:
+		link, err := c.Paste.Save(body)
+		if err != nil {
+			c.logger().Error("Could not paste message: %s", err)
+			return nil, ErrInternal
+		}
+
+		suffix := " ... " + link
+		return []string{common.Truncate(lines[0], max-len(suffix)) + suffix}, nil
+	default:
+		return lines[:c.Message.MaxLines], nil
+	}
+}
+
+// sent passes the first line of sent message to Sent handler
+func (c *Connection) sent(id, line string) {
+	if c.Sent == nil {
+		c.notify(id, common.STATUS_SENT, "")
+		return
+	}
+
+	c.Sent(id, line)
+}
+
+// notify passes delivery status of message to Delivery handler
+func (c *Connection) notify(id, status, reason string) {
+	if id == "" || c.Delivery == nil {
+		return
+	}
+
+	c.Delivery(id, status, reason)
+}
+
 // Connect creates irc connection and register event handlers.
 // If connection times out, it returns timeout error
 func (c *Connection) Connect() error {
+	if err := c.Buffer.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Message.Validate(); err != nil {
+		return err
+	}
+
+	if c.Message.Long == common.LONG_PASTE && c.Paste == nil {
+		return ErrPasteNotSet
+	}
+
+	if err := c.Encodings.Validate(); err != nil {
+		return err
+	}
+
+	if c.ircConn != nil {
+		reconnects.Inc(c.Nickname)
+	}
+
+	log := c.logger()
+	server, err := dialServer(c.Server, c.PlainText)
+	if err != nil {
+		log.Error("Could not connect to %s: %s", c.Server, err)
+		return ErrInternal
+	}
+
+	// goirc is connected to server via relay, which parses message tags
+	c.tags = newTagQueue()
+	r, err := newRelay(server, c.tags)
+	if err != nil {
+		server.Close()
+		log.Error("Could not create relay: %s", err)
+		return ErrInternal
+	}
+	go func() {
+		if err := r.serve(); err != nil && err != io.EOF {
+			log.Debug("Relay closed: %s", err)
+		}
+	}()
 
 	cfg := irc.NewConfig(c.Nickname)
-	cfg.SSL = true
-	cfg.Server = c.Server
+	cfg.SSL = false
+	cfg.Server = r.Addr()
 	cfg.NewNick = func(n string) string { return n + "^" }
 	c.ircConn = irc.Client(cfg)
 
+	requested := c.Capabilities
+	if requested == nil {
+		requested = DefaultCapabilities
+	}
+	c.caps = newCapabilities(requested)
+	c.batches = newBatches(c.endBatch)
+	c.ops = newOperators()
+	c.registered = make(chan struct{})
+	c.registerHandlers()
+
+	// queued lines are sent right after connecting, so negotiation
+	// starts before registration commands
+	if len(requested) > 0 {
+		c.ircConn.Raw("CAP LS 302")
+	}
+
 	go func() {
 		if err := c.ircConn.Connect(); err != nil {
-			log.Printf("an error occurred: %s \n", err)
+			log.Error("Could not connect to %s: %s", c.Server, err)
 			c.connRes <- ErrInternal
 			return
 		}
-		// just for debugging purposes
-		fmt.Printf(c.ircConn.String())
+		log.Debug("Connected: %s", c.ircConn)
 		c.connRes <- nil
 	}()
 
@@ -108,13 +322,25 @@ func (c *Connection) Connect() error {
 		c.ircConn.Quit()
 		return ErrTimeout
 	}
-	// TODO instead of sleeping, it needs to handle connected event
-	time.Sleep(time.Second * 15)
-	c.registerHandlers()
+
+	select {
+	case <-c.registered:
+	case <-time.After(REGISTRATION_TIMEOUT):
+		log.Error("Could not register to %s", c.Server)
+		c.ircConn.Quit()
+		return ErrTimeout
+	}
+	log.Debug("Enabled capabilities: %s", strings.Join(c.caps.list(), " "))
+	activeConnections.Inc(c.Nickname)
 
 	return nil
 }
 
+// Connected returns true when irc connection is established
+func (c *Connection) Connected() bool {
+	return c.ircConn != nil && c.ircConn.Connected()
+}
+
 // Join connects user to given channel if irc connection is established
 func (c *Connection) Join(channelName string) error {
 	if channelName == "" {
@@ -131,34 +357,139 @@ func (c *Connection) Join(channelName string) error {
 	return nil
 }
 
-// registerHandler registers user to connected, privmsg and disconnected
-// irc events. When more operators needed, handlers must be registered here.
+// Part leaves given channel if irc connection is established
+func (c *Connection) Part(channelName string) error {
+	if channelName == "" {
+		return ErrChannelNotSet
+	}
+
+	if c.ircConn == nil {
+		return ErrNotConnected
+	}
+
+	c.ircConn.Part(prepareChannel(channelName))
+
+	return nil
+}
+
+// registerHandler registers user to connected, cap, batch, privmsg and
+// disconnected irc events, error replies of sent messages, moderation
+// replies and channel membership changes. When more operators needed,
+// handlers must be registered here.
 func (c *Connection) registerHandlers() {
-	c.MsgChan = make(chan common.Message, 0)
+	c.buffer = common.NewBuffer(&c.Buffer)
+	c.buffer.Name = "connection"
+	c.MsgChan = c.buffer.C
+	registered := c.registered
 	c.ircConn.HandleFunc("connected",
 		func(conn *irc.Conn, line *irc.Line) {
-			// connected
+			// servers without capability support skip negotiation
+			c.caps.end()
+			close(registered)
 		})
 
+	c.ircConn.HandleFunc("cap", c.handleCap)
+	c.ircConn.HandleFunc("batch", c.handleBatch)
+	c.ircConn.HandleFunc("fail", c.handleFail)
+
 	c.ircConn.HandleFunc("disconnected",
 		//TODO handle disconnection
 		func(conn *irc.Conn, line *irc.Line) {
+			// connections are only counted after registration
+			select {
+			case <-registered:
+				activeConnections.Dec(c.Nickname)
+			default:
+			}
 			c.quit <- true
 		})
 
-	c.ircConn.HandleFunc("privmsg",
-		func(conn *irc.Conn, line *irc.Line) {
-			channel := line.Args[0]
-			if strings.IndexRune(channel, '#') == 0 {
-				channel = strings.Replace(channel, "#", "", 1)
-			}
-			m := common.Message{
-				Nickname: line.Nick,
-				Body:     line.Args[1],
-				Channel:  channel,
-			}
+	for _, numeric := range failureNumerics {
+		c.ircConn.HandleFunc(numeric, c.handleFailure)
+	}
+
+	for _, cmd := range []string{"353", irc.JOIN, irc.MODE, irc.TOPIC, irc.NICK, irc.PART, irc.KICK, irc.QUIT} {
+		c.ircConn.HandleFunc(cmd, c.handleOperators)
+	}
+
+	for numeric := range moderationReplies {
+		c.ircConn.HandleFunc(numeric, c.handleModeration)
+	}
+	c.ircConn.HandleFunc("pong", c.handleModeration)
+
+	c.ircConn.HandleFunc("privmsg", c.handlePrivmsg)
+}
+
+// handlePrivmsg pushes received channel messages into buffer. Own
+// messages are echoed back by servers supporting echo-message, and they
+// are only used for confirming delivery
+func (c *Connection) handlePrivmsg(conn *irc.Conn, line *irc.Line) {
+	channel := line.Args[0]
+	if strings.IndexRune(channel, '#') == 0 {
+		channel = strings.Replace(channel, "#", "", 1)
+	}
+	tags := c.tags.pop(line.Raw)
+	m := common.Message{
+		Nickname: line.Nick,
+		Body:     decode(line.Args[1], c.Encodings.Of(channel).Inbound),
+		Channel:  channel,
+		MsgID:    tags["msgid"],
+		Account:  tags["account"],
+	}
+	m.Time, m.ServerTime = messageTime(line, tags)
+	receivedMessages.Inc(channel)
+
+	if line.Nick == conn.Me().Nick {
+		if id, ok := c.pending.echo(channel, m.Body); ok {
+			c.notify(id, common.STATUS_ECHOED, "")
+		}
+		c.event(channel, common.MessageEvent(m))
+		return
+	}
+
+	if ref, ok := tags["batch"]; ok && c.batches.add(ref, m) {
+		return
+	}
+
+	if c.backfills.hold(channel, m) {
+		return
+	}
+
+	c.push(conn, m)
+}
+
+// push adds message to buffer, and disconnects when buffer policy
+// requires it
+func (c *Connection) push(conn *irc.Conn, m common.Message) {
+	if err := c.buffer.Push(m); err != nil {
+		c.logger().Warn("Disconnecting: %s", err)
+		conn.Quit()
+	}
+}
+
+// handleFailure marks the oldest pending message of channel as failed
+// when an error reply is received. Args[1] holds channel or nickname
+func (c *Connection) handleFailure(conn *irc.Conn, line *irc.Line) {
+	if len(line.Args) < 2 {
+		return
+	}
+
+	if id, ok := c.pending.fail(line.Args[1]); ok {
+		c.notify(id, common.STATUS_FAILED, line.Text())
+	}
+}
+
+// logger returns connection logger tagged with nickname
+func (c *Connection) logger() logger.Logger {
+	return c.Log.New("nickname", c.Nickname)
+}
+
+// Dropped returns the number of received messages discarded due to
+// buffer overflow
+func (c *Connection) Dropped() uint64 {
+	if c.buffer == nil {
+		return 0
+	}
 
-			c.MsgChan <- m
-		},
-	)
+	return c.buffer.Dropped()
 }
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+// sent messages are considered as delivered when no failure
+// replies are received within this duration
+const PENDING_TIMEOUT = 10 * time.Second
+
+// failureNumerics are irc error replies received when messages cannot
+// be delivered to channels (e.g. 404 for moderated channels)
+var failureNumerics = []string{"401", "403", "404", "442", "477", "489"}
+
+type pendingMessage struct {
+	id     string
+	body   string
+	sentAt time.Time
+}
+
+// pendingMessages holds recently sent messages of each channel. Error
+// replies only point to channels, so they are matched with the oldest
+// pending message of the channel.
+type pendingMessages struct {
+	mu       sync.Mutex
+	channels map[string][]pendingMessage
+
+	// used for mocking time in tests
+	now func() time.Time
+}
+
+func newPendingMessages() *pendingMessages {
+	return &pendingMessages{
+		channels: make(map[string][]pendingMessage),
+		now:      time.Now,
+	}
+}
+
+// add stores sent message of channel
+func (p *pendingMessages) add(channel, id, body string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	channel = common.NormalizeChannel(channel)
+	p.channels[channel] = append(p.expire(channel),
+		pendingMessage{id: id, body: body, sentAt: p.now()})
+}
+
+// fail removes and returns the oldest pending message id of channel
+func (p *pendingMessages) fail(channel string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	channel = common.NormalizeChannel(channel)
+	pending := p.expire(channel)
+	if len(pending) == 0 {
+		return "", false
+	}
+
+	p.channels[channel] = pending[1:]
+
+	return pending[0].id, true
+}
+
+// echo removes and returns the id of pending message with given body
+func (p *pendingMessages) echo(channel, body string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	channel = common.NormalizeChannel(channel)
+	pending := p.expire(channel)
+	for i, m := range pending {
+		if m.body == body {
+			p.channels[channel] = append(pending[:i:i], pending[i+1:]...)
+			return m.id, true
+		}
+	}
+
+	return "", false
+}
+
+// expire removes timed out messages of channel and returns the rest
+func (p *pendingMessages) expire(channel string) []pendingMessage {
+	pending := p.channels[channel]
+	now := p.now()
+	i := 0
+	for ; i < len(pending); i++ {
+		if now.Sub(pending[i].sentAt) < PENDING_TIMEOUT {
+			break
+		}
+	}
+
+	pending = pending[i:]
+	if len(pending) == 0 {
+		delete(p.channels, channel)
+	} else {
+		p.channels[channel] = pending
+	}
+
+	return pending
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingMessages(t *testing.T) {
+	p := newPendingMessages()
+	now := time.Unix(1000, 0)
+	p.now = func() time.Time { return now }
+
+	p.add("muppets", "1", "hi ho")
+	p.add("muppets", "2", "wocka wocka")
+	p.add("kitchen", "3", "bork bork")
+
+	id, ok := p.fail("#Muppets")
+	if !ok || id != "1" {
+		t.Errorf("Expected %s but got %s", "1", id)
+	}
+
+	id, ok = p.echo("#kitchen", "bork bork")
+	if !ok || id != "3" {
+		t.Errorf("Expected %s but got %s", "3", id)
+	}
+
+	if _, ok := p.echo("kitchen", "bork bork"); ok {
+		t.Error("Expected echoed message to be removed")
+	}
+
+	now = now.Add(PENDING_TIMEOUT)
+	if _, ok := p.fail("muppets"); ok {
+		t.Error("Expected pending message to be expired")
+	}
+
+	if len(p.channels) != 0 {
+		t.Errorf("Expected no channels but got %d", len(p.channels))
+	}
+}
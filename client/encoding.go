@@ -0,0 +1,88 @@
+package client
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+// charset maps bytes 0x80-0xFF of a single byte encoding to runes
+type charset [128]rune
+
+var charsets = map[string]*charset{
+	common.ENCODING_LATIN1: latin1(),
+	common.ENCODING_CP1252: &cp1252,
+	common.ENCODING_CP1251: &cp1251,
+	common.ENCODING_KOI8R:  &koi8r,
+}
+
+func latin1() *charset {
+	c := new(charset)
+	for i := range c {
+		c[i] = rune(0x80 + i)
+	}
+
+	return c
+}
+
+// decode converts s into UTF-8. Valid UTF-8 input is returned as is,
+// since most clients send UTF-8 even in channels with legacy encodings
+func decode(s, encoding string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+
+	var out bytes.Buffer
+	c, ok := charsets[strings.ToLower(encoding)]
+	if !ok {
+		// invalid sequences are replaced for producing valid json
+		for _, r := range s {
+			out.WriteRune(r)
+		}
+
+		return out.String()
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] < utf8.RuneSelf {
+			out.WriteByte(s[i])
+			continue
+		}
+		out.WriteRune(c[s[i]-0x80])
+	}
+
+	return out.String()
+}
+
+// encode converts UTF-8 s into given encoding. Runes which cannot be
+// represented are replaced with question marks
+func encode(s, encoding string) string {
+	c, ok := charsets[strings.ToLower(encoding)]
+	if !ok {
+		return s
+	}
+
+	var out bytes.Buffer
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			out.WriteByte(byte(r))
+			continue
+		}
+		out.WriteByte(c.index(r))
+	}
+
+	return out.String()
+}
+
+// index returns the byte of rune r, or '?' when r is not in charset
+func (c *charset) index(r rune) byte {
+	for i, cr := range c {
+		if cr == r {
+			return byte(0x80 + i)
+		}
+	}
+
+	return '?'
+}
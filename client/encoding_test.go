@@ -0,0 +1,40 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+func TestDecode(t *testing.T) {
+	// valid UTF-8 is kept as it is
+	if s := decode("çöp", common.ENCODING_LATIN1); s != "çöp" {
+		t.Errorf("Expected %s but got %s", "çöp", s)
+	}
+
+	if s := decode("caf\xe9 \x80", common.ENCODING_CP1252); s != "café €" {
+		t.Errorf("Expected %s but got %s", "café €", s)
+	}
+
+	if s := decode("\xcf\xf0\xe8\xe2\xe5\xf2", common.ENCODING_CP1251); s != "Привет" {
+		t.Errorf("Expected %s but got %s", "Привет", s)
+	}
+
+	if s := decode("\xf0\xd2\xc9\xd7\xc5\xd4", common.ENCODING_KOI8R); s != "Привет" {
+		t.Errorf("Expected %s but got %s", "Привет", s)
+	}
+
+	if s := decode("caf\xe9", common.ENCODING_UTF8); s != "caf�" {
+		t.Errorf("Expected %s but got %s", "caf�", s)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	if s := encode("Привет ✓", common.ENCODING_CP1251); s != "\xcf\xf0\xe8\xe2\xe5\xf2 ?" {
+		t.Errorf("Expected %q but got %q", "\xcf\xf0\xe8\xe2\xe5\xf2 ?", s)
+	}
+
+	if s := encode("çöp", ""); s != "çöp" {
+		t.Errorf("Expected %s but got %s", "çöp", s)
+	}
+}
@@ -7,4 +7,17 @@ var (
 	ErrChannelNotSet = errors.New("channel not set")
 	ErrInternal      = errors.New("internal error")
 	ErrNotConnected  = errors.New("not connected")
+	ErrPasteNotSet   = errors.New("paste store not set")
+
+	ErrHistoryNotSupported = errors.New("chathistory not supported")
+
+	ErrNotOperator      = errors.New("not channel operator")
+	ErrNoSuchNick       = errors.New("no such nick")
+	ErrNoSuchChannel    = errors.New("no such channel")
+	ErrUserNotInChannel = errors.New("user not in channel")
+	ErrNotOnChannel     = errors.New("not on channel")
+	ErrUserOnChannel    = errors.New("user already on channel")
+	ErrUnknownMode      = errors.New("unknown mode")
+	ErrInvalidMode      = errors.New("invalid mode")
+	ErrInvalidTarget    = errors.New("invalid target")
 )
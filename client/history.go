@@ -0,0 +1,180 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	irc "github.com/fluffle/goirc/client"
+)
+
+const (
+	CAP_CHATHISTORY = "draft/chathistory"
+
+	// batch type of chathistory replies
+	BATCH_CHATHISTORY = "chathistory"
+
+	// maximum number of messages requested for backfill
+	HISTORY_LIMIT = 100
+
+	// live messages are held at most this long while history is fetched
+	HISTORY_TIMEOUT = 10 * time.Second
+)
+
+// backfill holds the history request of a channel and live messages
+// received until history is fetched
+type backfill struct {
+	since time.Time
+	msgID string
+	held  []common.Message
+	timer *time.Timer
+}
+
+// includes returns false for history messages which are already seen
+// before the backfill reference
+func (b *backfill) includes(m common.Message) bool {
+	if m.MsgID != "" && m.MsgID == b.msgID {
+		return false
+	}
+
+	return m.Time.After(b.since)
+}
+
+// backfills holds ongoing history requests by channel
+type backfills struct {
+	mu       sync.Mutex
+	channels map[string]*backfill
+}
+
+func newBackfills() *backfills {
+	return &backfills{channels: make(map[string]*backfill)}
+}
+
+func (b *backfills) start(channel string, bf *backfill) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.channels[common.NormalizeChannel(channel)] = bf
+}
+
+// hold stores live message when history of its channel is being fetched
+func (b *backfills) hold(channel string, m common.Message) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bf, ok := b.channels[common.NormalizeChannel(channel)]
+	if !ok {
+		return false
+	}
+	bf.held = append(bf.held, m)
+
+	return true
+}
+
+// end removes and returns backfill of channel
+func (b *backfills) end(channel string) *backfill {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	channel = common.NormalizeChannel(channel)
+	bf, ok := b.channels[channel]
+	if !ok {
+		return nil
+	}
+	delete(b.channels, channel)
+
+	return bf
+}
+
+// JoinWithHistory joins channel and fetches messages sent after the
+// given message via chathistory. msgID is used as reference when it is
+// set, and since otherwise. Live messages of channel are held until
+// history is received, so that messages are pushed in order
+func (c *Connection) JoinWithHistory(channelName string, since time.Time, msgID string) error {
+	if channelName == "" {
+		return ErrChannelNotSet
+	}
+
+	if c.ircConn == nil {
+		return ErrNotConnected
+	}
+
+	if !c.HasCapability(CAP_CHATHISTORY) {
+		return ErrHistoryNotSupported
+	}
+
+	reference := "timestamp=" + since.UTC().Format("2006-01-02T15:04:05.000Z")
+	if msgID != "" {
+		reference = "msgid=" + msgID
+	}
+
+	bf := &backfill{since: since, msgID: msgID}
+	bf.timer = time.AfterFunc(HISTORY_TIMEOUT, func() {
+		c.logger().Warn("History of %s is not received", channelName)
+		c.finishBackfill(channelName, nil)
+	})
+	c.backfills.start(channelName, bf)
+
+	channel := prepareChannel(channelName)
+	c.ircConn.Join(channel)
+	c.ircConn.Raw(fmt.Sprintf("CHATHISTORY AFTER %s %s %d", channel, reference, HISTORY_LIMIT))
+
+	return nil
+}
+
+// finishBackfill pushes history messages of channel which are not seen
+// before, and then the held live messages. History received after
+// timeout is dropped, since live messages are already pushed
+func (c *Connection) finishBackfill(channel string, history []common.Message) {
+	bf := c.backfills.end(channel)
+	if bf == nil {
+		if len(history) > 0 {
+			c.logger().Warn("Dropping %d history messages of %s received after timeout", len(history), channel)
+			droppedHistory.Add(float64(len(history)), common.NormalizeChannel(channel))
+		}
+		return
+	}
+
+	if bf.timer != nil {
+		bf.timer.Stop()
+	}
+
+	// held live messages are preferred over their history copies
+	seen := make(map[string]bool)
+	for _, m := range bf.held {
+		if m.MsgID != "" {
+			seen[m.MsgID] = true
+		}
+	}
+
+	for _, m := range history {
+		if !bf.includes(m) || (m.MsgID != "" && seen[m.MsgID]) {
+			continue
+		}
+
+		m.History = true
+		seen[m.MsgID] = true
+		c.push(c.ircConn, m)
+	}
+
+	for _, m := range bf.held {
+		c.push(c.ircConn, m)
+	}
+}
+
+// handleFail releases held messages when chathistory requests fail.
+// FAIL replies are in "FAIL command code [context...] :description" format
+func (c *Connection) handleFail(conn *irc.Conn, line *irc.Line) {
+	if len(line.Args) < 2 || !strings.EqualFold(line.Args[0], "CHATHISTORY") {
+		return
+	}
+
+	c.logger().Warn("Could not fetch history: %s", line.Text())
+	for _, arg := range line.Args[2:] {
+		if strings.HasPrefix(arg, "#") {
+			c.finishBackfill(arg, nil)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+// fakeServer is a minimal irc server supporting capability negotiation
+// and chathistory. Lines received from client are piped into received
+type fakeServer struct {
+	listener net.Listener
+	received chan string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	s := &fakeServer{listener: l, received: make(chan string, 32)}
+	go s.serve()
+
+	return s
+}
+
+func (s *fakeServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	write := func(lines ...string) {
+		for _, line := range lines {
+			fmt.Fprintf(conn, "%s\r\n", line)
+		}
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		s.received <- line
+
+		switch {
+		case line == "CAP LS 302":
+			write(":irc.test CAP * LS :server-time message-tags batch draft/chathistory")
+		case strings.HasPrefix(line, "CAP REQ :"):
+			write(":irc.test CAP * ACK :" + strings.TrimPrefix(line, "CAP REQ :"))
+		case line == "CAP END":
+			write(":irc.test 001 kermit :Welcome kermit!kermit@localhost")
+		case line == "JOIN #muppets":
+			// live message is received before history
+			write("@time=2015-06-02T12:00:03.000Z;msgid=3 :piggy!p@muppets PRIVMSG #muppets :hi-ya")
+		case strings.HasPrefix(line, "CHATHISTORY"):
+			write(
+				"BATCH +h chathistory #muppets",
+				"@batch=h;time=2015-06-02T12:00:00.000Z;msgid=0 :fozzie!f@muppets PRIVMSG #muppets :stored",
+				"@batch=h;time=2015-06-02T12:00:01.000Z;msgid=1 :fozzie!f@muppets PRIVMSG #muppets :wocka",
+				"@batch=h;time=2015-06-02T12:00:02.000Z;msgid=2 :gonzo!g@muppets PRIVMSG #muppets :ta-da",
+				"@batch=h;time=2015-06-02T12:00:03.000Z;msgid=3 :piggy!p@muppets PRIVMSG #muppets :hi-ya",
+				"BATCH -h",
+			)
+		}
+	}
+}
+
+func (s *fakeServer) expect(t *testing.T, line string) {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case l := <-s.received:
+			if l == line {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Expected line %q but timed out", line)
+		}
+	}
+}
+
+func TestJoinWithHistory(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.listener.Close()
+
+	c := NewConnection()
+	c.Nickname = "kermit"
+	c.Server = s.listener.Addr().String()
+	c.PlainText = true
+	c.Buffer = common.BufferConf{Size: 16}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if !c.HasCapability(CAP_CHATHISTORY) {
+		t.Fatalf("Expected %s to be enabled", CAP_CHATHISTORY)
+	}
+
+	since := time.Date(2015, 6, 2, 12, 0, 0, 0, time.UTC)
+	if err := c.JoinWithHistory("muppets", since, ""); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+	s.expect(t, "CHATHISTORY AFTER #muppets timestamp=2015-06-02T12:00:00.000Z 100")
+
+	expected := []struct {
+		body    string
+		history bool
+	}{
+		{"wocka", true},
+		{"ta-da", true},
+		{"hi-ya", false},
+	}
+
+	for _, e := range expected {
+		select {
+		case m := <-c.MsgChan:
+			if m.Body != e.body || m.History != e.history {
+				t.Errorf("Expected %s (history: %t) but got %s (history: %t)", e.body, e.history, m.Body, m.History)
+			}
+		case <-time.After(HISTORY_TIMEOUT):
+			t.Fatalf("Expected message %s but timed out", e.body)
+		}
+	}
+}
+
+func TestLateHistory(t *testing.T) {
+	c := NewConnection()
+	c.finishBackfill("#late", []common.Message{{Channel: "late", Body: "wocka"}})
+
+	if v := droppedHistory.Value("late"); v != 1 {
+		t.Errorf("Expected %d but got %f", 1, v)
+	}
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/canthefason/irc-k/logger"
+	ircLogging "github.com/fluffle/goirc/logging"
+)
+
+// redactions match irc commands carrying passwords. First group is kept
+// and the rest of the line is masked.
+var redactions = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^((?:-> |<- )?PASS ).+$`),
+	regexp.MustCompile(`(?i)^((?:-> |<- )?OPER \S+ ).+$`),
+	regexp.MustCompile(`(?i)^((?:-> |<- )?(?:PRIVMSG|NOTICE) (?:NickServ|NS) :(?:IDENTIFY|REGISTER|GHOST \S+|RECOVER \S+) ).+$`),
+	regexp.MustCompile(`(?i)^((?:-> |<- )?(?:NICKSERV|NS) (?:IDENTIFY|REGISTER) ).+$`),
+	regexp.MustCompile(`(?i)^((?:-> |<- )?AUTHENTICATE )[A-Za-z0-9+/=]{12,}$`),
+}
+
+// redact masks passwords in raw irc lines
+func redact(line string) string {
+	for _, r := range redactions {
+		if r.MatchString(line) {
+			return r.ReplaceAllString(line, "${1}****")
+		}
+	}
+
+	return line
+}
+
+// ircLogger adapts Logger to goirc logging. Raw irc traffic is logged
+// at debug level only when it is enabled in log settings
+type ircLogger struct {
+	logger.Logger
+}
+
+func (l ircLogger) Debug(format string, args ...interface{}) {
+	if !logger.RawIRC() {
+		return
+	}
+
+	l.Logger.Debug("%s", redact(fmt.Sprintf(format, args...)))
+}
+
+func init() {
+	ircLogging.SetLogger(ircLogger{logger.New("irc")})
+}
@@ -0,0 +1,21 @@
+package client
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	lines := map[string]string{
+		"-> PASS hunter2":                              "-> PASS ****",
+		"-> OPER kermit hunter2":                       "-> OPER kermit ****",
+		"-> PRIVMSG NickServ :IDENTIFY kermit hunter2": "-> PRIVMSG NickServ :IDENTIFY ****",
+		"-> NS identify hunter2":                       "-> NS identify ****",
+		"-> AUTHENTICATE a2VybWl0AGtlcm1pdABodW50ZXIy": "-> AUTHENTICATE ****",
+		"-> AUTHENTICATE +":                            "-> AUTHENTICATE +",
+		"-> PRIVMSG #muppets :my PASS is safe":         "-> PRIVMSG #muppets :my PASS is safe",
+	}
+
+	for line, expected := range lines {
+		if r := redact(line); r != expected {
+			t.Errorf("Expected %s but got %s", expected, r)
+		}
+	}
+}
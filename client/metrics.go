@@ -0,0 +1,18 @@
+package client
+
+import "github.com/canthefason/irc-k/metrics"
+
+var (
+	receivedMessages = metrics.NewCounter("irck_messages_received_total",
+		"Messages received from irc channels", "channel")
+	sendErrors = metrics.NewCounter("irck_send_errors_total",
+		"Messages which could not be sent to irc channels", "nickname")
+	activeConnections = metrics.NewGauge("irck_irc_connections",
+		"Active irc connections", "nickname")
+	reconnects = metrics.NewCounter("irck_irc_reconnects_total",
+		"Irc connections established after a previous connection", "nickname")
+	subscribedMessages = metrics.NewCounter("irck_subscriber_messages_received_total",
+		"Messages received by subscribers", "channel")
+	droppedHistory = metrics.NewCounter("irck_history_messages_dropped_total",
+		"History messages received after backfill timeout", "channel")
+)
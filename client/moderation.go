@@ -0,0 +1,226 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+const (
+	// moderation operations fail when their replies are not received
+	// within this duration
+	MODERATION_TIMEOUT = 10 * time.Second
+
+	// prefix of ping tokens sent after moderation commands
+	MODERATION_TOKEN = "irck-moderation-"
+)
+
+// moderationReplies are irc error replies of moderation commands
+var moderationReplies = map[string]error{
+	"401": ErrNoSuchNick,
+	"403": ErrNoSuchChannel,
+	"441": ErrUserNotInChannel,
+	"442": ErrNotOnChannel,
+	"443": ErrUserOnChannel,
+	"472": ErrUnknownMode,
+	"482": ErrNotOperator,
+}
+
+// moderation holds the ongoing moderation operation. Servers do not
+// reply successful commands in a common way, so every command is
+// followed by a ping. Commands are processed in order, hence the
+// operation succeeds when pong is received before any error replies.
+type moderation struct {
+	// serializes operations, so that replies are matched with the
+	// only ongoing operation
+	op sync.Mutex
+
+	mu      sync.Mutex
+	seq     int
+	token   string
+	targets []string
+	result  chan error
+}
+
+func newModeration() *moderation {
+	return &moderation{}
+}
+
+// start registers an operation on given channel and nicknames, and
+// returns its ping token. It must be called with op lock held
+func (m *moderation) start(targets ...string) (string, chan error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seq++
+	m.token = fmt.Sprintf("%s%d", MODERATION_TOKEN, m.seq)
+	m.targets = m.targets[:0]
+	for _, target := range targets {
+		m.targets = append(m.targets, strings.ToLower(target))
+	}
+	m.result = make(chan error, 1)
+
+	return m.token, m.result
+}
+
+// end removes the ongoing operation
+func (m *moderation) end() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.token = ""
+	m.targets = nil
+	m.result = nil
+}
+
+// fail finishes the ongoing operation with err when the error reply
+// refers to one of its targets
+func (m *moderation) fail(args []string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.result == nil || len(args) < 2 {
+		return
+	}
+
+	// first argument is own nickname and the last one is the description
+	for _, arg := range args[1 : len(args)-1] {
+		for _, target := range m.targets {
+			if strings.ToLower(arg) == target {
+				m.finish(err)
+				return
+			}
+		}
+	}
+}
+
+// pong finishes the ongoing operation successfully when token matches
+func (m *moderation) pong(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.result == nil || token != m.token {
+		return
+	}
+
+	m.finish(nil)
+}
+
+// finish publishes the operation result. It must be called with lock held
+func (m *moderation) finish(err error) {
+	m.result <- err
+	m.result = nil
+}
+
+// handleModeration matches error replies and pongs with the ongoing
+// moderation operation
+func (c *Connection) handleModeration(conn *irc.Conn, line *irc.Line) {
+	if line.Cmd == "PONG" {
+		if len(line.Args) > 0 {
+			c.moderation.pong(line.Args[len(line.Args)-1])
+		}
+		return
+	}
+
+	if err, ok := moderationReplies[line.Cmd]; ok {
+		c.moderation.fail(line.Args, err)
+	}
+}
+
+// moderate sends the command and waits for its result. Channel is not
+// joined, so commands fail with ErrNotOnChannel or ErrNotOperator when
+// connection is not an operator of channel. targets are the nicknames
+// and masks which error replies can refer to
+func (c *Connection) moderate(channelName string, send func(channel string), targets ...string) error {
+	if channelName == "" {
+		return ErrChannelNotSet
+	}
+
+	if !c.Connected() {
+		return ErrNotConnected
+	}
+
+	c.moderation.op.Lock()
+	defer c.moderation.op.Unlock()
+
+	channel := prepareChannel(channelName)
+	token, result := c.moderation.start(append(targets, channel)...)
+	defer c.moderation.end()
+
+	send(channel)
+	c.ircConn.Raw("PING :" + token)
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(MODERATION_TIMEOUT):
+		return ErrTimeout
+	}
+}
+
+// validTarget checks whether nickname or mask can be sent as a single
+// command argument
+func validTarget(target string) bool {
+	return target != "" && !strings.ContainsAny(target, " ,\r\n")
+}
+
+// Topic sets topic of channel
+func (c *Connection) Topic(channelName, topic string) error {
+	return c.moderate(channelName, func(channel string) {
+		// empty topics are sent explicitly, since a missing topic
+		// argument queries the current one
+		c.ircConn.Raw(irc.TOPIC + " " + channel + " :" + topic)
+	})
+}
+
+// Kick removes nickname from channel with an optional reason
+func (c *Connection) Kick(channelName, nickname, reason string) error {
+	if !validTarget(nickname) {
+		return ErrInvalidTarget
+	}
+
+	return c.moderate(channelName, func(channel string) {
+		c.ircConn.Kick(channel, nickname, reason)
+	}, nickname)
+}
+
+// Ban adds mask to ban list of channel
+func (c *Connection) Ban(channelName, mask string) error {
+	return c.Mode(channelName, "+b", mask)
+}
+
+// Unban removes mask from ban list of channel
+func (c *Connection) Unban(channelName, mask string) error {
+	return c.Mode(channelName, "-b", mask)
+}
+
+// Mode sets channel modes in +/- form (e.g. +mi, +o-v nick nick)
+func (c *Connection) Mode(channelName, modes string, args ...string) error {
+	if len(modes) < 2 || !strings.ContainsAny(modes[:1], "+-") || !validTarget(modes) {
+		return ErrInvalidMode
+	}
+
+	for _, arg := range args {
+		if !validTarget(arg) {
+			return ErrInvalidTarget
+		}
+	}
+
+	return c.moderate(channelName, func(channel string) {
+		c.ircConn.Mode(channel, append([]string{modes}, args...)...)
+	}, args...)
+}
+
+// Invite invites nickname to channel
+func (c *Connection) Invite(channelName, nickname string) error {
+	if !validTarget(nickname) {
+		return ErrInvalidTarget
+	}
+
+	return c.moderate(channelName, func(channel string) {
+		c.ircConn.Invite(nickname, channel)
+	}, nickname)
+}
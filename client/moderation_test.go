@@ -0,0 +1,85 @@
+package client
+
+import (
+	"testing"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+func TestModerationReplies(t *testing.T) {
+	c := NewConnection()
+	conn := irc.SimpleClient("koding-bot")
+
+	tests := []struct {
+		targets []string
+		lines   []string
+		err     error
+	}{
+		{
+			[]string{"#muppets"},
+			[]string{":server 482 koding-bot #muppets :You're not channel operator"},
+			ErrNotOperator,
+		},
+		{
+			[]string{"fozzie", "#muppets"},
+			[]string{
+				":server 401 koding-bot animal :No such nick/channel",
+				":server 441 koding-bot fozzie #muppets :They aren't on that channel",
+			},
+			ErrUserNotInChannel,
+		},
+		{
+			[]string{"#muppets"},
+			[]string{
+				":server 482 koding-bot #swedish :You're not channel operator",
+				":server PONG server :" + MODERATION_TOKEN + "0",
+				":server PONG server :" + MODERATION_TOKEN + "3",
+			},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		_, result := c.moderation.start(test.targets...)
+		for _, raw := range test.lines {
+			c.handleModeration(conn, irc.ParseLine(raw))
+		}
+
+		select {
+		case err := <-result:
+			if err != test.err {
+				t.Errorf("Expected %v but got %v", test.err, err)
+			}
+		default:
+			t.Errorf("Expected %v but got no result", test.err)
+		}
+		c.moderation.end()
+	}
+
+	// replies are ignored without ongoing operations
+	c.handleModeration(conn, irc.ParseLine(":server 482 koding-bot #muppets :You're not channel operator"))
+}
+
+func TestModerationValidation(t *testing.T) {
+	c := NewConnection()
+
+	if err := c.Kick("muppets", "fozzie bear", ""); err != ErrInvalidTarget {
+		t.Errorf("Expected %s but got %v", ErrInvalidTarget, err)
+	}
+
+	if err := c.Mode("muppets", "m"); err != ErrInvalidMode {
+		t.Errorf("Expected %s but got %v", ErrInvalidMode, err)
+	}
+
+	if err := c.Ban("muppets", ""); err != ErrInvalidTarget {
+		t.Errorf("Expected %s but got %v", ErrInvalidTarget, err)
+	}
+
+	if err := c.Topic("", "welcome"); err != ErrChannelNotSet {
+		t.Errorf("Expected %s but got %v", ErrChannelNotSet, err)
+	}
+
+	if err := c.Invite("muppets", "fozzie"); err != ErrNotConnected {
+		t.Errorf("Expected %s but got %v", ErrNotConnected, err)
+	}
+}
@@ -0,0 +1,251 @@
+package client
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/canthefason/irc-k/common"
+	irc "github.com/fluffle/goirc/client"
+)
+
+// modes which take an argument in channel mode changes. l only takes
+// an argument when it is set
+const MODE_ARGS = "ovhqabeIk"
+
+// operators tracks members and channel operators of joined channels via
+// names replies, joins and mode changes. goirc state tracker is not used,
+// since it cannot be read outside of irc event loop
+type operators struct {
+	mu sync.RWMutex
+	// channel -> nickname -> operator status. names are lower case
+	chans map[string]map[string]bool
+}
+
+func newOperators() *operators {
+	return &operators{chans: make(map[string]map[string]bool)}
+}
+
+// names sets operator status of nicknames listed in a names reply
+func (o *operators) names(channel string, nicks []string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	ops := o.channel(channel)
+	for _, nick := range nicks {
+		name := strings.TrimLeft(nick, "~&@%+")
+		if name == "" {
+			continue
+		}
+
+		// owners and admins are operators as well
+		ops[strings.ToLower(name)] = strings.ContainsAny(nick[:len(nick)-len(name)], "~&@")
+	}
+}
+
+// join adds nickname to channel members
+func (o *operators) join(channel, nick string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	ops := o.channel(channel)
+	if _, ok := ops[strings.ToLower(nick)]; !ok {
+		ops[strings.ToLower(nick)] = false
+	}
+}
+
+// mode applies operator changes in a channel mode change
+func (o *operators) mode(channel, modes string, args []string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	set := true
+	for _, r := range modes {
+		switch {
+		case r == '+':
+			set = true
+			continue
+		case r == '-':
+			set = false
+			continue
+		case strings.ContainsRune(MODE_ARGS, r), r == 'l' && set:
+		default:
+			continue
+		}
+
+		if len(args) == 0 {
+			return
+		}
+
+		arg := args[0]
+		args = args[1:]
+		if r == 'o' || r == 'q' || r == 'a' {
+			o.channel(channel)[strings.ToLower(arg)] = set
+		}
+	}
+}
+
+// rename moves status of nickname in all channels, and returns the
+// channels of nickname
+func (o *operators) rename(old, nick string) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	chans := make([]string, 0)
+	old, nick = strings.ToLower(old), strings.ToLower(nick)
+	for channel, ops := range o.chans {
+		if op, ok := ops[old]; ok {
+			delete(ops, old)
+			ops[nick] = op
+			chans = append(chans, channel)
+		}
+	}
+
+	return chans
+}
+
+// leave removes nickname from channel. All channels are removed when
+// nickname is empty
+func (o *operators) leave(channel, nick string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if nick == "" {
+		delete(o.chans, common.NormalizeChannel(channel))
+		return
+	}
+
+	delete(o.channel(channel), strings.ToLower(nick))
+}
+
+// quit removes nickname from all channels, and returns the channels
+// of nickname
+func (o *operators) quit(nick string) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	chans := make([]string, 0)
+	nick = strings.ToLower(nick)
+	for channel, ops := range o.chans {
+		if _, ok := ops[nick]; ok {
+			delete(ops, nick)
+			chans = append(chans, channel)
+		}
+	}
+
+	return chans
+}
+
+// is checks whether nickname is an operator of channel
+func (o *operators) is(channel, nick string) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.chans[common.NormalizeChannel(channel)][strings.ToLower(nick)]
+}
+
+// channel returns operators of channel. It must be called with lock held
+func (o *operators) channel(channel string) map[string]bool {
+	channel = common.NormalizeChannel(channel)
+	ops, ok := o.chans[channel]
+	if !ok {
+		ops = make(map[string]bool)
+		o.chans[channel] = ops
+	}
+
+	return ops
+}
+
+// handleOperators keeps members and operators of joined channels up to
+// date, and notifies Events with the channel changes
+func (c *Connection) handleOperators(conn *irc.Conn, line *irc.Line) {
+	me := conn.Me().Nick
+	e := common.Event{Nickname: line.Nick}
+	e.Time, e.ServerTime = messageTime(line, c.tags.pop(line.Raw))
+	switch line.Cmd {
+	case "353":
+		// :server 353 me = #channel :@op +voice nick
+		if len(line.Args) < 4 {
+			return
+		}
+		c.ops.names(line.Args[2], strings.Fields(line.Args[3]))
+	case irc.JOIN:
+		if len(line.Args) == 0 {
+			return
+		}
+		c.ops.join(line.Args[0], line.Nick)
+		e.Type = common.EVENT_JOIN
+		c.event(line.Args[0], e)
+	case irc.MODE:
+		if len(line.Args) < 2 || !strings.HasPrefix(line.Args[0], "#") {
+			return
+		}
+		c.ops.mode(line.Args[0], line.Args[1], line.Args[2:])
+		e.Type, e.Text = common.EVENT_MODE, strings.Join(line.Args[1:], " ")
+		c.event(line.Args[0], e)
+	case irc.TOPIC:
+		if len(line.Args) < 2 {
+			return
+		}
+		e.Type, e.Text = common.EVENT_TOPIC, line.Args[1]
+		c.event(line.Args[0], e)
+	case irc.NICK:
+		if len(line.Args) == 0 {
+			return
+		}
+		e.Type, e.Target = common.EVENT_NICK, line.Args[0]
+		for _, channel := range c.ops.rename(line.Nick, line.Args[0]) {
+			c.event(channel, e)
+		}
+	case irc.PART:
+		if len(line.Args) == 0 {
+			return
+		}
+		e.Type = common.EVENT_PART
+		if len(line.Args) > 1 {
+			e.Text = line.Args[1]
+		}
+		c.event(line.Args[0], e)
+		if line.Nick == me {
+			c.ops.leave(line.Args[0], "")
+			return
+		}
+		c.ops.leave(line.Args[0], line.Nick)
+	case irc.KICK:
+		if len(line.Args) < 2 {
+			return
+		}
+		e.Type, e.Target = common.EVENT_KICK, line.Args[1]
+		if len(line.Args) > 2 {
+			e.Text = line.Args[2]
+		}
+		c.event(line.Args[0], e)
+		if line.Args[1] == me {
+			c.ops.leave(line.Args[0], "")
+			return
+		}
+		c.ops.leave(line.Args[0], line.Args[1])
+	case irc.QUIT:
+		e.Type = common.EVENT_QUIT
+		if len(line.Args) > 0 {
+			e.Text = line.Args[0]
+		}
+		for _, channel := range c.ops.quit(line.Nick) {
+			c.event(channel, e)
+		}
+	}
+}
+
+// event notifies Events with the change of channel
+func (c *Connection) event(channel string, e common.Event) {
+	if c.Events == nil {
+		return
+	}
+
+	e.Channel = strings.TrimPrefix(channel, "#")
+	c.Events(e)
+}
+
+// IsOperator checks whether nickname is an operator of a joined channel
+func (c *Connection) IsOperator(channel, nickname string) bool {
+	return c.ops.is(channel, nickname)
+}
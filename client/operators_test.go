@@ -0,0 +1,97 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/canthefason/irc-k/common"
+	irc "github.com/fluffle/goirc/client"
+)
+
+func TestOperators(t *testing.T) {
+	c := NewConnection()
+	conn := irc.SimpleClient("koding-bot")
+
+	lines := []string{
+		":server 353 koding-bot = #muppets :@kermit +piggy ~statler fozzie",
+		":kermit!k@muppets MODE #muppets +o-o+b fozzie kermit *!*@swedish",
+		":statler!s@balcony NICK waldorf",
+	}
+	for _, raw := range lines {
+		c.handleOperators(conn, irc.ParseLine(raw))
+	}
+
+	tests := []struct {
+		nick string
+		op   bool
+	}{
+		{"Kermit", false},
+		{"piggy", false},
+		{"fozzie", true},
+		{"statler", false},
+		{"waldorf", true},
+	}
+
+	for _, test := range tests {
+		if op := c.IsOperator("#Muppets", test.nick); op != test.op {
+			t.Errorf("Expected %t but got %t for %s", test.op, op, test.nick)
+		}
+	}
+
+	c.handleOperators(conn, irc.ParseLine(":waldorf!s@balcony KICK #muppets koding-bot :bye"))
+	if c.IsOperator("muppets", "fozzie") {
+		t.Error("Expected kicked channel to be removed")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	c := NewConnection()
+	conn := irc.SimpleClient("koding-bot")
+
+	events := make([]common.Event, 0)
+	c.Events = func(e common.Event) {
+		events = append(events, e)
+	}
+
+	lines := []string{
+		":server 353 koding-bot = #muppets :@kermit fozzie",
+		":server 353 koding-bot = #kitchen :chef fozzie",
+		":piggy!p@muppets JOIN #muppets",
+		":kermit!k@muppets TOPIC #muppets :It's time to play the music",
+		":fozzie!f@muppets NICK fozzie_",
+		":kermit!k@muppets KICK #muppets piggy :hi-yah",
+		":fozzie_!f@muppets QUIT :wocka wocka",
+	}
+	for _, raw := range lines {
+		c.handleOperators(conn, irc.ParseLine(raw))
+	}
+
+	expected := []common.Event{
+		{Type: common.EVENT_JOIN, Channel: "muppets", Nickname: "piggy"},
+		{Type: common.EVENT_TOPIC, Channel: "muppets", Nickname: "kermit", Text: "It's time to play the music"},
+		{Type: common.EVENT_NICK, Nickname: "fozzie", Target: "fozzie_"},
+		{Type: common.EVENT_NICK, Nickname: "fozzie", Target: "fozzie_"},
+		{Type: common.EVENT_KICK, Channel: "muppets", Nickname: "kermit", Target: "piggy", Text: "hi-yah"},
+		{Type: common.EVENT_QUIT, Nickname: "fozzie_", Text: "wocka wocka"},
+		{Type: common.EVENT_QUIT, Nickname: "fozzie_", Text: "wocka wocka"},
+	}
+
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d but got %d", len(expected), len(events))
+	}
+
+	for i, e := range expected {
+		actual := events[i]
+		// nick changes and quits are notified for each channel of nickname
+		if e.Channel == "" && actual.Channel != "muppets" && actual.Channel != "kitchen" {
+			t.Errorf("Expected muppets or kitchen but got %s", actual.Channel)
+		}
+		actual.Time = e.Time
+		if e.Channel == "" {
+			actual.Channel = ""
+		}
+
+		if actual != e {
+			t.Errorf("Expected %v but got %v", e, actual)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// relay forwards irc traffic between goirc and server through a loopback
+// listener. goirc connects to the listener instead of the server, and
+// tags of received lines are moved into the tag queue before goirc
+// parses them
+type relay struct {
+	listener net.Listener
+	server   net.Conn
+	tags     *tagQueue
+}
+
+// dialServer connects to irc server. Default ports are used when server
+// does not have a port
+func dialServer(server string, plainText bool) (net.Conn, error) {
+	port := "6697"
+	if plainText {
+		port = "6667"
+	}
+
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, port)
+	}
+
+	dialer := &net.Dialer{Timeout: CONN_TIMEOUT}
+	if plainText {
+		return dialer.Dial("tcp", server)
+	}
+
+	return tls.DialWithDialer(dialer, "tcp", server, nil)
+}
+
+// newRelay creates a relay of server connection listening on a loopback
+// address
+func newRelay(server net.Conn, tags *tagQueue) (*relay, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	return &relay{listener: l, server: server, tags: tags}, nil
+}
+
+// Addr returns the address goirc connects to
+func (r *relay) Addr() string {
+	return r.listener.Addr().String()
+}
+
+// serve accepts the goirc connection and forwards lines until one of
+// the connections is closed
+func (r *relay) serve() error {
+	defer r.listener.Close()
+
+	// the listener is only used by the goirc connection
+	r.listener.(*net.TCPListener).SetDeadline(time.Now().Add(CONN_TIMEOUT))
+	local, err := r.listener.Accept()
+	if err != nil {
+		r.server.Close()
+		return err
+	}
+
+	closeAll := func() {
+		local.Close()
+		r.server.Close()
+	}
+
+	go func() {
+		io.Copy(r.server, local)
+		closeAll()
+	}()
+
+	defer closeAll()
+	reader := bufio.NewReader(r.server)
+	for {
+		s, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		// goirc cannot parse empty lines
+		line, tags := splitTags(strings.Trim(s, "\r\n"))
+		if line == "" {
+			continue
+		}
+
+		r.tags.push(line, tags)
+		if _, err := io.WriteString(local, line+"\r\n"); err != nil {
+			return err
+		}
+	}
+}
@@ -2,66 +2,184 @@ package client
 
 import (
 	"encoding/json"
-	"log"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/formatting"
+	"github.com/canthefason/irc-k/logger"
 	"gopkg.in/redis.v2"
 )
 
+const (
+	// subscription is requested but not yet confirmed by redis
+	STATE_PENDING = "pending"
+
+	// subscription is confirmed by redis
+	STATE_SUBSCRIBED = "subscribed"
+
+	// subscription is ended
+	STATE_UNSUBSCRIBED = "unsubscribed"
+)
+
 // Subscriber holds the receive channel for fetching
 // messages of subscribed channels
 type Subscriber struct {
 	// message reception channel
 	Rcv chan common.Message
 
+	// Log is used for subscriber logs
+	Log logger.Logger
+
+	// Format of received message bodies. It is one of raw, plain, html
+	// and markdown formats. By default irc formatting codes are kept
+	Format string
+
 	redisConn *redis.Client
 	ps        *redis.PubSub
+	buffer    *common.Buffer
 	quit      bool
+
+	// subscription states of channels and patterns
+	mu       sync.RWMutex
+	channels map[string]string
+	patterns map[string]string
+}
+
+// Subscription holds the state of a channel or pattern subscription
+type Subscription struct {
+	Name    string `json:"name"`
+	Pattern bool   `json:"pattern"`
+	State   string `json:"state"`
 }
 
 // NewSubscriber creates redis and pubsub connections and opens
-// receive channel
+// an unbuffered receive channel
 func NewSubscriber(r *common.RedisConf) *Subscriber {
+	return NewBufferedSubscriber(r, &common.BufferConf{})
+}
+
+// NewBufferedSubscriber creates redis and pubsub connections and opens
+// receive channel with given buffer size and overflow policy
+func NewBufferedSubscriber(r *common.RedisConf, b *common.BufferConf) *Subscriber {
 	s := new(Subscriber)
 
 	s.redisConn = common.Initialize(r)
-	s.Rcv = make(chan common.Message, 0)
+	s.buffer = common.NewBuffer(b)
+	s.buffer.Name = "subscriber"
+	s.Rcv = s.buffer.C
 	s.ps = s.redisConn.PubSub()
+	s.Log = logger.New("subscriber")
+	s.channels = make(map[string]string)
+	s.patterns = make(map[string]string)
 
 	return s
 }
 
 // Subscribe used for subscribing a user to given channel messages.
-func (s *Subscriber) Subscribe(channel string) error {
-	if channel == "" {
-		return ErrChannelNotSet
+// Multiple channels can be subscribed at once.
+func (s *Subscriber) Subscribe(channels ...string) error {
+	channels, err := prepareNames(channels)
+	if err != nil {
+		return err
+	}
+
+	// subscribe user to given channels for receiving channel messages
+	if err := s.ps.Subscribe(keysWithPrefix(channels)...); err != nil {
+		return err
 	}
+	s.setState(s.channels, channels, STATE_PENDING)
+
+	for _, channel := range channels {
+		if err := s.request(channel); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-	// subscribe user to given channel for receiving channel messages
-	err := s.ps.Subscribe(common.KeyWithPrefix(channel))
+// PSubscribe subscribes a user to messages of channels matching given glob
+// patterns (e.g. team-*). Since patterns do not point to any particular
+// channel, bots are not requested to join any channels.
+func (s *Subscriber) PSubscribe(patterns ...string) error {
+	patterns, err := prepareNames(patterns)
 	if err != nil {
 		return err
 	}
 
+	if err := s.ps.PSubscribe(keysWithPrefix(patterns)...); err != nil {
+		return err
+	}
+	s.setState(s.patterns, patterns, STATE_PENDING)
+
+	return nil
+}
+
+// Unsubscribe stops receiving messages of given channels. Bots stay
+// in the channels.
+func (s *Subscriber) Unsubscribe(channels ...string) error {
+	channels, err := prepareNames(channels)
+	if err != nil {
+		return err
+	}
+
+	return s.ps.Unsubscribe(keysWithPrefix(channels)...)
+}
+
+// PUnsubscribe stops receiving messages of given patterns.
+func (s *Subscriber) PUnsubscribe(patterns ...string) error {
+	patterns, err := prepareNames(patterns)
+	if err != nil {
+		return err
+	}
+
+	return s.ps.PUnsubscribe(keysWithPrefix(patterns)...)
+}
+
+// Channels returns the subscription state of each subscribed channel
+// and pattern ordered by name.
+func (s *Subscriber) Channels() []Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subs := make([]Subscription, 0, len(s.channels)+len(s.patterns))
+	for name, state := range s.channels {
+		subs = append(subs, Subscription{Name: name, State: state})
+	}
+	for name, state := range s.patterns {
+		subs = append(subs, Subscription{Name: name, Pattern: true, State: state})
+	}
+	sort.Sort(byName(subs))
+
+	return subs
+}
+
+// request adds channel to requested channels and queues it for
+// feeder connections. Requests of subscribers are not tied to users, see
+// follow package for per user channels
+func (s *Subscriber) request(channel string) error {
 	// add subscribed channel to requested channels set. it is used for
 	// preventing duplicate channel connections
-	response := s.redisConn.SAdd(common.KeyWithPrefix(common.REQ_CHANNELS_KEY), channel)
+	response := s.redisConn.SAdd(common.KeyWithPrefix(common.REQ_CHANNELS_KEY), common.NormalizeChannel(channel))
 	if response.Err() != nil {
 		return response.Err()
 	}
 
-	if response.Val() == 0 {
-		log.Printf("bot is already connected to channel: %s", channel)
-		return nil
+	// followed channels are already joined by feeder bots
+	followed := s.redisConn.HExists(common.KeyWithPrefix(common.FOLLOWED_CHANNELS_KEY), common.NormalizeChannel(channel))
+	if followed.Err() != nil {
+		return followed.Err()
 	}
 
-	// queue channel name for feeder connection. this queue is consumed by feeder workers.
-	if err := common.MustGetQueue().Queue(channel); err != nil {
-		return err
+	if response.Val() == 0 || followed.Val() {
+		s.Log.New("channel", channel).Debug("Bot is already connected to channel")
+		return nil
 	}
 
-	return nil
+	// queue channel name for feeder connection. this queue is consumed by feeder workers.
+	return common.MustGetQueue().Queue(channel)
 }
 
 // Listen starts listening channel messages in blocking manner.
@@ -77,23 +195,82 @@ func (s *Subscriber) Listen() {
 			panic(err)
 		}
 
-		// res also returns subscription events.
-		// we are ignoring them and just listening messages here
+		// res also returns subscription events. they are used for
+		// tracking channel subscription states
 		switch res.(type) {
+		case *redis.Subscription:
+			s.updateState(res.(*redis.Subscription))
 		case *redis.Message:
 			rm := res.(*redis.Message)
-			msg := common.Message{}
-			err := json.Unmarshal([]byte(rm.Payload), &msg)
-			if err != nil {
-				log.Printf("Could not unmarshal received message: %s", err)
-				continue
+			if !s.deliver(rm.Channel, rm.Payload) {
+				return
+			}
+		case *redis.PMessage:
+			rm := res.(*redis.PMessage)
+			if !s.deliver(rm.Channel, rm.Payload) {
+				return
 			}
-			msg.Channel = removePrefix(rm.Channel)
-			s.Rcv <- msg
 		}
 	}
 }
 
+// deliver unmarshals received payload and pushes it to receive channel.
+// It returns false when subscriber is disconnected.
+func (s *Subscriber) deliver(channel, payload string) bool {
+	msg := common.Message{}
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		s.Log.New("channel", channel).Warn("Could not unmarshal received message: %s", err)
+		return true
+	}
+
+	msg.Channel = removePrefix(channel)
+	subscribedMessages.Inc(msg.Channel)
+
+	body, err := formatting.Convert(msg.Body, s.Format)
+	if err != nil {
+		s.Log.Warn("Could not convert message body: %s", err)
+	} else {
+		msg.Body = body
+	}
+	if err := s.buffer.Push(msg); err != nil {
+		s.Log.Warn("Disconnecting: %s", err)
+		s.Close()
+		return false
+	}
+
+	return true
+}
+
+// updateState updates channel states with received subscription events
+func (s *Subscriber) updateState(sub *redis.Subscription) {
+	name := removePrefix(sub.Channel)
+	switch sub.Kind {
+	case "subscribe":
+		s.setState(s.channels, []string{name}, STATE_SUBSCRIBED)
+	case "unsubscribe":
+		s.setState(s.channels, []string{name}, STATE_UNSUBSCRIBED)
+	case "psubscribe":
+		s.setState(s.patterns, []string{name}, STATE_SUBSCRIBED)
+	case "punsubscribe":
+		s.setState(s.patterns, []string{name}, STATE_UNSUBSCRIBED)
+	}
+}
+
+func (s *Subscriber) setState(states map[string]string, names []string, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, name := range names {
+		states[name] = state
+	}
+}
+
+// Dropped returns the number of received messages discarded due to
+// buffer overflow
+func (s *Subscriber) Dropped() uint64 {
+	return s.buffer.Dropped()
+}
+
 // Close ends pub/sub and redis connections and sets quit flag to true
 func (s *Subscriber) Close() error {
 	s.quit = true
@@ -105,3 +282,38 @@ func (s *Subscriber) Close() error {
 func removePrefix(channel string) string {
 	return strings.Replace(channel, common.PREFIX+":", "", 1)
 }
+
+// keysWithPrefix adds redis cache key prefix to all given names
+func keysWithPrefix(names []string) []string {
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = common.KeyWithPrefix(name)
+	}
+
+	return keys
+}
+
+// prepareNames validates channel names and patterns, and removes
+// leading # characters
+func prepareNames(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, ErrChannelNotSet
+	}
+
+	prepared := make([]string, len(names))
+	for i, name := range names {
+		name = strings.TrimPrefix(name, "#")
+		if name == "" {
+			return nil, ErrChannelNotSet
+		}
+		prepared[i] = name
+	}
+
+	return prepared, nil
+}
+
+type byName []Subscription
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byName) Less(i, j int) bool { return b[i].Name < b[j].Name }
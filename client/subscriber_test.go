@@ -52,12 +52,12 @@ func TestUserSubscribeValidation(t *testing.T) {
 
 	err := s.Subscribe("")
 	if err != ErrChannelNotSet {
-		t.Error("Expected nil but got %s", err)
+		t.Errorf("Expected nil but got %s", err)
 	}
 
 	err = s.Subscribe("canthefason-test")
 	if err != nil {
-		t.Error("Expected nil but got %s", err)
+		t.Errorf("Expected nil but got %s", err)
 	}
 }
 
@@ -124,7 +124,7 @@ func TestListenChannel(t *testing.T) {
 	}()
 
 	if err := common.Send(m); err != nil {
-		t.Error("Expected nil but got %s", err)
+		t.Errorf("Expected nil but got %s", err)
 	}
 
 	select {
@@ -133,3 +133,100 @@ func TestListenChannel(t *testing.T) {
 		t.Error("Expected message but connection timeout")
 	}
 }
+
+func TestSubscribeMultipleChannels(t *testing.T) {
+	s := tearUp()
+	defer tearDown(s)
+
+	err := s.Subscribe("muppet-kitchen", "")
+	if err != ErrChannelNotSet {
+		t.Errorf("Expected %s but got %s", ErrChannelNotSet, err)
+	}
+
+	err = s.Subscribe("muppet-kitchen", "#muppet-theater")
+	if err != nil {
+		t.Errorf("Expected nil but got %s", err)
+		t.FailNow()
+	}
+
+	length, err := common.MustGetQueue().Len()
+	if err != nil {
+		t.Errorf("Expected nil but got %s", err)
+	}
+
+	if length != 2 {
+		t.Errorf("Expected %d but got %d", 2, length)
+	}
+
+	channels := s.Channels()
+	if len(channels) != 2 {
+		t.Errorf("Expected %d channels but got %d", 2, len(channels))
+		t.FailNow()
+	}
+
+	if channels[1].Name != "muppet-theater" {
+		t.Errorf("Expected %s but got %s", "muppet-theater", channels[1].Name)
+	}
+}
+
+func TestPSubscribe(t *testing.T) {
+	s := tearUp()
+	if err := s.PSubscribe(); err != ErrChannelNotSet {
+		t.Errorf("Expected %s but got %s", ErrChannelNotSet, err)
+	}
+
+	if err := s.PSubscribe("muppet-*"); err != nil {
+		t.Errorf("Expected nil but got %s", err)
+	}
+	go s.Listen()
+	defer tearDown(s)
+
+	length, _ := common.MustGetQueue().Len()
+	if length != 0 {
+		t.Errorf("Expected %d but got %d", 0, length)
+	}
+
+	m := common.Message{}
+	m.Nickname = "animal"
+	m.Body = "drums!"
+	m.Channel = "muppet-show"
+
+	// wait for subscription confirmation
+	for i := 0; i < 20 && s.Channels()[0].State != STATE_SUBSCRIBED; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	channels := s.Channels()
+	if !channels[0].Pattern || channels[0].State != STATE_SUBSCRIBED {
+		t.Errorf("Expected subscribed pattern but got %+v", channels[0])
+	}
+
+	if err := common.Send(m); err != nil {
+		t.Errorf("Expected nil but got %s", err)
+	}
+
+	select {
+	case msg := <-s.Rcv:
+		if msg.Channel != m.Channel {
+			t.Errorf("Expected %s as channel but got %s", m.Channel, msg.Channel)
+		}
+	case <-time.After(time.Second * 2):
+		t.Error("Expected message but connection timeout")
+	}
+}
+
+func TestPrepareNames(t *testing.T) {
+	names, err := prepareNames([]string{"#team-*", "labs"})
+	if err != nil {
+		t.Errorf("Expected nil but got %s", err)
+		t.FailNow()
+	}
+
+	if names[0] != "team-*" || names[1] != "labs" {
+		t.Errorf("Expected %v but got %v", []string{"team-*", "labs"}, names)
+	}
+
+	if _, err := prepareNames([]string{"#"}); err != ErrChannelNotSet {
+		t.Errorf("Expected %s but got %s", ErrChannelNotSet, err)
+	}
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+// maximum number of received lines waiting for their tags to be read
+const MAX_TAGGED_LINES = 256
+
+// tagEscapes unescapes IRCv3 message tag values
+var tagEscapes = strings.NewReplacer(`\:`, ";", `\s`, " ", `\r`, "\r", `\n`, "\n", `\\`, `\`)
+
+// parseTags returns the IRCv3 message tags of raw line. Lines without
+// tags return nil. See http://ircv3.net/specs/core/message-tags-3.2.html
+func parseTags(raw string) map[string]string {
+	if !strings.HasPrefix(raw, "@") {
+		return nil
+	}
+
+	idx := strings.Index(raw, " ")
+	if idx == -1 {
+		return nil
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(raw[1:idx], ";") {
+		if tag == "" {
+			continue
+		}
+
+		pair := strings.SplitN(tag, "=", 2)
+		if len(pair) < 2 {
+			tags[tag] = ""
+			continue
+		}
+		tags[pair[0]] = tagEscapes.Replace(pair[1])
+	}
+
+	return tags
+}
+
+// splitTags separates IRCv3 message tags from raw line. Lines without
+// tags are returned as they are
+func splitTags(raw string) (string, map[string]string) {
+	tags := parseTags(raw)
+	if tags == nil {
+		return raw, nil
+	}
+
+	return strings.TrimLeft(raw[strings.Index(raw, " ")+1:], " "), tags
+}
+
+// messageTime returns server-time tag of line, or its reception time.
+// The returned flag is set when server time is used
+func messageTime(line *irc.Line, tags map[string]string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339Nano, tags["time"]); err == nil {
+		return t.UTC(), true
+	}
+
+	return line.Time.UTC(), false
+}
+
+// taggedLine is a received line stored with its tags. Raw line does not
+// contain tags
+type taggedLine struct {
+	raw  string
+	tags map[string]string
+}
+
+// tagQueue holds tags of received lines in reception order. The vendored
+// goirc does not parse IRCv3 tags, so tags are stripped by relay before
+// lines reach goirc, and handlers read them from the queue
+type tagQueue struct {
+	mu    sync.Mutex
+	lines []taggedLine
+}
+
+func newTagQueue() *tagQueue {
+	return &tagQueue{lines: make([]taggedLine, 0)}
+}
+
+// push adds tags of a received line. Untagged lines are also added, so
+// that they are not matched with tags of identical lines
+func (q *tagQueue) push(raw string, tags map[string]string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.lines) == MAX_TAGGED_LINES {
+		q.lines = q.lines[1:]
+	}
+	q.lines = append(q.lines, taggedLine{raw: raw, tags: tags})
+}
+
+// pop returns tags of the oldest line matching raw. goirc handlers are
+// run in reception order, so lines received before it were not handled
+// and they are discarded. Connections which are not connected do not
+// have queues
+func (q *tagQueue) pop(raw string) map[string]string {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, line := range q.lines {
+		if line.raw == raw {
+			q.lines = q.lines[i+1:]
+			return line.tags
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,399 @@
+// Package command routes channel messages to registered bot commands.
+//
+// Commands are invoked either with a prefix ("!deploy api") or by
+// addressing the bot ("koding-bot: deploy api"). Arguments are split by
+// whitespace, and double quoted arguments may contain spaces.
+//
+// Each command has a permission. Operator commands can be run by channel
+// operators and admins, and admin commands can only be run by the
+// configured admin nicknames and services accounts. Commands may also
+// have a cooldown per channel.
+package command
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/logger"
+	"github.com/canthefason/irc-k/metrics"
+)
+
+const (
+	// PERMISSION_ANYONE commands can be run by everyone
+	PERMISSION_ANYONE = "anyone"
+
+	// PERMISSION_OPERATOR commands can be run by channel operators
+	// and admins
+	PERMISSION_OPERATOR = "operator"
+
+	// PERMISSION_ADMIN commands can only be run by admins
+	PERMISSION_ADMIN = "admin"
+
+	// name of the built-in help command
+	HELP = "help"
+)
+
+var (
+	ErrInvalidName       = errors.New("invalid command name")
+	ErrInvalidPermission = errors.New("invalid permission")
+	ErrHandlerNotSet     = errors.New("handler not set")
+	ErrDuplicateCommand  = errors.New("command already registered")
+	ErrPermissionDenied  = errors.New("permission denied")
+
+	runs = metrics.NewCounter("irck_commands_total",
+		"Bot commands by result", "command", "result")
+)
+
+// Command is a bot command
+type Command struct {
+	// Name is used for invoking command
+	Name string
+	// Usage describes arguments, e.g. "<service> [environment]"
+	Usage string
+	// Help is a short description of command
+	Help string
+	// Permission is PERMISSION_ANYONE when it is empty
+	Permission string
+	// Cooldown is the minimum duration between runs in a channel
+	Cooldown time.Duration
+	// Args is the minimum number of arguments
+	Args int
+	// Handler runs command. Returned errors are replied to the user
+	Handler func(ctx *Context) error
+}
+
+// Validate checks name, permission and handler of command
+func (c *Command) Validate() error {
+	if c.Name == "" || strings.ContainsAny(c.Name, " \t") {
+		return ErrInvalidName
+	}
+
+	switch c.Permission {
+	case "", PERMISSION_ANYONE, PERMISSION_OPERATOR, PERMISSION_ADMIN:
+	default:
+		return ErrInvalidPermission
+	}
+
+	if c.Handler == nil {
+		return ErrHandlerNotSet
+	}
+
+	return nil
+}
+
+// Context holds an invocation of command
+type Context struct {
+	// Message which invoked command
+	Message common.Message
+	Command *Command
+	Args    []string
+
+	router *Router
+}
+
+// Reply sends a message to the channel of invocation. Replies are
+// addressed to the user who ran the command
+func (ctx *Context) Reply(format string, args ...interface{}) {
+	ctx.router.reply(ctx.Message, fmt.Sprintf(format, args...))
+}
+
+// Router parses channel messages and runs matching commands
+type Router struct {
+	Conf *common.CommandConf
+
+	// Names are nicknames of the bot used for addressing it
+	Names []string
+
+	// Send is used for replies
+	Send func(channel, body string)
+
+	// IsOperator checks channel operator status of nicknames. Operator
+	// commands are restricted to admins when it is nil
+	IsOperator func(channel, nickname string) bool
+
+	Log logger.Logger
+
+	mu       sync.Mutex
+	commands map[string]*Command
+	// last run times by command and channel
+	lastRun map[string]time.Time
+}
+
+// NewRouter creates a router with the built-in help command
+func NewRouter(conf *common.CommandConf) *Router {
+	r := &Router{
+		Conf:     conf,
+		Log:      logger.New("command"),
+		commands: make(map[string]*Command),
+		lastRun:  make(map[string]time.Time),
+	}
+
+	r.Register(&Command{
+		Name:    HELP,
+		Usage:   "[command]",
+		Help:    "Lists commands, or shows usage of a command",
+		Handler: r.help,
+	})
+
+	return r
+}
+
+// Register adds command to router. Command names are case insensitive
+func (r *Router) Register(c *Command) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := strings.ToLower(c.Name)
+	if _, ok := r.commands[name]; ok {
+		return ErrDuplicateCommand
+	}
+	r.commands[name] = c
+
+	return nil
+}
+
+// Handle runs the command invoked by message. It returns false when
+// message is not a command invocation. Commands are run asynchronously
+func (r *Router) Handle(m common.Message) bool {
+	// backfilled messages are already handled
+	if m.History {
+		return false
+	}
+
+	name, args, ok := r.parse(m.Body)
+	if !ok {
+		return false
+	}
+
+	r.mu.Lock()
+	c, ok := r.commands[strings.ToLower(name)]
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	ctx := &Context{Message: m, Command: c, Args: args, router: r}
+	go r.run(ctx)
+
+	return true
+}
+
+// run checks permission, arguments and cooldown of command and runs it
+func (r *Router) run(ctx *Context) {
+	c, m := ctx.Command, ctx.Message
+	log := r.Log.New("command", c.Name).New("channel", m.Channel).New("nickname", m.Nickname)
+
+	if !r.allowed(c, m) {
+		log.Info("Command is not allowed")
+		runs.Inc(c.Name, "denied")
+		ctx.Reply("%s", ErrPermissionDenied)
+		return
+	}
+
+	if len(ctx.Args) < c.Args {
+		runs.Inc(c.Name, "invalid")
+		ctx.Reply("usage: %s", r.usage(c))
+		return
+	}
+
+	if !r.cool(c, m.Channel) {
+		log.Debug("Command is cooling down")
+		runs.Inc(c.Name, "cooldown")
+		return
+	}
+
+	if err := c.Handler(ctx); err != nil {
+		log.Warn("Command failed: %s", err)
+		runs.Inc(c.Name, "failure")
+		ctx.Reply("%s", err)
+		return
+	}
+
+	runs.Inc(c.Name, "success")
+}
+
+// parse returns command name and arguments of body
+func (r *Router) parse(body string) (string, []string, bool) {
+	body = strings.TrimSpace(body)
+	rest, ok := r.strip(body)
+	if !ok {
+		return "", nil, false
+	}
+
+	fields := splitArgs(rest)
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+
+	return fields[0], fields[1:], true
+}
+
+// strip removes command prefix or bot address from body
+func (r *Router) strip(body string) (string, bool) {
+	if r.Conf.Prefix != "" && strings.HasPrefix(body, r.Conf.Prefix) {
+		return body[len(r.Conf.Prefix):], true
+	}
+
+	for _, name := range r.Names {
+		if len(body) <= len(name) || !strings.EqualFold(body[:len(name)], name) {
+			continue
+		}
+
+		switch body[len(name)] {
+		case ':', ',':
+			return body[len(name)+1:], true
+		}
+	}
+
+	return "", false
+}
+
+// allowed checks permission of message sender for command
+func (r *Router) allowed(c *Command, m common.Message) bool {
+	if r.admin(m) {
+		return true
+	}
+
+	switch c.Permission {
+	case PERMISSION_ADMIN:
+		return false
+	case PERMISSION_OPERATOR:
+		return r.IsOperator != nil && r.IsOperator(m.Channel, m.Nickname)
+	default:
+		return true
+	}
+}
+
+// admin checks whether sender of message is a configured admin
+func (r *Router) admin(m common.Message) bool {
+	for _, nick := range r.Conf.Admin {
+		if strings.EqualFold(nick, m.Nickname) {
+			return true
+		}
+	}
+
+	if m.Account == "" {
+		return false
+	}
+
+	for _, account := range r.Conf.Account {
+		if strings.EqualFold(account, m.Account) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cool checks cooldown of command in channel, and records the run
+func (r *Router) cool(c *Command, channel string) bool {
+	if c.Cooldown == 0 {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := strings.ToLower(c.Name) + ":" + strings.ToLower(channel)
+	now := time.Now()
+	if now.Sub(r.lastRun[key]) < c.Cooldown {
+		return false
+	}
+	r.lastRun[key] = now
+
+	return true
+}
+
+// reply sends body to the channel of message
+func (r *Router) reply(m common.Message, body string) {
+	if r.Send == nil {
+		return
+	}
+
+	r.Send(m.Channel, fmt.Sprintf("%s: %s", m.Nickname, body))
+}
+
+// usage returns invocation syntax of command
+func (r *Router) usage(c *Command) string {
+	prefix := r.Conf.Prefix
+	if prefix == "" && len(r.Names) > 0 {
+		prefix = r.Names[0] + ": "
+	}
+
+	if c.Usage == "" {
+		return prefix + c.Name
+	}
+
+	return fmt.Sprintf("%s%s %s", prefix, c.Name, c.Usage)
+}
+
+// help lists commands which sender can run, or describes a command
+func (r *Router) help(ctx *Context) error {
+	r.mu.Lock()
+	commands := make([]*Command, 0, len(r.commands))
+	for _, c := range r.commands {
+		commands = append(commands, c)
+	}
+	r.mu.Unlock()
+
+	if len(ctx.Args) > 0 {
+		for _, c := range commands {
+			if strings.EqualFold(c.Name, ctx.Args[0]) {
+				ctx.Reply("%s - %s", r.usage(c), c.Help)
+				return nil
+			}
+		}
+
+		ctx.Reply("unknown command %s", ctx.Args[0])
+		return nil
+	}
+
+	names := make([]string, 0, len(commands))
+	for _, c := range commands {
+		if r.allowed(c, ctx.Message) {
+			names = append(names, c.Name)
+		}
+	}
+	sort.Strings(names)
+	ctx.Reply("commands: %s", strings.Join(names, ", "))
+
+	return nil
+}
+
+// splitArgs splits s by whitespace. Double quoted parts are not split
+func splitArgs(s string) []string {
+	args := make([]string, 0)
+	var current []rune
+	quoted, started := false, false
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			started = true
+		case !quoted && (r == ' ' || r == '\t'):
+			if started {
+				args = append(args, string(current))
+				current, started = current[:0], false
+			}
+		default:
+			current = append(current, r)
+			started = true
+		}
+	}
+
+	if started {
+		args = append(args, string(current))
+	}
+
+	return args
+}
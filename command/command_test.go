@@ -0,0 +1,143 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+func newTestRouter() (*Router, chan string) {
+	replies := make(chan string, 10)
+	r := NewRouter(&common.CommandConf{Prefix: "!", Account: []string{"kermit"}})
+	r.Names = []string{"koding-bot"}
+	r.Send = func(channel, body string) { replies <- body }
+	r.IsOperator = func(channel, nick string) bool { return nick == "piggy" }
+
+	return r, replies
+}
+
+func expectReply(t *testing.T, replies chan string, expected string) {
+	select {
+	case reply := <-replies:
+		if reply != expected {
+			t.Errorf("Expected %q but got %q", expected, reply)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected %q but got nothing", expected)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	args := splitArgs(` deploy  "api server" prod ""`)
+	expected := []string{"deploy", "api server", "prod", ""}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %q but got %q", expected, args)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	r, replies := newTestRouter()
+	err := r.Register(&Command{
+		Name:  "echo",
+		Usage: "<text>",
+		Args:  1,
+		Handler: func(ctx *Context) error {
+			ctx.Reply("%s", ctx.Args[0])
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if err := r.Register(&Command{Name: "Echo", Handler: func(*Context) error { return nil }}); err != ErrDuplicateCommand {
+		t.Errorf("Expected %s but got %v", ErrDuplicateCommand, err)
+	}
+
+	m := common.Message{Nickname: "fozzie", Channel: "muppets"}
+	for _, body := range []string{"hello", "koding-botx: echo hi", "!unknown"} {
+		m.Body = body
+		if r.Handle(m) {
+			t.Errorf("Expected %q not to be handled", body)
+		}
+	}
+
+	m.Body = "Koding-Bot: echo \"wocka wocka\""
+	if !r.Handle(m) {
+		t.Fatal("Expected message to be handled")
+	}
+	expectReply(t, replies, "fozzie: wocka wocka")
+
+	m.Body = "!echo"
+	r.Handle(m)
+	expectReply(t, replies, "fozzie: usage: !echo <text>")
+
+	m.Body = "!help"
+	r.Handle(m)
+	expectReply(t, replies, "fozzie: commands: echo, help")
+
+	m.Body = "!echo hi"
+	m.History = true
+	if r.Handle(m) {
+		t.Error("Expected history message not to be handled")
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	r, replies := newTestRouter()
+	for _, perm := range []string{PERMISSION_OPERATOR, PERMISSION_ADMIN} {
+		perm := perm
+		r.Register(&Command{
+			Name:       perm,
+			Permission: perm,
+			Handler: func(ctx *Context) error {
+				ctx.Reply("ok")
+				return nil
+			},
+		})
+	}
+
+	tests := []struct {
+		m        common.Message
+		expected string
+	}{
+		{common.Message{Nickname: "fozzie", Body: "!operator"}, "fozzie: permission denied"},
+		{common.Message{Nickname: "piggy", Body: "!operator"}, "piggy: ok"},
+		{common.Message{Nickname: "piggy", Body: "!admin"}, "piggy: permission denied"},
+		{common.Message{Nickname: "kermit^", Account: "Kermit", Body: "!admin"}, "kermit^: ok"},
+	}
+
+	for _, test := range tests {
+		r.Handle(test.m)
+		expectReply(t, replies, test.expected)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	r, replies := newTestRouter()
+	r.Register(&Command{
+		Name:     "coffee",
+		Cooldown: time.Hour,
+		Handler: func(ctx *Context) error {
+			ctx.Reply("brewing")
+			return nil
+		},
+	})
+
+	m := common.Message{Nickname: "fozzie", Channel: "muppets", Body: "!coffee"}
+	r.Handle(m)
+	expectReply(t, replies, "fozzie: brewing")
+
+	r.Handle(m)
+	m.Channel = "kitchen"
+	r.Handle(m)
+	expectReply(t, replies, "fozzie: brewing")
+
+	select {
+	case reply := <-replies:
+		t.Errorf("Expected no reply but got %q", reply)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,138 @@
+package common
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+const (
+	// producer waits until consumer reads the message
+	POLICY_BLOCK = "block"
+
+	// oldest buffered message is discarded in favour of the new one
+	POLICY_DROP_OLDEST = "drop-oldest"
+
+	// new message is discarded when buffer is full
+	POLICY_DROP_NEWEST = "drop-newest"
+
+	// slow consumer is disconnected when buffer is full
+	POLICY_DISCONNECT = "disconnect"
+)
+
+var (
+	ErrInvalidPolicy = errors.New("invalid overflow policy")
+	ErrInvalidSize   = errors.New("invalid buffer size")
+	ErrSlowConsumer  = errors.New("slow consumer")
+)
+
+// BufferConf holds message buffer size and overflow policy
+type BufferConf struct {
+	Size   int
+	Policy string
+}
+
+// Validate checks buffer size and policy. Empty policy is treated as block
+func (b *BufferConf) Validate() error {
+	if b.Size < 0 {
+		return ErrInvalidSize
+	}
+
+	switch b.Policy {
+	case "", POLICY_BLOCK, POLICY_DROP_OLDEST, POLICY_DROP_NEWEST, POLICY_DISCONNECT:
+		return nil
+	default:
+		return ErrInvalidPolicy
+	}
+}
+
+// Buffer is a bounded message channel with an overflow policy.
+// Messages are consumed from C.
+type Buffer struct {
+	C chan Message
+
+	// Name is used for labeling dropped message metrics
+	Name string
+
+	policy  string
+	dropped uint64
+}
+
+// NewBuffer creates a buffer with given configuration. When conf is nil
+// an unbuffered, blocking buffer is created.
+func NewBuffer(conf *BufferConf) *Buffer {
+	b := new(Buffer)
+	b.policy = POLICY_BLOCK
+	if conf == nil {
+		b.C = make(chan Message, 0)
+		return b
+	}
+
+	if conf.Policy != "" {
+		b.policy = conf.Policy
+	}
+
+	size := conf.Size
+	if size < 0 {
+		size = 0
+	}
+	b.C = make(chan Message, size)
+
+	return b
+}
+
+// Push puts message into buffer by applying the overflow policy.
+// It only returns ErrSlowConsumer with disconnect policy, and the caller
+// is expected to disconnect the consumer.
+func (b *Buffer) Push(m Message) error {
+	switch b.policy {
+	case POLICY_DROP_NEWEST:
+		select {
+		case b.C <- m:
+		default:
+			b.drop()
+		}
+	case POLICY_DROP_OLDEST:
+		for {
+			select {
+			case b.C <- m:
+				return nil
+			default:
+			}
+
+			select {
+			case <-b.C:
+				b.drop()
+			default:
+				// unbuffered channel without any waiting consumers
+				b.drop()
+				return nil
+			}
+		}
+	case POLICY_DISCONNECT:
+		select {
+		case b.C <- m:
+		default:
+			b.drop()
+			return ErrSlowConsumer
+		}
+	default:
+		b.C <- m
+	}
+
+	return nil
+}
+
+// Dropped returns the number of discarded messages
+func (b *Buffer) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// Len returns the number of messages waiting in buffer
+func (b *Buffer) Len() int {
+	return len(b.C)
+}
+
+func (b *Buffer) drop() {
+	atomic.AddUint64(&b.dropped, 1)
+	droppedMessages.Inc(b.Name)
+}
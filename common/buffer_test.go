@@ -0,0 +1,99 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBufferConfValidation(t *testing.T) {
+	b := &BufferConf{Size: -1}
+	if err := b.Validate(); err != ErrInvalidSize {
+		t.Errorf("Expected %s but got %s", ErrInvalidSize, err)
+	}
+
+	b = &BufferConf{Size: 10, Policy: "drop-everything"}
+	if err := b.Validate(); err != ErrInvalidPolicy {
+		t.Errorf("Expected %s but got %s", ErrInvalidPolicy, err)
+	}
+
+	b = &BufferConf{Size: 10, Policy: POLICY_DROP_OLDEST}
+	if err := b.Validate(); err != nil {
+		t.Errorf("Expected nil but got %s", err)
+	}
+}
+
+func TestBufferDropNewest(t *testing.T) {
+	b := NewBuffer(&BufferConf{Size: 2, Policy: POLICY_DROP_NEWEST})
+	for _, body := range []string{"one", "two", "three"} {
+		if err := b.Push(Message{Body: body}); err != nil {
+			t.Errorf("Expected nil but got %s", err)
+		}
+	}
+
+	if b.Dropped() != 1 {
+		t.Errorf("Expected %d dropped messages but got %d", 1, b.Dropped())
+	}
+
+	if m := <-b.C; m.Body != "one" {
+		t.Errorf("Expected %s but got %s", "one", m.Body)
+	}
+	if m := <-b.C; m.Body != "two" {
+		t.Errorf("Expected %s but got %s", "two", m.Body)
+	}
+}
+
+func TestBufferDropOldest(t *testing.T) {
+	b := NewBuffer(&BufferConf{Size: 2, Policy: POLICY_DROP_OLDEST})
+	for _, body := range []string{"one", "two", "three"} {
+		if err := b.Push(Message{Body: body}); err != nil {
+			t.Errorf("Expected nil but got %s", err)
+		}
+	}
+
+	if b.Dropped() != 1 {
+		t.Errorf("Expected %d dropped messages but got %d", 1, b.Dropped())
+	}
+
+	if m := <-b.C; m.Body != "two" {
+		t.Errorf("Expected %s but got %s", "two", m.Body)
+	}
+	if m := <-b.C; m.Body != "three" {
+		t.Errorf("Expected %s but got %s", "three", m.Body)
+	}
+}
+
+func TestBufferDisconnect(t *testing.T) {
+	b := NewBuffer(&BufferConf{Size: 1, Policy: POLICY_DISCONNECT})
+	if err := b.Push(Message{Body: "one"}); err != nil {
+		t.Errorf("Expected nil but got %s", err)
+	}
+
+	if err := b.Push(Message{Body: "two"}); err != ErrSlowConsumer {
+		t.Errorf("Expected %s but got %s", ErrSlowConsumer, err)
+	}
+}
+
+func TestBufferBlock(t *testing.T) {
+	b := NewBuffer(nil)
+	done := make(chan struct{}, 1)
+	go func() {
+		b.Push(Message{Body: "one"})
+		done <- struct{}{}
+	}()
+
+	select {
+	case <-done:
+		t.Error("Expected push to block until message is consumed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if m := <-b.C; m.Body != "one" {
+		t.Errorf("Expected %s but got %s", "one", m.Body)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected push to complete but got timeout")
+	}
+}
@@ -0,0 +1,22 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// NormalizeChannel removes leading # and lowers channel name
+func NormalizeChannel(channel string) string {
+	return strings.ToLower(strings.TrimPrefix(channel, "#"))
+}
+
+// NewID returns a random hex encoded id of size bytes
+func NewID(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
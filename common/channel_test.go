@@ -0,0 +1,28 @@
+package common
+
+import "testing"
+
+func TestNormalizeChannel(t *testing.T) {
+	tests := map[string]string{
+		"#Muppets":  "muppets",
+		"kitchen":   "kitchen",
+		"##swedish": "#swedish",
+	}
+
+	for channel, expected := range tests {
+		if n := NormalizeChannel(channel); n != expected {
+			t.Errorf("Expected %s but got %s", expected, n)
+		}
+	}
+}
+
+func TestNewID(t *testing.T) {
+	id := NewID(8)
+	if len(id) != 16 {
+		t.Errorf("Expected %d but got %d", 16, len(id))
+	}
+
+	if id == NewID(8) {
+		t.Errorf("Expected unique ids but got %s twice", id)
+	}
+}
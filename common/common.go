@@ -7,14 +7,19 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/canthefason/irc-k/metrics"
 	"github.com/canthefason/r2dq"
 	"gopkg.in/redis.v2"
 )
 
 const (
-	// used for storing all channel names in a set
+	// used for storing channel names requested by subscribers in a set
 	REQ_CHANNELS_KEY = "requested-channels"
 
+	// used for storing follower counts of channels followed by users in
+	// a hash
+	FOLLOWED_CHANNELS_KEY = "followers"
+
 	// redis key prefix
 	PREFIX = "irc-k"
 )
@@ -25,6 +30,13 @@ var (
 	ErrChannelNotSet = errors.New("channel not set")
 	ErrRedisNotInit  = errors.New("redis not initialized")
 	ErrQueueNotInit  = errors.New("queue not initialized")
+
+	publishedMessages = metrics.NewCounter("irck_messages_published_total",
+		"Messages published to channel subscribers", "channel")
+	publishErrors = metrics.NewCounter("irck_publish_errors_total",
+		"Messages which could not be published to channel subscribers", "channel")
+	droppedMessages = metrics.NewCounter("irck_messages_dropped_total",
+		"Messages discarded due to buffer overflow", "buffer")
 )
 
 // RedisConf holds redis connection data
@@ -41,6 +53,8 @@ type IrcConf struct {
 	Server string
 	// Stores botname to be used by feeder
 	BotName string
+	// IRCv3 capabilities requested from server
+	Capability []string
 }
 
 // Initialize initilizes redis and queue connections
@@ -96,6 +110,45 @@ func Close() {
 	waitingQueue.Close()
 }
 
+// PingRedis checks global redis connection
+func PingRedis() error {
+	if redisConn == nil {
+		return ErrRedisNotInit
+	}
+
+	return redisConn.Ping().Err()
+}
+
+// PingQueue checks whether channel queue is reachable
+func PingQueue() error {
+	if waitingQueue == nil {
+		return ErrQueueNotInit
+	}
+
+	_, err := waitingQueue.Len()
+
+	return err
+}
+
+// IsRequested checks whether channel is requested by subscribers or
+// followed by users. Feeder bots stay in the union of them
+func IsRequested(r *redis.Client, channel string) (bool, error) {
+	channel = NormalizeChannel(channel)
+
+	var requested, followed *redis.BoolCmd
+	_, err := r.Pipelined(func(p *redis.Pipeline) error {
+		requested = p.SIsMember(KeyWithPrefix(REQ_CHANNELS_KEY), channel)
+		followed = p.HExists(KeyWithPrefix(FOLLOWED_CHANNELS_KEY), channel)
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return requested.Val() || followed.Val(), nil
+}
+
 // KeyWithPrefix appends prefix constant to the given key
 func KeyWithPrefix(key string) string {
 	return fmt.Sprintf("%s:%s", PREFIX, key)
@@ -113,6 +166,11 @@ func Send(m Message) error {
 	}
 
 	res := redisConn.Publish(KeyWithPrefix(m.Channel), string(data))
+	if res.Err() != nil {
+		publishErrors.Inc(m.Channel)
+		return res.Err()
+	}
+	publishedMessages.Inc(m.Channel)
 
-	return res.Err()
+	return nil
 }
@@ -0,0 +1,215 @@
+package common
+
+import (
+	"errors"
+	"strings"
+)
+
+// settings of packages which are read from config sections. They are
+// kept here, so that config does not depend on the packages using them
+
+const (
+	ENCODING_UTF8   = "utf-8"
+	ENCODING_LATIN1 = "latin1"
+	ENCODING_CP1252 = "cp1252"
+	ENCODING_CP1251 = "cp1251"
+	ENCODING_KOI8R  = "koi8-r"
+)
+
+var (
+	ErrInvalidEncoding    = errors.New("invalid encoding")
+	ErrInvalidDedupWindow = errors.New("invalid dedup window")
+	ErrInvalidRetention   = errors.New("invalid retention")
+	ErrInvalidPrefix      = errors.New("invalid prefix")
+)
+
+// supported encodings besides UTF-8
+var encodings = map[string]bool{
+	ENCODING_LATIN1: true,
+	ENCODING_CP1252: true,
+	ENCODING_CP1251: true,
+	ENCODING_KOI8R:  true,
+}
+
+// EncodingConf holds character encodings of irc traffic. Received
+// messages are kept as they are when they are valid UTF-8, and they
+// are decoded with Inbound encoding otherwise. Sent messages are
+// encoded with Outbound encoding. Empty encodings are treated as UTF-8
+type EncodingConf struct {
+	Inbound  string
+	Outbound string
+}
+
+// Validate checks whether encodings are supported
+func (e *EncodingConf) Validate() error {
+	for _, name := range []string{e.Inbound, e.Outbound} {
+		name = strings.ToLower(name)
+		if name != "" && name != ENCODING_UTF8 && !encodings[name] {
+			return ErrInvalidEncoding
+		}
+	}
+
+	return nil
+}
+
+// Encodings holds encoding settings keyed by channel. Network settings
+// are stored with empty key, and channel settings override them
+type Encodings map[string]*EncodingConf
+
+// Validate checks all encoding settings
+func (e Encodings) Validate() error {
+	for _, c := range e {
+		if c == nil {
+			continue
+		}
+
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Of returns encoding settings of channel
+func (e Encodings) Of(channel string) EncodingConf {
+	var conf EncodingConf
+	if c := e[""]; c != nil {
+		conf = *c
+	}
+
+	// channel names are case insensitive, and they can be defined
+	// with or without leading #
+	channel = NormalizeChannel(channel)
+	for name, c := range e {
+		if name == "" || c == nil || NormalizeChannel(name) != channel {
+			continue
+		}
+
+		if c.Inbound != "" {
+			conf.Inbound = c.Inbound
+		}
+		if c.Outbound != "" {
+			conf.Outbound = c.Outbound
+		}
+	}
+
+	return conf
+}
+
+// DedupConf holds dedup settings
+type DedupConf struct {
+	// messages without ids are considered as duplicates when the same
+	// sender sends the same body to the same channel within Window
+	// seconds. zero window disables dedup
+	Window int
+}
+
+// Validate checks dedup window
+func (c *DedupConf) Validate() error {
+	if c.Window < 0 {
+		return ErrInvalidDedupWindow
+	}
+
+	return nil
+}
+
+// SearchConf holds search settings
+type SearchConf struct {
+	// messages older than Retention days are removed from index. zero
+	// retention keeps all messages
+	Retention int
+}
+
+// Validate checks retention
+func (c *SearchConf) Validate() error {
+	if c.Retention < 0 {
+		return ErrInvalidRetention
+	}
+
+	return nil
+}
+
+// CommandConf holds command prefix and admins
+type CommandConf struct {
+	// Prefix of commands. Commands can only be invoked by addressing
+	// the bot when it is empty
+	Prefix string
+	// Admin nicknames. Nicknames are not verified by servers, so they
+	// should only be used on networks enforcing nickname registration
+	Admin []string
+	// Admin services accounts. Accounts are read from account-tag
+	Account []string
+}
+
+// Validate checks prefix
+func (c *CommandConf) Validate() error {
+	if strings.ContainsAny(c.Prefix, " \t") {
+		return ErrInvalidPrefix
+	}
+
+	return nil
+}
+
+// FilterConf holds settings of a built-in filter. See filter package
+// for actions and directions
+type FilterConf struct {
+	// Action applied to matching messages. Drop is used when it is empty
+	Action string
+	// Direction of filtered messages. Both are filtered when it is empty
+	Direction string
+	// flood: repeated messages of a nickname allowed in Window seconds
+	Repeats int
+	Window  int
+	// links: maximum number of links in a message, and banned domains
+	MaxLinks int
+	Domain   []string
+	// words: banned words or phrases
+	Word []string
+	// throttle: allowed messages per second of a nickname, and burst
+	Rate  float64
+	Burst int
+}
+
+// ChanLogConf holds channel log settings. See chanlog package for
+// formats and layouts
+type ChanLogConf struct {
+	// log files are written under this directory
+	Dir string
+	// Format is text, json or both. Text is used when it is not set
+	Format []string
+	// Layout is the path of log files relative to Dir, without file
+	// extension. {channel}, {date}, {year}, {month} and {day} are
+	// replaced, and it must contain the channel and the day
+	Layout string
+	// Compress enables gzip compression of previous days
+	Compress bool
+	// Retention is the number of days log files are kept. Zero keeps
+	// them forever
+	Retention int
+}
+
+// HealthConf holds health server settings of processes which do not
+// serve the api, such as feeders
+type HealthConf struct {
+	// listen address of health and metrics endpoints. Endpoints are not
+	// served when it is empty
+	Addr string
+}
+
+// WebhookConf holds webhook settings
+type WebhookConf struct {
+	// users allowed to register webhooks in addition to admins
+	User []string
+}
+
+// Allowed checks whether user can register webhooks
+func (c *WebhookConf) Allowed(user string) bool {
+	for _, u := range c.User {
+		if u == user {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,27 @@
+package common
+
+import "testing"
+
+func TestEncodings(t *testing.T) {
+	e := Encodings{
+		"":         {Inbound: ENCODING_CP1252},
+		"#Russian": {Inbound: ENCODING_CP1251, Outbound: ENCODING_CP1251},
+	}
+
+	if err := e.Validate(); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if c := e.Of("russian"); c.Inbound != ENCODING_CP1251 || c.Outbound != ENCODING_CP1251 {
+		t.Errorf("Expected %s but got %v", ENCODING_CP1251, c)
+	}
+
+	if c := e.Of("muppets"); c.Inbound != ENCODING_CP1252 || c.Outbound != "" {
+		t.Errorf("Expected %s but got %v", ENCODING_CP1252, c)
+	}
+
+	e["kitchen"] = &EncodingConf{Inbound: "ebcdic"}
+	if err := e.Validate(); err != ErrInvalidEncoding {
+		t.Errorf("Expected %s but got %v", ErrInvalidEncoding, err)
+	}
+}
@@ -0,0 +1,41 @@
+package common
+
+import "time"
+
+const (
+	EVENT_MESSAGE = "message"
+	EVENT_JOIN    = "join"
+	EVENT_PART    = "part"
+	EVENT_QUIT    = "quit"
+	EVENT_KICK    = "kick"
+	EVENT_NICK    = "nick"
+	EVENT_TOPIC   = "topic"
+	EVENT_MODE    = "mode"
+)
+
+// Event is a change seen in a joined channel
+type Event struct {
+	Type     string `json:"type"`
+	Channel  string `json:"channel"`
+	Nickname string `json:"nickname"`
+	// Target is the kicked nickname, or the new nickname of nick changes
+	Target string `json:"target,omitempty"`
+	// Text is the message body, part, quit or kick reason, the new topic
+	// or the mode change
+	Text string    `json:"text,omitempty"`
+	Time time.Time `json:"time"`
+	// ServerTime is set when Time is the server time
+	ServerTime bool `json:"-"`
+}
+
+// MessageEvent returns the event of a received message
+func MessageEvent(m Message) Event {
+	return Event{
+		Type:       EVENT_MESSAGE,
+		Channel:    m.Channel,
+		Nickname:   m.Nickname,
+		Text:       m.Body,
+		Time:       m.Time,
+		ServerTime: m.ServerTime,
+	}
+}
@@ -1,16 +1,88 @@
 package common
 
-import "errors"
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// message is accepted but not yet handed to irc connection
+	STATUS_QUEUED = "queued"
+
+	// message is written to irc connection
+	STATUS_SENT = "sent"
+
+	// message is seen in channel
+	STATUS_ECHOED = "echoed"
+
+	// message could not be delivered
+	STATUS_FAILED = "failed"
+)
+
+const (
+	// only first MaxLines lines of long messages are sent
+	LONG_TRUNCATE = "truncate"
+
+	// long messages are rejected
+	LONG_REJECT = "reject"
+
+	// long messages are stored in paste store, and a link is sent instead
+	LONG_PASTE = "paste"
+)
 
 var (
-	ErrBodyNotSet     = errors.New("body not set")
-	ErrNicknameNotSet = errors.New("nickname not set")
+	ErrBodyNotSet      = errors.New("body not set")
+	ErrNicknameNotSet  = errors.New("nickname not set")
+	ErrMessageTooLong  = errors.New("message too long")
+	ErrInvalidMaxLines = errors.New("invalid max lines")
+	ErrInvalidLong     = errors.New("invalid long message policy")
 )
 
+// MessageConf holds limits of outbound messages. Messages longer than
+// MaxLines irc lines are handled with Long policy. Zero MaxLines means
+// no limit
+type MessageConf struct {
+	MaxLines int
+	Long     string
+}
+
+// Validate checks max lines and long message policy. Empty policy is
+// treated as truncate
+func (m *MessageConf) Validate() error {
+	if m.MaxLines < 0 {
+		return ErrInvalidMaxLines
+	}
+
+	switch m.Long {
+	case "", LONG_TRUNCATE, LONG_REJECT, LONG_PASTE:
+		return nil
+	default:
+		return ErrInvalidLong
+	}
+}
+
 type Message struct {
+	// ID is only set for messages sent via api, and it is used for
+	// tracking their delivery status
+	ID       string `json:"id,omitempty"`
 	Nickname string `json:"nickname"`
 	Body     string `json:"body"`
 	Channel  string `json:"-"`
+	// Time is the server time of received messages when supported by
+	// server, and the reception time otherwise
+	Time time.Time `json:"time,omitempty"`
+	// ServerTime is set when Time is the server time
+	ServerTime bool `json:"-"`
+	// MsgID is the unique id of received message given by server
+	MsgID string `json:"msgid,omitempty"`
+	// Account is the services account of sender
+	Account string `json:"account,omitempty"`
+	// History is set for messages fetched via chathistory
+	History bool `json:"history,omitempty"`
+	// Flags are names of the filters which tagged message
+	Flags []string `json:"flags,omitempty"`
 }
 
 func (m *Message) Validate() error {
@@ -24,3 +96,19 @@ func (m *Message) Validate() error {
 
 	return nil
 }
+
+// Mentions checks whether body contains nickname as a separate word
+func Mentions(body, nickname string) bool {
+	isNickRune := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_[]\\`^{}|", r)
+	}
+
+	words := strings.FieldsFunc(body, func(r rune) bool { return !isNickRune(r) })
+	for _, word := range words {
+		if strings.EqualFold(word, nickname) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maximum irc line length including trailing CRLF
+	MAX_LINE_LENGTH = 512
+
+	// used when host of sender is not yet known
+	MAX_HOST_LENGTH = 63
+)
+
+// MaxBodyLength returns the maximum body length of PRIVMSG lines sent to
+// target. Servers relay messages with the full nick!ident@host prefix of
+// sender, and the relayed line must fit into MAX_LINE_LENGTH
+func MaxBodyLength(nick, ident, host, target string) int {
+	hostLen := len(host)
+	if hostLen == 0 {
+		hostLen = MAX_HOST_LENGTH
+	}
+
+	// :nick!ident@host PRIVMSG target :body\r\n
+	prefix := len(":"+nick+"!"+ident+"@") + hostLen + len(" PRIVMSG "+target+" :")
+
+	return MAX_LINE_LENGTH - len("\r\n") - prefix
+}
+
+// SplitBody splits body into irc lines with at most max bytes. Each line
+// of a multi-line body is sent separately and blank lines are skipped.
+// Long lines are split at the last space when possible, and UTF-8
+// sequences are never broken.
+func SplitBody(body string, max int) []string {
+	if max < utf8.UTFMax {
+		max = utf8.UTFMax
+	}
+
+	isNewLine := func(r rune) bool { return r == '\n' || r == '\r' }
+
+	lines := make([]string, 0)
+	for _, line := range strings.FieldsFunc(body, isNewLine) {
+		line = strings.TrimRight(line, " \t")
+		for len(line) > max {
+			i := splitIndex(line, max)
+			// lines starting with spaces can have blank chunks
+			if chunk := strings.TrimRight(line[:i], " "); chunk != "" {
+				lines = append(lines, chunk)
+			}
+			line = strings.TrimLeft(line[i:], " ")
+		}
+
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// Truncate cuts s to at most max bytes without breaking UTF-8 sequences.
+// Negative max is treated as zero
+func Truncate(s string, max int) string {
+	if max < 0 {
+		max = 0
+	}
+
+	if len(s) <= max {
+		return s
+	}
+
+	i := max
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+
+	return s[:i]
+}
+
+// splitIndex returns the index where a line longer than max is split
+func splitIndex(line string, max int) int {
+	if i := strings.LastIndex(line[:max+1], " "); i > 0 {
+		return i
+	}
+
+	if i := len(Truncate(line, max)); i > 0 {
+		return i
+	}
+
+	// line does not start with a valid UTF-8 sequence
+	return max
+}
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestMaxBodyLength(t *testing.T) {
+	// :kermit!kermit@muppets.org PRIVMSG #muppets :
+	if l := MaxBodyLength("kermit", "kermit", "muppets.org", "#muppets"); l != 465 {
+		t.Errorf("Expected %d but got %d", 465, l)
+	}
+
+	if l := MaxBodyLength("kermit", "kermit", "", "#muppets"); l != 413 {
+		t.Errorf("Expected %d but got %d", 413, l)
+	}
+}
+
+func TestSplitBodyNewLines(t *testing.T) {
+	lines := SplitBody("hi ho\r\n\r\nwocka wocka  \nbork", 100)
+	expected := []string{"hi ho", "wocka wocka", "bork"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %v but got %v", expected, lines)
+	}
+}
+
+func TestSplitBodyWords(t *testing.T) {
+	lines := SplitBody("hi ho kermit the frog", 10)
+	expected := []string{"hi ho", "kermit the", "frog"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %v but got %v", expected, lines)
+	}
+}
+
+func TestSplitBodyLeadingSpaces(t *testing.T) {
+	lines := SplitBody("   kermitthefrog", 8)
+	expected := []string{"kermitth", "efrog"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %v but got %v", expected, lines)
+	}
+}
+
+func TestSplitBodyUTF8(t *testing.T) {
+	body := strings.Repeat("ğüş", 20)
+	lines := SplitBody(body, 9)
+	if strings.Join(lines, "") != body {
+		t.Errorf("Expected %s but got %s", body, strings.Join(lines, ""))
+	}
+
+	for _, line := range lines {
+		if len(line) > 9 {
+			t.Errorf("Expected at most %d bytes but got %d", 9, len(line))
+		}
+
+		if !utf8.ValidString(line) {
+			t.Errorf("Expected valid UTF-8 but got %q", line)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if s := Truncate("kermit", 10); s != "kermit" {
+		t.Errorf("Expected %s but got %s", "kermit", s)
+	}
+
+	if s := Truncate("çöp", 2); s != "ç" {
+		t.Errorf("Expected %s but got %s", "ç", s)
+	}
+
+	if s := Truncate("kermit", -5); s != "" {
+		t.Errorf("Expected empty string but got %s", s)
+	}
+}
@@ -4,10 +4,94 @@ var confStr = `
 [irc]
 Server  = irc.freenode.net:7000
 BotName = koding-bot
+Capability = server-time
+Capability = message-tags
+Capability = account-tag
+Capability = echo-message
+Capability = batch
+Capability = draft/chathistory
 
 [redis]
 Server = localhost
 Port   = 6379
 DB     = 3
 Prefix = irc-k
+
+[subscriber]
+Size   = 64
+Policy = block
+
+[connection]
+Size   = 256
+Policy = drop-newest
+
+[dedup]
+Window = 10
+
+[search]
+Retention = 30
+
+[filter "flood"]
+Action  = drop
+Repeats = 3
+Window  = 30
+
+[filter "links"]
+Action   = quarantine
+MaxLinks = 3
+
+[message]
+MaxLines = 5
+Long     = truncate
+
+[encoding]
+Inbound  = cp1252
+Outbound = utf-8
+
+[paste]
+Dir = /tmp/irc-k/pastes
+URL = http://localhost:3000/pastes
+Retention = 30
+
+[command]
+Prefix = !
+
+[chanlog]
+Format    = text
+Compress  = true
+Retention = 30
+
+[log]
+Format = text
+Level  = info
+RawIRC = false
+
+[log "irc"]
+Level = warning
+
+[auth]
+Mode = none
+
+[health]
+Addr = :3001
+
+[ratelimit]
+Mode     = reject
+MaxDelay = 30
+
+[ratelimit "user"]
+Rate  = 1
+Burst = 5
+
+[ratelimit "nickname"]
+Rate  = 1
+Burst = 5
+
+[ratelimit "channel"]
+Rate  = 2
+Burst = 10
+
+[ratelimit "network"]
+Rate  = 5
+Burst = 20
 `
@@ -1,6 +1,7 @@
 // Package config provides configuration data for main package
 // For overriding redis host and port settings, REDIS_HOST and
-// REDIS_POST environment variables are used
+// REDIS_POST environment variables are used. Authentication secret
+// is overridden with AUTH_SECRET environment variable
 package config
 
 import (
@@ -8,12 +9,45 @@ import (
 	"os"
 
 	"code.google.com/p/gcfg"
+	"github.com/canthefason/irc-k/auth"
 	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/logger"
+	"github.com/canthefason/irc-k/paste"
+	"github.com/canthefason/irc-k/ratelimit"
 )
 
 type Config struct {
-	IRC   common.IrcConf
-	Redis common.RedisConf
+	IRC        common.IrcConf
+	Redis      common.RedisConf
+	Subscriber common.BufferConf
+	Connection common.BufferConf
+	Message    common.MessageConf
+	Dedup      common.DedupConf
+	Search     common.SearchConf
+	Paste      paste.Conf
+	// message encodings keyed by channel. network encodings are stored
+	// with empty key
+	Encoding common.Encodings
+	// root log section is stored with empty key, and rest of the keys
+	// are component names
+	Log  map[string]*logger.Conf
+	Auth auth.Conf
+	// allowed nicknames of application users keyed by user
+	Identity map[string]*auth.IdentityConf
+	// outbound message limits keyed by scope. root section is stored
+	// with empty key
+	RateLimit map[string]*ratelimit.Conf
+	// built-in spam filters keyed by filter name
+	Filter map[string]*common.FilterConf
+	// health and metrics endpoints of feeder processes
+	Health common.HealthConf
+	// users allowed to register webhooks
+	Webhook common.WebhookConf
+	// prefix and admins of bot commands
+	Command common.CommandConf
+	// channel logs of feeders. channels are not logged when its
+	// directory is not set
+	ChanLog common.ChanLogConf
 }
 
 // Exposed root config
@@ -26,10 +60,39 @@ func init() {
 		return
 	}
 
+	if err := logger.Configure(Conf.Log); err != nil {
+		log.Fatalf("Could not initialize log settings: %s", err)
+	}
+
+	if err := Conf.Subscriber.Validate(); err != nil {
+		log.Fatalf("Could not initialize subscriber buffer: %s", err)
+	}
+	if err := Conf.Connection.Validate(); err != nil {
+		log.Fatalf("Could not initialize connection buffer: %s", err)
+	}
+	if err := Conf.Message.Validate(); err != nil {
+		log.Fatalf("Could not initialize message settings: %s", err)
+	}
+	if err := Conf.Dedup.Validate(); err != nil {
+		log.Fatalf("Could not initialize dedup settings: %s", err)
+	}
+	if err := Conf.Search.Validate(); err != nil {
+		log.Fatalf("Could not initialize search settings: %s", err)
+	}
+	if err := Conf.Encoding.Validate(); err != nil {
+		log.Fatalf("Could not initialize encodings: %s", err)
+	}
+	if err := Conf.Command.Validate(); err != nil {
+		log.Fatalf("Could not initialize command settings: %s", err)
+	}
+
 	if env := os.Getenv("REDIS_HOST"); env != "" {
 		Conf.Redis.Server = env
 	}
 	if env := os.Getenv("REDIS_PORT"); env != "" {
 		Conf.Redis.Port = os.Getenv("REDIS_PORT")
 	}
+	if env := os.Getenv("AUTH_SECRET"); env != "" {
+		Conf.Auth.Secret = env
+	}
 }
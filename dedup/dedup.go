@@ -0,0 +1,133 @@
+// Package dedup detects duplicate messages before they are published.
+// Several bots can be in the same channel, and each of them receives the
+// same lines. Messages are identified by their msgid tags when servers
+// support them, and by a hash of network, channel, sender, body and
+// server time otherwise. Messages without server time are hashed without
+// time, since bots receive them at different times. Channel events are
+// identified by a hash of their fields and server time in the same way.
+// Seen messages and events are stored in redis with a ttl.
+package dedup
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/metrics"
+	"gopkg.in/redis.v2"
+)
+
+const (
+	// message ids are unique, so they are kept longer than hashes
+	MSGID_WINDOW = 10 * time.Minute
+)
+
+var (
+	duplicateMessages = metrics.NewCounter("irck_duplicate_messages_total",
+		"Duplicate messages which are not published", "channel")
+)
+
+// Deduplicator stores seen messages of a network
+type Deduplicator struct {
+	Network string
+	Window  time.Duration
+
+	redisConn *redis.Client
+}
+
+// New creates a deduplicator for messages of the given network
+func New(r *redis.Client, network string, c *common.DedupConf) *Deduplicator {
+	return &Deduplicator{
+		Network:   network,
+		Window:    time.Duration(c.Window) * time.Second,
+		redisConn: r,
+	}
+}
+
+// Duplicate marks message as seen, and returns true when it is already
+// seen within its window
+func (d *Deduplicator) Duplicate(m common.Message) (bool, error) {
+	if d.Window == 0 {
+		return false, nil
+	}
+
+	key, ttl := d.key(m)
+	dup, err := d.seen(key, ttl)
+	if dup {
+		duplicateMessages.Inc(m.Channel)
+	}
+
+	return dup, err
+}
+
+// DuplicateEvent marks event as seen, and returns true when the same
+// event is already seen within window
+func (d *Deduplicator) DuplicateEvent(e common.Event) (bool, error) {
+	if d.Window == 0 {
+		return false, nil
+	}
+
+	return d.seen(d.eventKey(e), d.Window)
+}
+
+// seen marks key as seen for ttl, and returns true when it is already
+// marked
+func (d *Deduplicator) seen(key string, ttl time.Duration) (bool, error) {
+	// SET NX EX marks key as seen atomically, and it has a nil reply
+	// when key already exists
+	cmd := redis.NewStatusCmd("SET", key, "1", "EX", strconv.Itoa(int(ttl/time.Second)), "NX")
+	d.redisConn.Process(cmd)
+	if cmd.Err() == redis.Nil {
+		return true, nil
+	}
+
+	if cmd.Err() != nil {
+		return false, cmd.Err()
+	}
+
+	return false, nil
+}
+
+// key returns the redis key and ttl of message
+func (d *Deduplicator) key(m common.Message) (string, time.Duration) {
+	if m.MsgID != "" {
+		return common.KeyWithPrefix(fmt.Sprintf("seen:%s:%s", d.Network, m.MsgID)), MSGID_WINDOW
+	}
+
+	// irc names are case insensitive. Same body can be sent again within
+	// window, and server time separates repeated messages
+	fields := []string{
+		d.Network,
+		common.NormalizeChannel(m.Channel),
+		strings.ToLower(m.Nickname),
+		m.Body,
+	}
+	if m.ServerTime {
+		fields = append(fields, m.Time.UTC().Format(time.RFC3339Nano))
+	}
+	sum := sha1.Sum([]byte(strings.Join(fields, "\x00")))
+
+	return common.KeyWithPrefix("seen:" + hex.EncodeToString(sum[:])), d.Window
+}
+
+// eventKey returns the redis key of event
+func (d *Deduplicator) eventKey(e common.Event) string {
+	fields := []string{
+		d.Network,
+		e.Type,
+		common.NormalizeChannel(e.Channel),
+		strings.ToLower(e.Nickname),
+		strings.ToLower(e.Target),
+		e.Text,
+	}
+	if e.ServerTime {
+		fields = append(fields, e.Time.UTC().Format(time.RFC3339Nano))
+	}
+	sum := sha1.Sum([]byte(strings.Join(fields, "\x00")))
+
+	return common.KeyWithPrefix("seen:event:" + hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,112 @@
+package dedup
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+func TestKey(t *testing.T) {
+	d := New(nil, "freenode", &common.DedupConf{Window: 5})
+
+	k1, ttl := d.key(common.Message{Nickname: "Kermit", Channel: "#muppets", Body: "hi ho"})
+	k2, _ := d.key(common.Message{Nickname: "kermit", Channel: "muppets", Body: "hi ho"})
+	if k1 != k2 {
+		t.Errorf("Expected %s but got %s", k1, k2)
+	}
+
+	if ttl != 5*time.Second {
+		t.Errorf("Expected %s but got %s", 5*time.Second, ttl)
+	}
+
+	k3, _ := d.key(common.Message{Nickname: "kermit", Channel: "muppets", Body: "hi ho!"})
+	if k1 == k3 {
+		t.Errorf("Expected different keys but got %s", k3)
+	}
+
+	// repeated messages are separated by server time
+	sent := time.Date(2015, 6, 2, 12, 0, 0, 0, time.UTC)
+	k5, _ := d.key(common.Message{Nickname: "kermit", Channel: "muppets", Body: "hi ho", Time: sent, ServerTime: true})
+	k6, _ := d.key(common.Message{Nickname: "kermit", Channel: "muppets", Body: "hi ho", Time: sent.Add(time.Second), ServerTime: true})
+	if k5 == k6 || k5 == k1 {
+		t.Errorf("Expected different keys but got %s", k6)
+	}
+
+	// reception times differ between bots, and they are not hashed
+	k7, _ := d.key(common.Message{Nickname: "kermit", Channel: "muppets", Body: "hi ho", Time: sent})
+	if k7 != k1 {
+		t.Errorf("Expected %s but got %s", k1, k7)
+	}
+
+	k4, ttl := d.key(common.Message{MsgID: "abc", Body: "hi ho"})
+	if k4 != "irc-k:seen:freenode:abc" || ttl != MSGID_WINDOW {
+		t.Errorf("Expected %s but got %s", "irc-k:seen:freenode:abc", k4)
+	}
+}
+
+func TestEventKey(t *testing.T) {
+	d := New(nil, "freenode", &common.DedupConf{Window: 5})
+
+	k1 := d.eventKey(common.Event{Type: common.EVENT_JOIN, Channel: "#Muppets", Nickname: "Kermit"})
+	k2 := d.eventKey(common.Event{Type: common.EVENT_JOIN, Channel: "muppets", Nickname: "kermit"})
+	if k1 != k2 {
+		t.Errorf("Expected %s but got %s", k1, k2)
+	}
+
+	k3 := d.eventKey(common.Event{Type: common.EVENT_PART, Channel: "muppets", Nickname: "kermit"})
+	if k1 == k3 {
+		t.Errorf("Expected different keys but got %s", k3)
+	}
+
+	// repeated joins are separated by server time
+	joined := time.Date(2015, 6, 2, 12, 0, 0, 0, time.UTC)
+	k4 := d.eventKey(common.Event{Type: common.EVENT_JOIN, Channel: "muppets", Nickname: "kermit", Time: joined, ServerTime: true})
+	k5 := d.eventKey(common.Event{Type: common.EVENT_JOIN, Channel: "muppets", Nickname: "kermit", Time: joined.Add(time.Minute), ServerTime: true})
+	if k4 == k5 || k4 == k1 {
+		t.Errorf("Expected different keys but got %s", k5)
+	}
+
+	k6 := d.eventKey(common.Event{Type: common.EVENT_JOIN, Channel: "muppets", Nickname: "kermit", Time: joined})
+	if k6 != k1 {
+		t.Errorf("Expected %s but got %s", k1, k6)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	conf := &common.RedisConf{Server: "localhost", Port: "6379", DB: 3}
+	if env := os.Getenv("REDIS_HOST"); env != "" {
+		conf.Server = env
+	}
+
+	if env := os.Getenv("REDIS_PORT"); env != "" {
+		conf.Port = env
+	}
+
+	redisConn := common.NewRedis(conf)
+	defer redisConn.Close()
+
+	d := New(redisConn, "freenode", &common.DedupConf{Window: 5})
+	m := common.Message{Nickname: "kermit", Channel: "muppets", Body: "hi ho", MsgID: "dedup-test"}
+	key, _ := d.key(m)
+	defer redisConn.Del(key)
+
+	dup, err := d.Duplicate(m)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if dup {
+		t.Error("Expected first message not to be a duplicate")
+	}
+
+	dup, err = d.Duplicate(m)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if !dup {
+		t.Error("Expected second message to be a duplicate")
+	}
+}
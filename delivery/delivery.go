@@ -0,0 +1,293 @@
+// Package delivery tracks status of messages sent via api. Each message
+// gets an id, and its status moves from queued to sent when it is written
+// to irc connection, and to echoed when it is seen in channel. Messages
+// rejected by irc servers are marked as failed with the server reply.
+//
+// Statuses are stored in redis, and when a callback url is given, each
+// status change is posted to it.
+package delivery
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/logger"
+	"github.com/canthefason/irc-k/webhook"
+	"gopkg.in/redis.v2"
+)
+
+const (
+	// statuses are removed after this duration
+	STATUS_TTL = 24 * time.Hour
+
+	// sent messages are matched with their echoes within this duration
+	ECHO_TTL = time.Minute
+
+	CALLBACK_TIMEOUT = 5 * time.Second
+)
+
+var (
+	ErrNotFound        = errors.New("message not found")
+	ErrInvalidCallback = errors.New("invalid callback url")
+
+	// order of statuses. failed and echoed statuses are final
+	ranks = map[string]int{
+		common.STATUS_QUEUED: 0,
+		common.STATUS_SENT:   1,
+		common.STATUS_ECHOED: 2,
+		common.STATUS_FAILED: 2,
+	}
+)
+
+// Status holds delivery status of a message
+type Status struct {
+	ID       string `json:"id"`
+	State    string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+	User     string `json:"user,omitempty"`
+	Nickname string `json:"nickname"`
+	Channel  string `json:"channel"`
+	// used for matching echoes of sent message
+	BodyHash  string    `json:"bodyHash"`
+	Callback  string    `json:"callback,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// advance changes state of status. Statuses never move backwards, since
+// irc replies and echoes can arrive in any order
+func (s *Status) advance(state, reason string, now time.Time) bool {
+	current, ok := ranks[s.State]
+	if ok && (current == ranks[common.STATUS_ECHOED] || ranks[state] <= current) {
+		return false
+	}
+
+	s.State = state
+	s.Reason = reason
+	s.UpdatedAt = now
+
+	return true
+}
+
+// ValidateCallback checks whether callback is an http url of a public
+// address
+func ValidateCallback(callback string) error {
+	switch err := webhook.ValidateURL(callback); err {
+	case nil:
+		return nil
+	case webhook.ErrInvalidURL:
+		return ErrInvalidCallback
+	default:
+		return err
+	}
+}
+
+// NewID creates a random message id
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// Tracker stores message statuses and notifies their callbacks
+type Tracker struct {
+	Log    logger.Logger
+	Client *http.Client
+
+	redisConn *redis.Client
+	// serializes status updates
+	mu sync.Mutex
+}
+
+// NewTracker creates a tracker with the given redis connection
+func NewTracker(r *redis.Client) *Tracker {
+	return &Tracker{
+		Log:       logger.New("delivery"),
+		Client:    webhook.NewClient(CALLBACK_TIMEOUT),
+		redisConn: r,
+	}
+}
+
+// Create sets id of message and stores its status as queued
+func (t *Tracker) Create(m *common.Message, user, callback string) (*Status, error) {
+	now := time.Now().UTC()
+	m.ID = NewID()
+	s := &Status{
+		ID:        m.ID,
+		State:     common.STATUS_QUEUED,
+		User:      user,
+		Nickname:  m.Nickname,
+		Channel:   m.Channel,
+		BodyHash:  hash(firstLine(m.Body)),
+		Callback:  callback,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := t.save(s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Get returns status of the message with given id
+func (t *Tracker) Get(id string) (*Status, error) {
+	res := t.redisConn.Get(statusKey(id))
+	if res.Err() == redis.Nil {
+		return nil, ErrNotFound
+	}
+
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	s := new(Status)
+	if err := json.Unmarshal([]byte(res.Val()), s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Update changes status of message. Sent messages are indexed by their
+// content for matching their echoes
+func (t *Tracker) Update(id, state, reason string) error {
+	return t.update(id, state, reason, "")
+}
+
+// Sent marks message as sent with the first irc line written to the
+// connection. Lines differ from bodies of long and pasted messages, so
+// echoes are matched with the line
+func (t *Tracker) Sent(id, line string) error {
+	return t.update(id, common.STATUS_SENT, "", line)
+}
+
+func (t *Tracker) update(id, state, reason, line string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, err := t.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if !s.advance(state, reason, time.Now().UTC()) {
+		return nil
+	}
+
+	if line != "" {
+		s.BodyHash = hash(line)
+	}
+
+	if err := t.save(s); err != nil {
+		return err
+	}
+
+	if state == common.STATUS_SENT {
+		// echo is matched by content, since sent messages carry no id
+		res := t.redisConn.SetEx(echoKey(s.Channel, s.Nickname, s.BodyHash), ECHO_TTL, id)
+		if res.Err() != nil {
+			t.Log.Warn("Could not index sent message: %s", res.Err())
+		}
+	}
+
+	go t.notify(s)
+
+	return nil
+}
+
+// Echoed marks the sent message matching given channel message as echoed
+func (t *Tracker) Echoed(m common.Message) error {
+	key := echoKey(m.Channel, m.Nickname, hash(m.Body))
+	res := t.redisConn.Get(key)
+	if res.Err() == redis.Nil {
+		return nil
+	}
+
+	if res.Err() != nil {
+		return res.Err()
+	}
+
+	t.redisConn.Del(key)
+
+	return t.Update(res.Val(), common.STATUS_ECHOED, "")
+}
+
+// notify posts status to callback url of message
+func (t *Tracker) notify(s *Status) {
+	if s.Callback == "" {
+		return
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Log.Error("Could not marshal status: %s", err)
+		return
+	}
+
+	log := t.Log.New("id", s.ID)
+	resp, err := t.Client.Post(s.Callback, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Warn("Could not notify callback: %s", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Warn("Callback responded with %d", resp.StatusCode)
+	}
+}
+
+func (t *Tracker) save(s *Status) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	return t.redisConn.SetEx(statusKey(s.ID), STATUS_TTL, string(data)).Err()
+}
+
+func statusKey(id string) string {
+	return common.KeyWithPrefix(fmt.Sprintf("message:%s", id))
+}
+
+// echoKey forms key of sent message index. Channel names are stored
+// with or without leading #, and irc names are case insensitive
+func echoKey(channel, nickname, bodyHash string) string {
+	channel = strings.ToLower(strings.TrimPrefix(channel, "#"))
+	nickname = strings.ToLower(nickname)
+
+	return common.KeyWithPrefix(fmt.Sprintf("message-echo:%s:%s:%s", channel, nickname, bodyHash))
+}
+
+// firstLine returns the first irc line of body. Only the first line of
+// multi-line messages is matched with its echo. It is replaced with the
+// actual sent line when messages are marked via Sent
+func firstLine(body string) string {
+	lines := common.SplitBody(body, common.MAX_LINE_LENGTH)
+	if len(lines) == 0 {
+		return body
+	}
+
+	return lines[0]
+}
+
+func hash(body string) string {
+	sum := sha1.Sum([]byte(body))
+
+	return hex.EncodeToString(sum[:])
+}
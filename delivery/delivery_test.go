@@ -0,0 +1,144 @@
+package delivery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/webhook"
+)
+
+func TestAdvance(t *testing.T) {
+	now := time.Now()
+	s := &Status{State: common.STATUS_QUEUED}
+
+	if !s.advance(common.STATUS_SENT, "", now) {
+		t.Errorf("Expected %s but got %s", common.STATUS_SENT, s.State)
+	}
+
+	if s.advance(common.STATUS_QUEUED, "", now) {
+		t.Errorf("Expected %s but got %s", common.STATUS_SENT, s.State)
+	}
+
+	if !s.advance(common.STATUS_FAILED, "Cannot send to channel", now) {
+		t.Errorf("Expected %s but got %s", common.STATUS_FAILED, s.State)
+	}
+
+	if s.Reason != "Cannot send to channel" {
+		t.Errorf("Expected %s but got %s", "Cannot send to channel", s.Reason)
+	}
+
+	if s.advance(common.STATUS_ECHOED, "", now) {
+		t.Errorf("Expected %s but got %s", common.STATUS_FAILED, s.State)
+	}
+}
+
+func TestValidateCallback(t *testing.T) {
+	if err := ValidateCallback("https://example.com/status"); err != nil {
+		t.Errorf("Expected nil but got %s", err)
+	}
+
+	if err := ValidateCallback("ftp://example.com"); err != ErrInvalidCallback {
+		t.Errorf("Expected %s but got %v", ErrInvalidCallback, err)
+	}
+
+	for _, u := range []string{"http://localhost/status", "http://127.0.0.1:8080", "http://[::1]/", "http://169.254.169.254/"} {
+		if err := ValidateCallback(u); err != webhook.ErrPrivateAddress {
+			t.Errorf("Expected %s but got %v for %s", webhook.ErrPrivateAddress, err, u)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	received := make(chan Status, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var s Status
+		if err := json.NewDecoder(req.Body).Decode(&s); err != nil {
+			t.Errorf("Expected nil but got %s", err)
+		}
+		received <- s
+	}))
+	defer server.Close()
+
+	// test server listens on loopback, which is not allowed by the
+	// default client
+	tracker := NewTracker(nil)
+	tracker.Client = &http.Client{Timeout: CALLBACK_TIMEOUT}
+	tracker.notify(&Status{ID: "123", State: common.STATUS_SENT, Callback: server.URL})
+
+	select {
+	case s := <-received:
+		if s.State != common.STATUS_SENT {
+			t.Errorf("Expected %s but got %s", common.STATUS_SENT, s.State)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected callback but timed out")
+	}
+}
+
+func TestTracker(t *testing.T) {
+	conf := &common.RedisConf{Server: "localhost", Port: "6379", DB: 3}
+	if env := os.Getenv("REDIS_HOST"); env != "" {
+		conf.Server = env
+	}
+
+	if env := os.Getenv("REDIS_PORT"); env != "" {
+		conf.Port = env
+	}
+
+	redisConn := common.NewRedis(conf)
+	defer redisConn.Close()
+
+	tracker := NewTracker(redisConn)
+	m := &common.Message{Nickname: "kermit", Body: "hi ho", Channel: "muppets"}
+	if _, err := tracker.Create(m, "", ""); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+	defer redisConn.Del(statusKey(m.ID))
+
+	if err := tracker.Update(m.ID, common.STATUS_SENT, ""); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	echo := common.Message{Nickname: "Kermit", Body: "hi ho", Channel: "#muppets"}
+	if err := tracker.Echoed(echo); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	s, err := tracker.Get(m.ID)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if s.State != common.STATUS_ECHOED {
+		t.Errorf("Expected %s but got %s", common.STATUS_ECHOED, s.State)
+	}
+
+	// pasted messages are echoed with their sent lines
+	m = &common.Message{Nickname: "kermit", Body: "hi ho\nkermit the frog here", Channel: "muppets"}
+	if _, err := tracker.Create(m, "", ""); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+	defer redisConn.Del(statusKey(m.ID))
+
+	if err := tracker.Sent(m.ID, "hi ho ... http://localhost/pastes/1"); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	echo.Body = "hi ho ... http://localhost/pastes/1"
+	if err := tracker.Echoed(echo); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if s, _ := tracker.Get(m.ID); s == nil || s.State != common.STATUS_ECHOED {
+		t.Errorf("Expected %s but got %v", common.STATUS_ECHOED, s)
+	}
+
+	if _, err := tracker.Get("unknown"); err != ErrNotFound {
+		t.Errorf("Expected %s but got %v", ErrNotFound, err)
+	}
+}
@@ -13,10 +13,10 @@ import (
 )
 
 func TestMessageHandling(t *testing.T) {
-	go feeder.Run(&config.Conf.IRC, &config.Conf.Redis)
+	go feeder.Run(config.Conf)
 
 	config.Conf.Redis.Prefix = "irc-test"
-	beaker := client.NewSubscriber(&config.Conf.Redis)
+	beaker := client.NewBufferedSubscriber(&config.Conf.Redis, &config.Conf.Subscriber)
 	chef := client.NewConnection()
 	chef.Server = config.Conf.IRC.Server
 
@@ -5,13 +5,30 @@ package feeder
 import (
 	"errors"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/canthefason/irc-k/chanlog"
 	"github.com/canthefason/irc-k/client"
+	"github.com/canthefason/irc-k/command"
 	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/config"
+	"github.com/canthefason/irc-k/dedup"
+	"github.com/canthefason/irc-k/delivery"
+	"github.com/canthefason/irc-k/filter"
+	"github.com/canthefason/irc-k/follow"
+	"github.com/canthefason/irc-k/health"
+	"github.com/canthefason/irc-k/logger"
+	"github.com/canthefason/irc-k/metrics"
+	"github.com/canthefason/irc-k/notification"
+	"github.com/canthefason/irc-k/paste"
+	"github.com/canthefason/irc-k/search"
+	"github.com/canthefason/irc-k/unread"
+	"github.com/canthefason/irc-k/webhook"
 	"github.com/canthefason/r2dq"
 	redis "gopkg.in/redis.v2"
 )
@@ -19,19 +36,70 @@ import (
 var (
 	ErrConnNotInit = errors.New("connection not initialized")
 
+	// Commands routes channel messages to bot commands. Commands must be
+	// registered before Run, and their settings are read from config
+	Commands = command.NewRouter(&common.CommandConf{Prefix: "!"})
+
+	// CustomFilters are run after built-in filters configured in config.
+	// They must be added before Run
+	CustomFilters []filter.Filter
+
+	log       = logger.New("feeder")
 	redisConn *redis.Client
 	conn      *client.Connection
 	quit      chan os.Signal
 	channels  []string
 	queue     *r2dq.Queue
+	tracker   *delivery.Tracker
+	dedupe    *dedup.Deduplicator
+	index     *search.Index
+	hooks     *webhook.Dispatcher
+	outbox    *webhook.Outbox
+	bots      *webhook.Bots
+	notifier  *notification.Notifier
+	readers   *unread.Store
+	filters   *filter.Chain
 	botName   string
 	// used for getting joined channels
 	joinChan   chan string
 	closeQueue chan bool
+	// used for waiting outbox consumers on shutdown
+	closeOutbox chan bool
+	// outbox of messages routed to this bot, since it joined their
+	// channels. outboxBot is the bot name of the shared outbox
+	botOutbox *webhook.Outbox
+	outboxBot string
+	// guards channels, which are also updated when parting
+	channelsMu sync.Mutex
+	// nil when channel logging is disabled
+	channelLog *chanlog.Writer
+	// nil when health endpoints are not served
+	healthServer *http.Server
+
+	joinedChannels = metrics.NewGauge("irck_feeder_channels",
+		"Channels joined by feeder bots", "bot")
+	queueLength = metrics.NewGaugeFunc("irck_queue_length",
+		"Channels waiting for a feeder bot", waitingChannels)
+)
+
+const (
+	// Used for storing bot count in redis
+	BOT_COUNT = "botcount"
+
+	// expired messages are removed from search index in this interval
+	PRUNE_INTERVAL = time.Hour
+
+	// channels without followers are parted in this interval
+	PART_INTERVAL = time.Minute
+
+	// channel log files are compressed and expired in this interval
+	CHANLOG_INTERVAL = time.Hour
 )
 
-// Used for storing bot count in redis
-const BOT_COUNT = "botcount"
+// SetLogger sets the logger of feeder and its bot connection
+func SetLogger(l logger.Logger) {
+	log = l
+}
 
 func initialize(r *common.RedisConf) {
 	redisConn = common.NewRedis(r)
@@ -39,16 +107,28 @@ func initialize(r *common.RedisConf) {
 	channels = make([]string, 0)
 	joinChan = make(chan string)
 	closeQueue = make(chan bool, 1)
+	closeOutbox = make(chan bool, 2)
 	queue = common.MustGetQueue()
+	tracker = delivery.NewTracker(redisConn)
 }
 
-// Run initializes irc connection via bots, and joins queued
-// channels
-func Run(i *common.IrcConf, r *common.RedisConf) {
-	connect(i, r)
+// Run initializes irc connection via bots with the given settings, and
+// joins queued channels
+func Run(c *config.Config) {
+	connect(c)
 	defer Close()
 
+	if c.Health.Addr != "" {
+		serveHealth(c.Health.Addr)
+	}
+
 	go connectToChannel()
+	go sendOutbox(outbox)
+	go sendOutbox(botOutbox)
+	go heartbeat()
+	go pruneIndex()
+	go partChannels()
+	go maintainChannelLog()
 
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
@@ -59,33 +139,187 @@ func Run(i *common.IrcConf, r *common.RedisConf) {
 // for further connections.
 func Close() {
 	defer queue.Close()
+	defer outbox.Close()
 	defer redisConn.Close()
+	health.Unregister(healthCheckName())
 	gracefulShutdown()
 
+	if healthServer != nil {
+		healthServer.Close()
+	}
+
+	// messages routed to this bot are handed over to other feeders
+	botOutbox.Close()
+	if err := bots.Move(botName, outboxBot); err != nil {
+		log.Error("Could not move outbox messages: %s", err)
+	}
+
+	if channelLog != nil {
+		if err := channelLog.Close(); err != nil {
+			log.Warn("Could not close channel logs: %s", err)
+		}
+	}
+
 	close(quit)
 }
 
 func gracefulShutdown() {
 	queue.StopDequeue()
 	<-closeQueue
+	outbox.StopReceive()
+	botOutbox.StopReceive()
+	<-closeOutbox
+	<-closeOutbox
 	// first close redis connection to prevent further channel consuming
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
 	for _, channel := range channels {
 		if err := queue.Queue(channel); err != nil {
-			log.Printf("Critical: channel %s can not be requeued: %s", channel, err)
+			log.New("channel", channel).Error("Channel can not be requeued: %s", err)
+		}
+
+		if err := bots.Part(channel, botName); err != nil {
+			log.New("channel", channel).Warn("Could not release channel: %s", err)
 		}
 	}
+	joinedChannels.Set(0, botName)
+}
+
+// waitingChannels returns the length of channel queue
+func waitingChannels() float64 {
+	if queue == nil {
+		return 0
+	}
+
+	length, err := queue.Len()
+	if err != nil {
+		log.Warn("Could not get queue length: %s", err)
+		return 0
+	}
+
+	return float64(length)
 }
 
-func connect(i *common.IrcConf, r *common.RedisConf) {
+func connect(c *config.Config) {
+	i, r := &c.IRC, &c.Redis
 	initialize(r)
+	dedupe = dedup.New(redisConn, i.Server, &c.Dedup)
+	index = search.NewIndex(redisConn, &c.Search)
+	hooks = webhook.NewDispatcher(webhook.NewStore(redisConn))
+	hooks.Log = log
+	outbox = webhook.NewOutbox(r, i.BotName)
+	outboxBot = i.BotName
+	bots = webhook.NewBots(redisConn)
+	notifier = notification.NewNotifier(notification.NewStore(redisConn))
+	notifier.Log = log
+	notifier.Hooks = hooks
+	notifier.Follows = follow.NewStore(redisConn)
+	readers = unread.NewStore(redisConn)
+
+	var err error
+	filters, err = filter.NewChain(c.Filter)
+	if err != nil {
+		panic(err)
+	}
+	filters.Log = log
+	filters.Quarantine = filter.NewQuarantine(redisConn)
+	filters.Add(CustomFilters...)
+
+	if c.ChanLog.Dir != "" {
+		channelLog, err = chanlog.NewWriter(&c.ChanLog)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	conn = client.NewConnection()
+	conn.Log = log
 	conn.Server = i.Server
+	// a slow redis publish stalls irc event handling when received
+	// messages are not dropped
+	conn.Buffer = c.Connection
+	// message settings are applied to messages posted via incoming
+	// webhooks
+	conn.Message = c.Message
+	if c.Message.Long == common.LONG_PASTE {
+		conn.Paste, err = paste.NewFileStore(&c.Paste)
+		if err != nil {
+			panic(err)
+		}
+	}
+	conn.Encodings = c.Encoding
+	conn.Capabilities = i.Capability
 	conn.Nickname = botName
+	conn.Delivery = func(id, status, reason string) {
+		if err := tracker.Update(id, status, reason); err != nil {
+			log.New("id", id).Warn("Could not update delivery status: %s", err)
+		}
+	}
+	conn.Sent = func(id, line string) {
+		if err := tracker.Sent(id, line); err != nil {
+			log.New("id", id).Warn("Could not update delivery status: %s", err)
+		}
+	}
+	if channelLog != nil {
+		conn.Events = logEvent
+	}
 	botName = prepareBotName(i.BotName)
+	botOutbox = webhook.NewOutbox(r, botName)
+
+	if err := c.Command.Validate(); err != nil {
+		panic(err)
+	}
+	Commands.Conf = &c.Command
+	Commands.Log = log
+	Commands.Names = []string{botName, i.BotName}
+	Commands.IsOperator = conn.IsOperator
+	Commands.Send = reply
 
 	if err := conn.Connect(); err != nil {
 		panic(err)
 	}
+
+	health.Register("redis", pingRedis)
+	health.Register("queue", common.PingQueue)
+	health.Register(healthCheckName(), checkConnection)
+}
+
+// serveHealth serves health and metrics endpoints in background, since
+// feeder processes do not run the api server
+func serveHealth(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/", health.Handler())
+
+	healthServer = &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("Could not serve health endpoints: %s", err)
+		}
+	}()
+}
+
+// pingRedis checks the redis connection of feeder
+func pingRedis() error {
+	return redisConn.Ping().Err()
+}
+
+// healthCheckName returns the readiness check name of the bot
+func healthCheckName() string {
+	return "irc:" + botName
+}
+
+// checkConnection reports irc connection state of the bot
+func checkConnection() error {
+	if conn == nil {
+		return ErrConnNotInit
+	}
+
+	if !conn.Connected() {
+		return client.ErrNotConnected
+	}
+
+	return nil
 }
 
 func connectToChannel() {
@@ -104,20 +338,178 @@ func connectToChannel() {
 		}
 
 		// try to join channel
-		if err := conn.Join(channel); err != nil {
-			log.Printf("An error occurred while joining channel: %s", err)
+		if err := join(channel); err != nil {
+			log.New("bot", botName).New("channel", channel).Error("Could not join channel: %s", err)
 			queue.NAck(channel)
 			return
 		}
 
-		log.Printf("%s connected to channel: %s", botName, channel)
+		log.New("bot", botName).New("channel", channel).Info("Connected to channel")
+		if err := bots.Join(channel, botName); err != nil {
+			log.New("channel", channel).Warn("Could not record joined channel: %s", err)
+		}
 		go func() { joinChan <- channel }()
 
+		channelsMu.Lock()
 		channels = append(channels, channel)
+		joinedChannels.Set(float64(len(channels)), botName)
+		channelsMu.Unlock()
 		queue.Ack(channel)
 	}
 }
 
+// sendOutbox sends messages posted via incoming webhooks. Messages are
+// requeued when bot is not connected
+func sendOutbox(outbox *webhook.Outbox) {
+	for {
+		m, raw, err := outbox.Receive()
+		if err == r2dq.ErrConnClosed {
+			closeOutbox <- true
+			return
+		}
+
+		if err == webhook.ErrInvalidMessage {
+			log.New("bot", botName).Warn("Dropped outbox message: %s", err)
+			continue
+		}
+
+		if err != nil {
+			panic(err)
+		}
+
+		log := log.New("bot", botName).New("channel", m.Channel).New("id", m.ID)
+		err = conn.SendMessage(m)
+		if err == client.ErrNotConnected {
+			log.Warn("Could not send outbox message: %s", err)
+			outbox.NAck(raw)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if err != nil {
+			log.Warn("Could not send outbox message: %s", err)
+		}
+
+		outbox.Ack(raw)
+	}
+}
+
+// heartbeat periodically marks the shared outbox as consumed, so that
+// incoming webhooks can only be registered for running bots
+func heartbeat() {
+	ticker := time.NewTicker(webhook.RUNNING_TTL / 2)
+	defer ticker.Stop()
+
+	for {
+		if err := bots.Heartbeat(outboxBot); err != nil {
+			log.Warn("Could not mark bot as running: %s", err)
+		}
+		<-ticker.C
+	}
+}
+
+// partChannels periodically leaves joined channels which are not
+// requested anymore, since all of their followers are gone
+func partChannels() {
+	ticker := time.NewTicker(PART_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := partUnrequested(); err != nil {
+			log.Warn("Could not part channels: %s", err)
+		}
+	}
+}
+
+// partUnrequested leaves channels which are neither requested by
+// subscribers nor followed by users
+func partUnrequested() error {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+
+	joined := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		requested, err := common.IsRequested(redisConn, channel)
+		if err != nil {
+			return err
+		}
+
+		if requested {
+			joined = append(joined, channel)
+			continue
+		}
+
+		if err := conn.Part(channel); err != nil {
+			return err
+		}
+		log.New("bot", botName).New("channel", channel).Info("Parted channel")
+
+		if err := bots.Part(channel, botName); err != nil {
+			return err
+		}
+	}
+
+	channels = joined
+	joinedChannels.Set(float64(len(channels)), botName)
+
+	return nil
+}
+
+// reply sends command replies to channel
+func reply(channel, body string) {
+	m := &common.Message{Nickname: botName, Channel: channel, Body: body}
+	if err := conn.SendMessage(m); err != nil {
+		log.New("channel", channel).Warn("Could not send reply: %s", err)
+	}
+}
+
+// logEvent writes channel event into channel logs. Bots sharing a
+// channel see the same events, and they are only logged once
+func logEvent(e common.Event) {
+	dup, err := dedupe.DuplicateEvent(e)
+	if err != nil {
+		// events are logged when they cannot be checked
+		log.New("channel", e.Channel).Warn("Could not check duplicate event: %s", err)
+	}
+
+	if dup {
+		return
+	}
+
+	if err := channelLog.Event(e); err != nil {
+		log.New("channel", e.Channel).Warn("Could not log channel event: %s", err)
+	}
+}
+
+// maintainChannelLog periodically compresses and removes old channel
+// log files
+func maintainChannelLog() {
+	if channelLog == nil {
+		return
+	}
+
+	ticker := time.NewTicker(CHANLOG_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := channelLog.Maintain(); err != nil {
+			log.Warn("Could not maintain channel logs: %s", err)
+		}
+	}
+}
+
+// pruneIndex periodically removes expired messages from search index
+func pruneIndex() {
+	ticker := time.NewTicker(PRUNE_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := index.Prune(); err != nil {
+			log.Warn("Could not prune search index: %s", err)
+		}
+	}
+}
+
 func prepareBotName(botname string) string {
 	res := redisConn.Incr(common.KeyWithPrefix(BOT_COUNT))
 	if res.Err() != nil {
@@ -129,8 +521,57 @@ func prepareBotName(botname string) string {
 
 func handleMessages(conn *client.Connection) {
 	for m := range conn.MsgChan {
+		dup, err := dedupe.Duplicate(m)
+		if err != nil {
+			// messages are published when they cannot be checked
+			log.New("channel", m.Channel).Warn("Could not check duplicate: %s", err)
+		}
+
+		if dup {
+			continue
+		}
+
+		if d := filters.Check(&m, filter.DIRECTION_INBOUND); d.Blocked() {
+			// filtered messages sent via api are still delivered to channel
+			if err := tracker.Echoed(m); err != nil {
+				log.New("channel", m.Channel).Warn("Could not update delivery status: %s", err)
+			}
+			continue
+		}
+
 		if err := common.Send(m); err != nil {
-			log.Printf("An error occurred while sending message: %s", err)
+			log.New("channel", m.Channel).Error("Could not send message: %s", err)
+		} else if err := storeLast(m); err != nil {
+			log.New("channel", m.Channel).Warn("Could not store last message: %s", err)
+		}
+
+		// messages are indexed before counting them, so that they are
+		// recounted when their channel is marked read in between
+		if err := index.Add(m); err != nil {
+			log.New("channel", m.Channel).Warn("Could not index message: %s", err)
+		}
+
+		if err := readers.Add(m); err != nil {
+			log.New("channel", m.Channel).Warn("Could not update unread counts: %s", err)
+		}
+
+		if channelLog != nil {
+			logEvent(common.MessageEvent(m))
+		}
+
+		if err := hooks.Dispatch(m); err != nil {
+			log.New("channel", m.Channel).Warn("Could not dispatch webhooks: %s", err)
+		}
+
+		if err := notifier.Notify(m); err != nil {
+			log.New("channel", m.Channel).Warn("Could not notify users: %s", err)
+		}
+
+		Commands.Handle(m)
+
+		// messages sent via api are marked as echoed when seen in channel
+		if err := tracker.Echoed(m); err != nil {
+			log.New("channel", m.Channel).Warn("Could not update delivery status: %s", err)
 		}
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/config"
 )
 
 func tearUp() {
@@ -29,7 +30,9 @@ func tearUp() {
 	}
 
 	common.Initialize(rConf)
-	connect(conf, rConf)
+	c := *config.Conf
+	c.IRC, c.Redis = *conf, *rConf
+	connect(&c)
 }
 
 func tearDown() {
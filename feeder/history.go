@@ -0,0 +1,64 @@
+package feeder
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/canthefason/irc-k/client"
+	"github.com/canthefason/irc-k/common"
+	redis "gopkg.in/redis.v2"
+)
+
+// Used for storing last published message of each channel in a hash
+const LAST_MESSAGES_KEY = "last-messages"
+
+// lastMessage is the reference of chathistory backfill
+type lastMessage struct {
+	Time  time.Time `json:"time"`
+	MsgID string    `json:"msgid,omitempty"`
+}
+
+// storeLast stores time and id of the published message of channel
+func storeLast(m common.Message) error {
+	data, err := json.Marshal(lastMessage{Time: m.Time, MsgID: m.MsgID})
+	if err != nil {
+		return err
+	}
+
+	return redisConn.HSet(common.KeyWithPrefix(LAST_MESSAGES_KEY), m.Channel, string(data)).Err()
+}
+
+// loadLast returns the last published message of channel. It returns
+// nil when there is no published message
+func loadLast(channel string) (*lastMessage, error) {
+	res := redisConn.HGet(common.KeyWithPrefix(LAST_MESSAGES_KEY), channel)
+	if res.Err() == redis.Nil {
+		return nil, nil
+	}
+
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	last := new(lastMessage)
+	if err := json.Unmarshal([]byte(res.Val()), last); err != nil {
+		return nil, err
+	}
+
+	return last, nil
+}
+
+// join joins channel. Messages sent after the last published message
+// of channel are backfilled when server supports chathistory
+func join(channel string) error {
+	last, err := loadLast(channel)
+	if err != nil {
+		log.New("channel", channel).Warn("Could not load last message: %s", err)
+	}
+
+	if last == nil || !conn.HasCapability(client.CAP_CHATHISTORY) {
+		return conn.Join(channel)
+	}
+
+	return conn.JoinWithHistory(channel, last.Time, last.MsgID)
+}
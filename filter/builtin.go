@@ -0,0 +1,258 @@
+package filter
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/ratelimit"
+)
+
+const (
+	FILTER_THROTTLE = "throttle"
+	FILTER_FLOOD    = "flood"
+	FILTER_WORDS    = "words"
+	FILTER_LINKS    = "links"
+
+	// expired flood counters are removed after this many checks
+	PRUNE_INTERVAL = 1000
+)
+
+var (
+	ErrInvalidRepeats = errors.New("invalid flood repeats")
+	ErrInvalidWindow  = errors.New("invalid flood window")
+	ErrInvalidLinks   = errors.New("invalid link limit")
+	ErrWordsNotSet    = errors.New("banned words not set")
+)
+
+// order of built-in filters in chains. cheap filters come first
+var order = []string{FILTER_THROTTLE, FILTER_FLOOD, FILTER_WORDS, FILTER_LINKS}
+
+var builtins = map[string]func(*common.FilterConf) (Filter, error){
+	FILTER_THROTTLE: NewThrottle,
+	FILTER_FLOOD:    NewFlood,
+	FILTER_WORDS:    NewWords,
+	FILTER_LINKS:    NewLinks,
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+`)
+
+// Throttle limits messages of each nickname
+type Throttle struct {
+	conf    *common.FilterConf
+	limiter *ratelimit.Limiter
+}
+
+// NewThrottle creates a throttle with Rate and Burst of conf
+func NewThrottle(c *common.FilterConf) (Filter, error) {
+	limiter, err := ratelimit.NewLimiter(map[string]*ratelimit.Conf{
+		ratelimit.SCOPE_NICKNAME: {Rate: c.Rate, Burst: c.Burst},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Throttle{conf: c, limiter: limiter}, nil
+}
+
+func (t *Throttle) Name() string { return FILTER_THROTTLE }
+
+func (t *Throttle) Check(m *common.Message, direction string) (string, string) {
+	if !applies(t.conf, direction) {
+		return ACTION_ALLOW, ""
+	}
+
+	key := ratelimit.Key{Scope: ratelimit.SCOPE_NICKNAME, Name: strings.ToLower(m.Nickname)}
+	if _, err := t.limiter.Reserve(key); err != nil {
+		return actionOf(t.conf), fmt.Sprintf("%s sends too many messages", m.Nickname)
+	}
+
+	return ACTION_ALLOW, ""
+}
+
+// Flood detects a nickname repeating the same message in a channel
+type Flood struct {
+	conf   *common.FilterConf
+	window time.Duration
+
+	mu       sync.Mutex
+	counters map[string]*counter
+	checks   int
+
+	// used for mocking time in tests
+	now func() time.Time
+}
+
+type counter struct {
+	count int
+	first time.Time
+}
+
+// NewFlood creates a flood filter allowing Repeats copies of a message
+// in Window seconds
+func NewFlood(c *common.FilterConf) (Filter, error) {
+	if c.Repeats <= 0 {
+		return nil, ErrInvalidRepeats
+	}
+
+	if c.Window <= 0 {
+		return nil, ErrInvalidWindow
+	}
+
+	return &Flood{
+		conf:     c,
+		window:   time.Duration(c.Window) * time.Second,
+		counters: make(map[string]*counter),
+		now:      time.Now,
+	}, nil
+}
+
+func (f *Flood) Name() string { return FILTER_FLOOD }
+
+func (f *Flood) Check(m *common.Message, direction string) (string, string) {
+	if !applies(f.conf, direction) {
+		return ACTION_ALLOW, ""
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	f.prune(now)
+
+	hash := sha1.Sum([]byte(strings.ToLower(m.Nickname) + "\n" + m.Channel + "\n" + m.Body))
+	key := string(hash[:])
+	c, ok := f.counters[key]
+	if !ok || now.Sub(c.first) > f.window {
+		c = &counter{first: now}
+		f.counters[key] = c
+	}
+	c.count++
+
+	if c.count > f.conf.Repeats {
+		return actionOf(f.conf), fmt.Sprintf("%s repeated a message %d times", m.Nickname, c.count)
+	}
+
+	return ACTION_ALLOW, ""
+}
+
+// prune removes expired counters periodically
+func (f *Flood) prune(now time.Time) {
+	f.checks++
+	if f.checks < PRUNE_INTERVAL {
+		return
+	}
+	f.checks = 0
+
+	for key, c := range f.counters {
+		if now.Sub(c.first) > f.window {
+			delete(f.counters, key)
+		}
+	}
+}
+
+// Words detects banned words and phrases
+type Words struct {
+	conf *common.FilterConf
+}
+
+// NewWords creates a filter of Word list
+func NewWords(c *common.FilterConf) (Filter, error) {
+	if len(c.Word) == 0 {
+		return nil, ErrWordsNotSet
+	}
+
+	return &Words{conf: c}, nil
+}
+
+func (w *Words) Name() string { return FILTER_WORDS }
+
+func (w *Words) Check(m *common.Message, direction string) (string, string) {
+	if !applies(w.conf, direction) {
+		return ACTION_ALLOW, ""
+	}
+
+	body := strings.ToLower(m.Body)
+	for _, word := range w.conf.Word {
+		// phrases are matched as substrings, and words as whole words
+		var matched bool
+		if strings.ContainsAny(word, " \t") {
+			matched = strings.Contains(body, strings.ToLower(word))
+		} else {
+			matched = common.Mentions(body, word)
+		}
+
+		if matched {
+			return actionOf(w.conf), fmt.Sprintf("contains banned word %q", word)
+		}
+	}
+
+	return ACTION_ALLOW, ""
+}
+
+// Links detects messages with too many links or links of banned domains
+type Links struct {
+	conf *common.FilterConf
+}
+
+// NewLinks creates a link spam filter
+func NewLinks(c *common.FilterConf) (Filter, error) {
+	if c.MaxLinks < 0 {
+		return nil, ErrInvalidLinks
+	}
+
+	return &Links{conf: c}, nil
+}
+
+func (l *Links) Name() string { return FILTER_LINKS }
+
+func (l *Links) Check(m *common.Message, direction string) (string, string) {
+	if !applies(l.conf, direction) {
+		return ACTION_ALLOW, ""
+	}
+
+	links := linkPattern.FindAllString(m.Body, -1)
+	if l.conf.MaxLinks > 0 && len(links) > l.conf.MaxLinks {
+		return actionOf(l.conf), fmt.Sprintf("contains %d links", len(links))
+	}
+
+	for _, link := range links {
+		if domain := l.banned(link); domain != "" {
+			return actionOf(l.conf), fmt.Sprintf("links to banned domain %s", domain)
+		}
+	}
+
+	return ACTION_ALLOW, ""
+}
+
+// banned returns the banned domain of link, or an empty string
+func (l *Links) banned(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	host := strings.ToLower(u.Host)
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+
+	for _, domain := range l.conf.Domain {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return domain
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,290 @@
+// Package filter inspects inbound and outbound messages for spam and
+// abuse.
+//
+// A Chain runs its filters in order. Each filter either allows a
+// message, tags it, drops it or quarantines it. Tagged messages are
+// delivered with the tag in their flags, and the chain goes on with the
+// next filter. Dropped and quarantined messages are not delivered, and
+// quarantined ones are stored for review. Decisions other than allow are
+// logged and counted.
+//
+// Built-in filters are configured by their names:
+//
+//	[filter "flood"]
+//	Action  = drop
+//	Repeats = 3
+//	Window  = 30
+package filter
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/logger"
+	"github.com/canthefason/irc-k/metrics"
+	"gopkg.in/redis.v2"
+)
+
+const (
+	ACTION_ALLOW      = "allow"
+	ACTION_TAG        = "tag"
+	ACTION_DROP       = "drop"
+	ACTION_QUARANTINE = "quarantine"
+
+	// messages received from channels
+	DIRECTION_INBOUND = "inbound"
+
+	// messages sent via api
+	DIRECTION_OUTBOUND = "outbound"
+
+	// used for storing quarantined messages in a list
+	QUARANTINE_KEY = "filter:quarantine"
+
+	// maximum number of stored quarantined messages
+	QUARANTINE_SIZE = 1000
+)
+
+var (
+	ErrFiltered         = errors.New("message filtered")
+	ErrUnknownFilter    = errors.New("unknown filter")
+	ErrInvalidAction    = errors.New("invalid filter action")
+	ErrInvalidDirection = errors.New("invalid filter direction")
+	ErrNotFound         = errors.New("quarantined message not found")
+
+	decisions = metrics.NewCounter("irck_filter_decisions_total",
+		"Filter decisions by filter, direction and action", "filter", "direction", "action")
+)
+
+// Filter inspects a message. It returns ACTION_ALLOW for messages it
+// does not object to, and a reason for other actions
+type Filter interface {
+	Name() string
+	Check(m *common.Message, direction string) (action, reason string)
+}
+
+// Decision is the outcome of a chain for a message
+type Decision struct {
+	Action string `json:"action"`
+	Filter string `json:"filter,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Blocked checks whether message must not be delivered
+func (d Decision) Blocked() bool {
+	return d.Action == ACTION_DROP || d.Action == ACTION_QUARANTINE
+}
+
+// Validate checks action and direction of filter
+func Validate(c *common.FilterConf) error {
+	switch c.Action {
+	case "", ACTION_TAG, ACTION_DROP, ACTION_QUARANTINE:
+	default:
+		return ErrInvalidAction
+	}
+
+	switch c.Direction {
+	case "", DIRECTION_INBOUND, DIRECTION_OUTBOUND:
+	default:
+		return ErrInvalidDirection
+	}
+
+	return nil
+}
+
+// actionOf returns configured action, defaulting to drop
+func actionOf(c *common.FilterConf) string {
+	if c.Action == "" {
+		return ACTION_DROP
+	}
+
+	return c.Action
+}
+
+// applies checks whether messages of direction are filtered
+func applies(c *common.FilterConf, direction string) bool {
+	return c.Direction == "" || c.Direction == direction
+}
+
+// Chain runs filters in order
+type Chain struct {
+	Log logger.Logger
+
+	// Quarantine stores quarantined messages. They are only dropped
+	// when it is nil
+	Quarantine *Quarantine
+
+	filters []Filter
+}
+
+// NewChain creates a chain of built-in filters configured by name.
+// Built-ins are run in throttle, flood, words and links order
+func NewChain(confs map[string]*common.FilterConf) (*Chain, error) {
+	c := &Chain{Log: logger.New("filter")}
+
+	for name := range confs {
+		if _, ok := builtins[name]; !ok {
+			return nil, ErrUnknownFilter
+		}
+	}
+
+	for _, name := range order {
+		conf, ok := confs[name]
+		if !ok || conf == nil {
+			continue
+		}
+
+		if err := Validate(conf); err != nil {
+			return nil, err
+		}
+
+		f, err := builtins[name](conf)
+		if err != nil {
+			return nil, err
+		}
+		c.filters = append(c.filters, f)
+	}
+
+	return c, nil
+}
+
+// Add appends filters to chain
+func (c *Chain) Add(filters ...Filter) {
+	c.filters = append(c.filters, filters...)
+}
+
+// Check runs filters until one of them blocks message. Tags of
+// filters are added to message flags
+func (c *Chain) Check(m *common.Message, direction string) Decision {
+	decision := Decision{Action: ACTION_ALLOW}
+	for _, f := range c.filters {
+		action, reason := f.Check(m, direction)
+		if action == ACTION_ALLOW {
+			continue
+		}
+
+		decision = Decision{Action: action, Filter: f.Name(), Reason: reason}
+		c.record(m, direction, decision)
+		if decision.Blocked() {
+			return decision
+		}
+
+		m.Flags = append(m.Flags, f.Name())
+	}
+
+	return decision
+}
+
+// record logs and counts decision, and quarantines message when needed
+func (c *Chain) record(m *common.Message, direction string, d Decision) {
+	decisions.Inc(d.Filter, direction, d.Action)
+
+	log := c.Log.New("filter", d.Filter).New("channel", m.Channel).New("nickname", m.Nickname)
+	log.Info("Message is %s: %s", actionVerbs[d.Action], d.Reason)
+
+	if d.Action != ACTION_QUARANTINE || c.Quarantine == nil {
+		return
+	}
+
+	entry := &Entry{ID: common.NewID(8), Message: *m, Channel: m.Channel, Direction: direction, Decision: d, Time: time.Now().UTC()}
+	if err := c.Quarantine.Add(entry); err != nil {
+		log.Error("Could not quarantine message: %s", err)
+	}
+}
+
+var actionVerbs = map[string]string{
+	ACTION_TAG:        "tagged",
+	ACTION_DROP:       "dropped",
+	ACTION_QUARANTINE: "quarantined",
+}
+
+// Entry is a quarantined message
+type Entry struct {
+	ID        string         `json:"id"`
+	Message   common.Message `json:"message"`
+	Channel   string         `json:"channel"`
+	Direction string         `json:"direction"`
+	Decision  Decision       `json:"decision"`
+	Time      time.Time      `json:"time"`
+}
+
+// Quarantine keeps the last quarantined messages in redis
+type Quarantine struct {
+	redisConn *redis.Client
+}
+
+// NewQuarantine creates a quarantine with the given redis connection
+func NewQuarantine(r *redis.Client) *Quarantine {
+	return &Quarantine{redisConn: r}
+}
+
+// Add stores entry. Only the last QUARANTINE_SIZE entries are kept
+func (q *Quarantine) Add(e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.redisConn.Pipelined(func(p *redis.Pipeline) error {
+		p.LPush(common.KeyWithPrefix(QUARANTINE_KEY), string(data))
+		p.LTrim(common.KeyWithPrefix(QUARANTINE_KEY), 0, QUARANTINE_SIZE-1)
+
+		return nil
+	})
+
+	return err
+}
+
+// List returns the newest quarantined messages
+func (q *Quarantine) List(count int) ([]*Entry, error) {
+	res := q.redisConn.LRange(common.KeyWithPrefix(QUARANTINE_KEY), 0, int64(count-1))
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	entries := make([]*Entry, 0, len(res.Val()))
+	for _, data := range res.Val() {
+		e := new(Entry)
+		if err := json.Unmarshal([]byte(data), e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// Take removes quarantined message with the given id and returns it
+func (q *Quarantine) Take(id string) (*Entry, error) {
+	key := common.KeyWithPrefix(QUARANTINE_KEY)
+	res := q.redisConn.LRange(key, 0, -1)
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	for _, data := range res.Val() {
+		e := new(Entry)
+		if err := json.Unmarshal([]byte(data), e); err != nil {
+			return nil, err
+		}
+
+		if e.ID != id {
+			continue
+		}
+
+		removed := q.redisConn.LRem(key, 1, data)
+		if removed.Err() != nil {
+			return nil, removed.Err()
+		}
+
+		// message is released by another request
+		if removed.Val() == 0 {
+			return nil, ErrNotFound
+		}
+
+		return e, nil
+	}
+
+	return nil, ErrNotFound
+}
@@ -0,0 +1,171 @@
+package filter
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+// run sends messages through chain, and counts their actions
+func run(c *Chain, messages []common.Message, direction string) map[string]int {
+	actions := make(map[string]int)
+	for _, m := range messages {
+		actions[c.Check(&m, direction).Action]++
+	}
+
+	return actions
+}
+
+func TestNewChain(t *testing.T) {
+	if _, err := NewChain(map[string]*common.FilterConf{"captcha": {}}); err != ErrUnknownFilter {
+		t.Errorf("Expected %s but got %v", ErrUnknownFilter, err)
+	}
+
+	if _, err := NewChain(map[string]*common.FilterConf{FILTER_FLOOD: {Action: "ban", Repeats: 1, Window: 1}}); err != ErrInvalidAction {
+		t.Errorf("Expected %s but got %v", ErrInvalidAction, err)
+	}
+
+	if _, err := NewChain(map[string]*common.FilterConf{FILTER_FLOOD: {}}); err != ErrInvalidRepeats {
+		t.Errorf("Expected %s but got %v", ErrInvalidRepeats, err)
+	}
+}
+
+func TestFlood(t *testing.T) {
+	c, err := NewChain(map[string]*common.FilterConf{FILTER_FLOOD: {Repeats: 3, Window: 30}})
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	now := time.Now()
+	c.filters[0].(*Flood).now = func() time.Time { return now }
+
+	messages := make([]common.Message, 0)
+	for i := 0; i < 10; i++ {
+		messages = append(messages,
+			common.Message{Nickname: "animal", Channel: "muppets", Body: "DRUMS!"},
+			common.Message{Nickname: "kermit", Channel: "muppets", Body: fmt.Sprintf("line %d", i)})
+	}
+
+	actions := run(c, messages, DIRECTION_INBOUND)
+	expected := map[string]int{ACTION_ALLOW: 13, ACTION_DROP: 7}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected %v but got %v", expected, actions)
+	}
+
+	// counters are reset after window
+	now = now.Add(31 * time.Second)
+	m := common.Message{Nickname: "animal", Channel: "muppets", Body: "DRUMS!"}
+	if d := c.Check(&m, DIRECTION_INBOUND); d.Action != ACTION_ALLOW {
+		t.Errorf("Expected %s but got %s", ACTION_ALLOW, d.Action)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	c, err := NewChain(map[string]*common.FilterConf{FILTER_THROTTLE: {Rate: 0.01, Burst: 5, Direction: DIRECTION_OUTBOUND}})
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	messages := make([]common.Message, 0)
+	for i := 0; i < 8; i++ {
+		messages = append(messages, common.Message{Nickname: "Swedish-Chef", Body: fmt.Sprintf("bork %d", i)})
+	}
+	messages = append(messages, common.Message{Nickname: "beaker", Body: "meep"})
+
+	actions := run(c, messages, DIRECTION_OUTBOUND)
+	expected := map[string]int{ACTION_ALLOW: 6, ACTION_DROP: 3}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected %v but got %v", expected, actions)
+	}
+
+	// inbound messages are not throttled
+	actions = run(c, messages, DIRECTION_INBOUND)
+	if actions[ACTION_ALLOW] != len(messages) {
+		t.Errorf("Expected %d but got %d", len(messages), actions[ACTION_ALLOW])
+	}
+}
+
+func TestWordsAndLinks(t *testing.T) {
+	c, err := NewChain(map[string]*common.FilterConf{
+		FILTER_WORDS: {Action: ACTION_TAG, Word: []string{"pigs", "in space"}},
+		FILTER_LINKS: {Action: ACTION_QUARANTINE, MaxLinks: 2, Domain: []string{"spam.example"}},
+	})
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	tests := []struct {
+		body   string
+		action string
+		flags  []string
+	}{
+		{"guinea pigsty", ACTION_ALLOW, nil},
+		{"PIGS!", ACTION_TAG, []string{FILTER_WORDS}},
+		{"pigs In Space", ACTION_TAG, []string{FILTER_WORDS}},
+		{"see http://a.example http://b.example", ACTION_ALLOW, nil},
+		{"see http://a.example www.b.example https://c.example", ACTION_QUARANTINE, nil},
+		{"free stuff at https://www.SPAM.example:8080/x", ACTION_QUARANTINE, nil},
+		{"pigs at notspam.example.org", ACTION_TAG, []string{FILTER_WORDS}},
+		{"pigs at www.spam.example", ACTION_QUARANTINE, []string{FILTER_WORDS}},
+	}
+
+	for _, test := range tests {
+		m := common.Message{Nickname: "statler", Body: test.body}
+		d := c.Check(&m, DIRECTION_INBOUND)
+		if d.Action != test.action {
+			t.Errorf("Expected %s but got %s for %q", test.action, d.Action, test.body)
+		}
+
+		if !reflect.DeepEqual(m.Flags, test.flags) {
+			t.Errorf("Expected %v but got %v for %q", test.flags, m.Flags, test.body)
+		}
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	conf := &common.RedisConf{Server: "localhost", Port: "6379", DB: 3}
+	if env := os.Getenv("REDIS_HOST"); env != "" {
+		conf.Server = env
+	}
+
+	if env := os.Getenv("REDIS_PORT"); env != "" {
+		conf.Port = env
+	}
+
+	redisConn := common.NewRedis(conf)
+	defer redisConn.Close()
+	defer redisConn.Del(common.KeyWithPrefix(QUARANTINE_KEY))
+
+	q := NewQuarantine(redisConn)
+	e := &Entry{ID: "1", Message: common.Message{Nickname: "piggy", Body: "spam"}, Channel: "muppets",
+		Direction: DIRECTION_INBOUND}
+	if err := q.Add(e); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	entries, err := q.List(10)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if len(entries) != 1 || entries[0].ID != "1" {
+		t.Fatalf("Expected %d entry but got %v", 1, entries)
+	}
+
+	released, err := q.Take("1")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if released.Message.Body != "spam" {
+		t.Errorf("Expected %s but got %s", "spam", released.Message.Body)
+	}
+
+	if _, err := q.Take("1"); err != ErrNotFound {
+		t.Errorf("Expected %s but got %v", ErrNotFound, err)
+	}
+}
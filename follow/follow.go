@@ -0,0 +1,242 @@
+// Package follow keeps channels followed by each user.
+//
+// Followed channels are kept apart from the channels requested by
+// subscribers, and feeder bots stay in the union of them. A channel is
+// queued for feeder bots when it gets its first follower, unless it is
+// already requested, and it is removed from followed channels when its
+// last follower leaves. Feeder bots periodically part channels which are
+// neither followed nor requested anymore.
+package follow
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"gopkg.in/redis.v2"
+)
+
+const (
+	// hash of channel -> follow of a user
+	FOLLOWS_KEY = "follows"
+
+	// hash of channel -> follower count
+	FOLLOWERS_KEY = common.FOLLOWED_CHANNELS_KEY
+)
+
+var ErrNotFound = errors.New("channel not followed")
+
+// adds follow of user when it does not exist, and increments follower
+// count of channel. It returns the follower count, or 0 when channel is
+// already followed
+var followScript = `
+if redis.call("HSETNX", KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+return redis.call("HINCRBY", KEYS[2], ARGV[1], 1)`
+
+// updates follow of user when it exists. It returns 0 when channel is
+// not followed
+var updateScript = `
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+return 1`
+
+// removes follow of user, and removes channel from followed channels
+// when it has no followers. It returns -1 when channel is not followed
+var unfollowScript = `
+if redis.call("HDEL", KEYS[1], ARGV[1]) == 0 then
+	return -1
+end
+local count = redis.call("HINCRBY", KEYS[2], ARGV[1], -1)
+if count <= 0 then
+	redis.call("HDEL", KEYS[2], ARGV[1])
+end
+return count`
+
+// Settings are preferences of a user for a followed channel
+type Settings struct {
+	// Muted channels are not counted as unread
+	Muted bool `json:"muted,omitempty"`
+	// Format of message bodies preferred by the client
+	Format string `json:"format,omitempty"`
+}
+
+// Follow is a channel followed by a user
+type Follow struct {
+	Channel  string    `json:"channel"`
+	JoinedAt time.Time `json:"joinedAt"`
+	Settings Settings  `json:"settings"`
+}
+
+// Store keeps follows in redis
+type Store struct {
+	redisConn *redis.Client
+}
+
+// NewStore creates a store with the given redis connection
+func NewStore(r *redis.Client) *Store {
+	return &Store{redisConn: r}
+}
+
+// Follow adds channel to the follows of user, or updates its settings
+// when it is already followed. Channel is requested from feeder bots
+// when it is followed for the first time
+func (s *Store) Follow(user, channel string, settings Settings) (*Follow, error) {
+	channel = common.NormalizeChannel(channel)
+	if channel == "" {
+		return nil, common.ErrChannelNotSet
+	}
+
+	keys := []string{followsKey(user), common.KeyWithPrefix(FOLLOWERS_KEY)}
+	for {
+		f := &Follow{Channel: channel, JoinedAt: time.Now().UTC(), Settings: settings}
+		count, err := s.eval(followScript, keys, channel, f)
+		if err != nil {
+			return nil, err
+		}
+
+		if count == 1 {
+			return f, s.request(channel)
+		}
+
+		if count > 1 {
+			return f, nil
+		}
+
+		// settings of existing follow are updated, and it is added again
+		// when it is unfollowed in the meantime
+		existing, err := s.Get(user, channel)
+		if err == ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		f.JoinedAt = existing.JoinedAt
+		updated, err := s.eval(updateScript, keys[:1], channel, f)
+		if err != nil {
+			return nil, err
+		}
+
+		if updated == 1 {
+			return f, nil
+		}
+	}
+}
+
+// eval runs script with channel and follow as arguments, and returns
+// its integer reply
+func (s *Store) eval(script string, keys []string, channel string, f *Follow) (int64, error) {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return 0, err
+	}
+
+	res := s.redisConn.Eval(script, keys, []string{channel, string(data)})
+	if res.Err() != nil {
+		return 0, res.Err()
+	}
+
+	count, _ := res.Val().(int64)
+
+	return count, nil
+}
+
+// Unfollow removes channel from the follows of user. Channel is removed
+// from followed channels when it has no followers
+func (s *Store) Unfollow(user, channel string) error {
+	keys := []string{followsKey(user), common.KeyWithPrefix(FOLLOWERS_KEY)}
+	res := s.redisConn.Eval(unfollowScript, keys, []string{common.NormalizeChannel(channel)})
+	if res.Err() != nil {
+		return res.Err()
+	}
+
+	if count, ok := res.Val().(int64); ok && count < 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Get returns follow of user for channel
+func (s *Store) Get(user, channel string) (*Follow, error) {
+	res := s.redisConn.HGet(followsKey(user), common.NormalizeChannel(channel))
+	if res.Err() == redis.Nil {
+		return nil, ErrNotFound
+	}
+
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	f := new(Follow)
+	if err := json.Unmarshal([]byte(res.Val()), f); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// List returns channels followed by user
+func (s *Store) List(user string) ([]*Follow, error) {
+	res := s.redisConn.HVals(followsKey(user))
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	follows := make([]*Follow, 0, len(res.Val()))
+	for _, data := range res.Val() {
+		f := new(Follow)
+		if err := json.Unmarshal([]byte(data), f); err != nil {
+			return nil, err
+		}
+		follows = append(follows, f)
+	}
+
+	return follows, nil
+}
+
+// Channels returns follower counts of all followed channels
+func (s *Store) Channels() (map[string]int64, error) {
+	res := s.redisConn.HGetAllMap(common.KeyWithPrefix(FOLLOWERS_KEY))
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	channels := make(map[string]int64, len(res.Val()))
+	for channel, count := range res.Val() {
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		channels[channel] = n
+	}
+
+	return channels, nil
+}
+
+// request queues channel for feeder bots unless it is already requested
+// by subscribers
+func (s *Store) request(channel string) error {
+	res := s.redisConn.SIsMember(common.KeyWithPrefix(common.REQ_CHANNELS_KEY), channel)
+	if res.Err() != nil {
+		return res.Err()
+	}
+
+	if res.Val() {
+		return nil
+	}
+
+	return common.MustGetQueue().Queue(channel)
+}
+
+func followsKey(user string) string {
+	return common.KeyWithPrefix(FOLLOWS_KEY + ":" + user)
+}
@@ -0,0 +1,90 @@
+package follow
+
+import (
+	"os"
+	"testing"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+func TestFollow(t *testing.T) {
+	conf := &common.RedisConf{Server: "localhost", Port: "6379", DB: 3}
+	if env := os.Getenv("REDIS_HOST"); env != "" {
+		conf.Server = env
+	}
+
+	if env := os.Getenv("REDIS_PORT"); env != "" {
+		conf.Port = env
+	}
+
+	redisConn := common.Initialize(conf)
+	defer common.Close()
+	defer common.MustGetQueue().Purge()
+	defer redisConn.Del(common.KeyWithPrefix(FOLLOWERS_KEY), common.KeyWithPrefix(common.REQ_CHANNELS_KEY),
+		followsKey("kermit"), followsKey("piggy"))
+
+	s := NewStore(redisConn)
+	if _, err := s.Follow("kermit", "#Muppets", Settings{}); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if _, err := s.Follow("piggy", "muppets", Settings{Muted: true}); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	channel, err := common.MustGetQueue().Dequeue()
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if channel != "muppets" {
+		t.Errorf("Expected %s but got %s", "muppets", channel)
+	}
+
+	if length, _ := common.MustGetQueue().Len(); length != 0 {
+		t.Errorf("Expected channel to be queued once but got %d more", length)
+	}
+
+	channels, err := s.Channels()
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if channels["muppets"] != 2 {
+		t.Errorf("Expected %d but got %d", 2, channels["muppets"])
+	}
+
+	// settings updates keep join time and follower count
+	before, err := s.Get("piggy", "muppets")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	f, err := s.Follow("piggy", "#muppets", Settings{Format: "text"})
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if !f.JoinedAt.Equal(before.JoinedAt) || f.Settings.Muted {
+		t.Errorf("Expected updated follow joined at %s but got %v", before.JoinedAt, f)
+	}
+
+	if channels, _ := s.Channels(); channels["muppets"] != 2 {
+		t.Errorf("Expected %d but got %d", 2, channels["muppets"])
+	}
+
+	s.Unfollow("kermit", "muppets")
+	s.Unfollow("piggy", "muppets")
+	if err := s.Unfollow("piggy", "muppets"); err != ErrNotFound {
+		t.Errorf("Expected %s but got %v", ErrNotFound, err)
+	}
+
+	requested, err := common.IsRequested(redisConn, "#Muppets")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if requested {
+		t.Error("Expected channel not to be requested")
+	}
+}
@@ -0,0 +1,344 @@
+// Package formatting converts irc formatting codes. Message bodies are
+// parsed into spans of equally styled text, which are rendered as html
+// or markdown, or stripped to plain text. Markdown sent via api can be
+// converted into irc formatting codes.
+package formatting
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// irc formatting codes
+const (
+	BOLD          = '\x02'
+	COLOR         = '\x03'
+	HEX_COLOR     = '\x04'
+	RESET         = '\x0f'
+	MONOSPACE     = '\x11'
+	REVERSE       = '\x16'
+	ITALIC        = '\x1d'
+	STRIKETHROUGH = '\x1e'
+	UNDERLINE     = '\x1f'
+)
+
+const (
+	// bodies are kept as they are
+	FORMAT_RAW = "raw"
+
+	// formatting codes are removed
+	FORMAT_PLAIN = "plain"
+
+	FORMAT_HTML     = "html"
+	FORMAT_MARKDOWN = "markdown"
+
+	// used for spans without colours. colour 99 is also treated as
+	// default colour
+	NO_COLOR = -1
+)
+
+var (
+	ErrInvalidFormat = errors.New("invalid format")
+
+	// standard irc colours. extended colours (16-98) are not rendered
+	palette = []string{
+		"#ffffff", "#000000", "#00007f", "#009300",
+		"#ff0000", "#7f0000", "#9c009c", "#fc7f00",
+		"#ffff00", "#00fc00", "#009393", "#00ffff",
+		"#0000fc", "#ff00ff", "#7f7f7f", "#d2d2d2",
+	}
+
+	// characters escaped in markdown output
+	markdownEscaper = strings.NewReplacer(
+		`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "[", `\[`, "]", `\]`,
+	)
+)
+
+// Style holds formatting of a span
+type Style struct {
+	Bold          bool
+	Italic        bool
+	Underline     bool
+	Strikethrough bool
+	Monospace     bool
+	Reverse       bool
+	Foreground    int
+	Background    int
+}
+
+// Span is a text with a single style
+type Span struct {
+	Text  string
+	Style Style
+}
+
+func defaultStyle() Style {
+	return Style{Foreground: NO_COLOR, Background: NO_COLOR}
+}
+
+// Validate checks whether given format is supported. Empty format is
+// treated as raw
+func Validate(format string) error {
+	switch format {
+	case "", FORMAT_RAW, FORMAT_PLAIN, FORMAT_HTML, FORMAT_MARKDOWN:
+		return nil
+	default:
+		return ErrInvalidFormat
+	}
+}
+
+// Convert renders irc formatted body in given format
+func Convert(body, format string) (string, error) {
+	switch format {
+	case "", FORMAT_RAW:
+		return body, nil
+	case FORMAT_PLAIN:
+		return Strip(body), nil
+	case FORMAT_HTML:
+		return HTML(body), nil
+	case FORMAT_MARKDOWN:
+		return Markdown(body), nil
+	default:
+		return "", ErrInvalidFormat
+	}
+}
+
+// ToIRC converts body of given format into irc formatted text. Html
+// input is not supported
+func ToIRC(body, format string) (string, error) {
+	switch format {
+	case "", FORMAT_RAW:
+		return body, nil
+	case FORMAT_PLAIN:
+		return Strip(body), nil
+	case FORMAT_MARKDOWN:
+		return FromMarkdown(body), nil
+	default:
+		return "", ErrInvalidFormat
+	}
+}
+
+// Parse splits irc formatted text into styled spans
+func Parse(s string) []Span {
+	spans := make([]Span, 0)
+	style := defaultStyle()
+
+	var text bytes.Buffer
+	flush := func() {
+		if text.Len() > 0 {
+			spans = append(spans, Span{Text: text.String(), Style: style})
+			text.Reset()
+		}
+	}
+
+	// formatting codes are ascii, so they never match bytes of
+	// multi-byte UTF-8 sequences
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case BOLD:
+			flush()
+			style.Bold = !style.Bold
+		case ITALIC:
+			flush()
+			style.Italic = !style.Italic
+		case UNDERLINE:
+			flush()
+			style.Underline = !style.Underline
+		case STRIKETHROUGH:
+			flush()
+			style.Strikethrough = !style.Strikethrough
+		case MONOSPACE:
+			flush()
+			style.Monospace = !style.Monospace
+		case REVERSE:
+			flush()
+			style.Reverse = !style.Reverse
+		case RESET:
+			flush()
+			style = defaultStyle()
+		case COLOR:
+			flush()
+			fg, bg, hasBg, n := parseColor(s[i+1:])
+			i += n
+			if n == 0 {
+				// colour code without numbers resets colours
+				style.Foreground, style.Background = NO_COLOR, NO_COLOR
+				continue
+			}
+
+			style.Foreground = fg
+			if hasBg {
+				style.Background = bg
+			}
+		case HEX_COLOR:
+			// hex colours are not rendered, and they only reset colours
+			flush()
+			i += hexColorLength(s[i+1:])
+			style.Foreground, style.Background = NO_COLOR, NO_COLOR
+		default:
+			text.WriteByte(s[i])
+		}
+	}
+	flush()
+
+	return spans
+}
+
+// parseColor reads fg[,bg] colour codes and returns the number of bytes
+// read
+func parseColor(s string) (fg, bg int, hasBg bool, n int) {
+	fg, n = parseNumber(s)
+	if n == 0 {
+		return NO_COLOR, NO_COLOR, false, 0
+	}
+
+	if n < len(s) && s[n] == ',' {
+		if b, m := parseNumber(s[n+1:]); m > 0 {
+			return fg, b, true, n + m + 1
+		}
+	}
+
+	return fg, NO_COLOR, false, n
+}
+
+// parseNumber reads a colour number of at most two digits
+func parseNumber(s string) (int, int) {
+	n, value := 0, 0
+	for n < 2 && n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		value = value*10 + int(s[n]-'0')
+		n++
+	}
+
+	if n == 0 || value == 99 {
+		return NO_COLOR, n
+	}
+
+	return value, n
+}
+
+// hexColorLength returns the length of RRGGBB[,RRGGBB] colour codes
+func hexColorLength(s string) int {
+	isHex := func(s string) bool {
+		if len(s) < 6 {
+			return false
+		}
+		for i := 0; i < 6; i++ {
+			if !strings.ContainsRune("0123456789abcdefABCDEF", rune(s[i])) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if !isHex(s) {
+		return 0
+	}
+
+	if len(s) > 6 && s[6] == ',' && isHex(s[7:]) {
+		return 13
+	}
+
+	return 6
+}
+
+// Strip removes formatting codes
+func Strip(s string) string {
+	var out bytes.Buffer
+	for _, span := range Parse(s) {
+		out.WriteString(span.Text)
+	}
+
+	return out.String()
+}
+
+// HTML renders formatted text as escaped html
+func HTML(s string) string {
+	var out bytes.Buffer
+	for _, span := range Parse(s) {
+		text := html.EscapeString(span.Text)
+		st := span.Style
+
+		if st.Monospace {
+			text = "<code>" + text + "</code>"
+		}
+		if st.Strikethrough {
+			text = "<s>" + text + "</s>"
+		}
+		if st.Underline {
+			text = "<u>" + text + "</u>"
+		}
+		if st.Italic {
+			text = "<i>" + text + "</i>"
+		}
+		if st.Bold {
+			text = "<b>" + text + "</b>"
+		}
+		if css := colorStyle(st); css != "" {
+			text = fmt.Sprintf(`<span style="%s">%s</span>`, css, text)
+		}
+
+		out.WriteString(text)
+	}
+
+	return out.String()
+}
+
+// colorStyle returns inline css of span colours
+func colorStyle(st Style) string {
+	fg, bg := st.Foreground, st.Background
+	if st.Reverse {
+		fg, bg = bg, fg
+	}
+
+	css := make([]string, 0, 2)
+	if fg >= 0 && fg < len(palette) {
+		css = append(css, "color:"+palette[fg])
+	}
+	if bg >= 0 && bg < len(palette) {
+		css = append(css, "background-color:"+palette[bg])
+	}
+
+	return strings.Join(css, ";")
+}
+
+// Markdown renders formatted text as markdown. Colours and underlines
+// are not supported by markdown, and they are dropped
+func Markdown(s string) string {
+	var out bytes.Buffer
+	for _, span := range Parse(s) {
+		// markers must be adjacent to text, so surrounding spaces are
+		// written outside of them
+		text := strings.TrimSpace(span.Text)
+		if text == "" {
+			out.WriteString(span.Text)
+			continue
+		}
+		start := strings.Index(span.Text, text)
+		st := span.Style
+
+		if st.Monospace && !strings.Contains(text, "`") {
+			text = "`" + text + "`"
+		} else {
+			text = markdownEscaper.Replace(text)
+		}
+		if st.Strikethrough {
+			text = "~~" + text + "~~"
+		}
+		if st.Italic {
+			text = "_" + text + "_"
+		}
+		if st.Bold {
+			text = "**" + text + "**"
+		}
+
+		out.WriteString(span.Text[:start])
+		out.WriteString(text)
+		out.WriteString(span.Text[start+len(strings.TrimSpace(span.Text)):])
+	}
+
+	return out.String()
+}
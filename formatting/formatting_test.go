@@ -0,0 +1,109 @@
+package formatting
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	spans := Parse("hi \x02kermit\x02 \x034,1frog\x03 \x1Dpiggy\x0f!")
+	expected := []Span{
+		{Text: "hi ", Style: defaultStyle()},
+		{Text: "kermit", Style: Style{Bold: true, Foreground: NO_COLOR, Background: NO_COLOR}},
+		{Text: " ", Style: defaultStyle()},
+		{Text: "frog", Style: Style{Foreground: 4, Background: 1}},
+		{Text: " ", Style: defaultStyle()},
+		{Text: "piggy", Style: Style{Italic: true, Foreground: NO_COLOR, Background: NO_COLOR}},
+		{Text: "!", Style: defaultStyle()},
+	}
+
+	if len(spans) != len(expected) {
+		t.Fatalf("Expected %d spans but got %d: %v", len(expected), len(spans), spans)
+	}
+
+	for i := range spans {
+		if spans[i] != expected[i] {
+			t.Errorf("Expected %v but got %v", expected[i], spans[i])
+		}
+	}
+}
+
+func TestParseColorDigits(t *testing.T) {
+	// only two digits are read as colour, and comma without digits is text
+	spans := Parse("\x03123\x03,x")
+	if spans[0].Text != "3" || spans[0].Style.Foreground != 12 {
+		t.Errorf("Expected %s with colour %d but got %v", "3", 12, spans[0])
+	}
+
+	if spans[1].Text != ",x" {
+		t.Errorf("Expected %s but got %s", ",x", spans[1].Text)
+	}
+}
+
+func TestStrip(t *testing.T) {
+	s := Strip("\x02bold\x02 \x0312,4colour\x03 \x04ff0000hex\x0f \x1funder")
+	if s != "bold colour hex under" {
+		t.Errorf("Expected %s but got %s", "bold colour hex under", s)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	s := HTML("<b> \x02\x1Dkermit\x0f \x034frog")
+	expected := `&lt;b&gt; <b><i>kermit</i></b> <span style="color:#ff0000">frog</span>`
+	if s != expected {
+		t.Errorf("Expected %s but got %s", expected, s)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	s := Markdown("snake_case \x02 kermit \x02\x11code\x11 \x1ethe end")
+	expected := "snake\\_case  **kermit** `code` ~~the end~~"
+	if s != expected {
+		t.Errorf("Expected %s but got %s", expected, s)
+	}
+}
+
+func TestFromMarkdown(t *testing.T) {
+	tests := map[string]string{
+		"**kermit** the _frog_":   "\x02kermit\x02 the \x1dfrog\x1d",
+		"~~piggy~~ `snake_case`":  "\x1epiggy\x1e \x11snake_case\x11",
+		"snake_case and 2 * 3":    "snake_case and 2 * 3",
+		"\\*not italic\\*":        "*not italic*",
+		"*unclosed marker":        "*unclosed marker",
+		"__bold__ and *italic*":   "\x02bold\x02 and \x1ditalic\x1d",
+		"Markdown(**bold**) ok_x": "Markdown(\x02bold\x02) ok_x",
+	}
+
+	for md, expected := range tests {
+		if s := FromMarkdown(md); s != expected {
+			t.Errorf("Expected %q but got %q", expected, s)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	if _, err := Convert("kermit", "bbcode"); err != ErrInvalidFormat {
+		t.Errorf("Expected %s but got %v", ErrInvalidFormat, err)
+	}
+
+	s, err := Convert("\x02kermit\x02", FORMAT_PLAIN)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if s != "kermit" {
+		t.Errorf("Expected %s but got %s", "kermit", s)
+	}
+}
+
+func TestToIRC(t *testing.T) {
+	s, err := ToIRC("**kermit**", FORMAT_MARKDOWN)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if s != "\x02kermit\x02" {
+		t.Errorf("Expected %q but got %q", "\x02kermit\x02", s)
+	}
+
+	if _, err := ToIRC("<b>kermit</b>", FORMAT_HTML); err != ErrInvalidFormat {
+		t.Errorf("Expected %s but got %v", ErrInvalidFormat, err)
+	}
+}
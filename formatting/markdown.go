@@ -0,0 +1,109 @@
+package formatting
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// markdown markers and their irc codes. longer markers are checked first
+var markers = []struct {
+	marker string
+	code   byte
+}{
+	{"**", BOLD},
+	{"__", BOLD},
+	{"~~", STRIKETHROUGH},
+	{"*", ITALIC},
+	{"_", ITALIC},
+}
+
+// FromMarkdown converts bold, italic, strikethrough and inline code
+// markdown into irc formatting codes. Markers without a matching
+// closing marker are kept as they are, and underscores inside words
+// (e.g. snake_case) are not treated as markers
+func FromMarkdown(md string) string {
+	var out bytes.Buffer
+	open := make(map[string]bool)
+
+	for i := 0; i < len(md); {
+		c := md[i]
+
+		if c == '\\' && i+1 < len(md) && strings.IndexByte("\\*_~`[]", md[i+1]) >= 0 {
+			out.WriteByte(md[i+1])
+			i += 2
+			continue
+		}
+
+		if c == '`' {
+			// code spans are not parsed further
+			if j := strings.IndexByte(md[i+1:], '`'); j > 0 {
+				out.WriteByte(MONOSPACE)
+				out.WriteString(md[i+1 : i+1+j])
+				out.WriteByte(MONOSPACE)
+				i += j + 2
+				continue
+			}
+		}
+
+		if m, code, ok := markerAt(md, i, open); ok {
+			open[m] = !open[m]
+			out.WriteByte(code)
+			i += len(m)
+			continue
+		}
+
+		out.WriteByte(c)
+		i++
+	}
+
+	return out.String()
+}
+
+// markerAt returns the marker starting at index i when it opens or
+// closes a style
+func markerAt(md string, i int, open map[string]bool) (string, byte, bool) {
+	for _, m := range markers {
+		if !strings.HasPrefix(md[i:], m.marker) {
+			continue
+		}
+
+		before, _ := utf8.DecodeLastRuneInString(md[:i])
+		after, _ := utf8.DecodeRuneInString(md[i+len(m.marker):])
+		if i == 0 {
+			before = ' '
+		}
+		if i+len(m.marker) == len(md) {
+			after = ' '
+		}
+
+		intraword := m.marker[0] == '_' && (isWordRune(before) && isWordRune(after))
+
+		if open[m.marker] {
+			// closing markers follow text
+			if unicode.IsSpace(before) || intraword || (m.marker[0] == '_' && isWordRune(after)) {
+				return "", 0, false
+			}
+
+			return m.marker, m.code, true
+		}
+
+		// opening markers precede text and must be closed later
+		if unicode.IsSpace(after) || intraword || (m.marker[0] == '_' && isWordRune(before)) {
+			return "", 0, false
+		}
+
+		if !strings.Contains(md[i+len(m.marker):], m.marker) {
+			return "", 0, false
+		}
+
+		return m.marker, m.code, true
+	}
+
+	return "", 0, false
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,168 @@
+// Package health provides liveness and readiness handlers. Components
+// register their checks, and readiness is reported as up only when all
+// registered checks succeed.
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	STATUS_UP   = "up"
+	STATUS_DOWN = "down"
+
+	// maximum duration of a single check
+	CHECK_TIMEOUT = 2 * time.Second
+)
+
+var (
+	ErrCheckTimeout = errors.New("check timeout")
+
+	// DefaultRegistry holds checks registered with package level functions
+	DefaultRegistry = NewRegistry()
+)
+
+// Check returns an error when checked component is not ready
+type Check func() error
+
+// ComponentStatus holds the check result of a component
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report holds overall status and status of each component
+type Report struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+// Registry holds component checks by their names
+type Registry struct {
+	mu     sync.RWMutex
+	checks map[string]Check
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{checks: make(map[string]Check)}
+}
+
+// Register adds a check for the given component. Existing check of the
+// component is replaced.
+func (r *Registry) Register(name string, c Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checks[name] = c
+}
+
+// Unregister removes check of the given component
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.checks, name)
+}
+
+// Check runs all checks concurrently and reports their results
+func (r *Registry) Check() Report {
+	r.mu.RLock()
+	checks := make(map[string]Check, len(r.checks))
+	for name, c := range r.checks {
+		checks[name] = c
+	}
+	r.mu.RUnlock()
+
+	report := Report{Status: STATUS_UP, Components: make(map[string]ComponentStatus)}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, c := range checks {
+		wg.Add(1)
+		go func(name string, c Check) {
+			defer wg.Done()
+
+			status := ComponentStatus{Status: STATUS_UP}
+			if err := run(c); err != nil {
+				status = ComponentStatus{Status: STATUS_DOWN, Error: err.Error()}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[name] = status
+			if status.Status == STATUS_DOWN {
+				report.Status = STATUS_DOWN
+			}
+		}(name, c)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// run executes check with CHECK_TIMEOUT
+func run(c Check) error {
+	res := make(chan error, 1)
+	go func() { res <- c() }()
+
+	select {
+	case err := <-res:
+		return err
+	case <-time.After(CHECK_TIMEOUT):
+		return ErrCheckTimeout
+	}
+}
+
+// Register adds a check to DefaultRegistry
+func Register(name string, c Check) {
+	DefaultRegistry.Register(name, c)
+}
+
+// Unregister removes a check from DefaultRegistry
+func Unregister(name string) {
+	DefaultRegistry.Unregister(name)
+}
+
+// LivenessHandler reports that process is up and serving requests
+func LivenessHandler(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, Report{Status: STATUS_UP})
+}
+
+// ReadinessHandler reports DefaultRegistry check results. It responds
+// with 503 when any of the components is down
+func ReadinessHandler(w http.ResponseWriter, req *http.Request) {
+	DefaultRegistry.ServeHTTP(w, req)
+}
+
+// Handler serves liveness and readiness endpoints at /healthz and
+// /readyz
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", LivenessHandler)
+	mux.HandleFunc("/readyz", ReadinessHandler)
+
+	return mux
+}
+
+// ServeHTTP reports check results of registry
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	report := r.Check()
+
+	status := http.StatusOK
+	if report.Status != STATUS_UP {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
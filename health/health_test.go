@@ -0,0 +1,93 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serve(t *testing.T, h http.Handler) (int, Report) {
+	req, err := http.NewRequest("GET", "/readyz", nil)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	report := Report{}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Errorf("Expected nil but got %s", err)
+	}
+
+	return w.Code, report
+}
+
+func TestReadiness(t *testing.T) {
+	r := NewRegistry()
+	r.Register("redis", func() error { return nil })
+	r.Register("irc:momo-1", func() error { return nil })
+
+	status, report := serve(t, r)
+	if status != http.StatusOK {
+		t.Errorf("Expected %d but got %d", http.StatusOK, status)
+	}
+
+	if report.Status != STATUS_UP {
+		t.Errorf("Expected %s but got %s", STATUS_UP, report.Status)
+	}
+
+	if len(report.Components) != 2 {
+		t.Errorf("Expected %d components but got %d", 2, len(report.Components))
+	}
+}
+
+func TestReadinessComponentDown(t *testing.T) {
+	r := NewRegistry()
+	r.Register("redis", func() error { return nil })
+	r.Register("irc:momo-1", func() error { return errors.New("not connected") })
+
+	status, report := serve(t, r)
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("Expected %d but got %d", http.StatusServiceUnavailable, status)
+	}
+
+	if report.Status != STATUS_DOWN {
+		t.Errorf("Expected %s but got %s", STATUS_DOWN, report.Status)
+	}
+
+	c := report.Components["irc:momo-1"]
+	if c.Status != STATUS_DOWN || c.Error != "not connected" {
+		t.Errorf("Expected down component with error but got %+v", c)
+	}
+
+	r.Unregister("irc:momo-1")
+	if status, _ := serve(t, r); status != http.StatusOK {
+		t.Errorf("Expected %d but got %d", http.StatusOK, status)
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	status, report := serve(t, http.HandlerFunc(LivenessHandler))
+	if status != http.StatusOK {
+		t.Errorf("Expected %d but got %d", http.StatusOK, status)
+	}
+
+	if report.Status != STATUS_UP {
+		t.Errorf("Expected %s but got %s", STATUS_UP, report.Status)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	for _, path := range []string{"/healthz", "/readyz"} {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		Handler().ServeHTTP(res, req)
+
+		if res.Code != http.StatusOK {
+			t.Errorf("Expected %d but got %d for %s", http.StatusOK, res.Code, path)
+		}
+	}
+}
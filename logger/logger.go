@@ -0,0 +1,251 @@
+// Package logger provides leveled loggers with key value fields.
+//
+// Loggers are created per component (client, feeder, api etc.), and each
+// component can have its own log level. Entries are written either in
+// text or json format.
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARNING
+	ERROR
+)
+
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+)
+
+var (
+	ErrInvalidLevel  = errors.New("invalid log level")
+	ErrInvalidFormat = errors.New("invalid log format")
+
+	levelNames = map[Level]string{
+		DEBUG:   "debug",
+		INFO:    "info",
+		WARNING: "warning",
+		ERROR:   "error",
+	}
+
+	// output settings shared by all loggers
+	mu     sync.RWMutex
+	out    io.Writer = os.Stderr
+	format           = FORMAT_TEXT
+	level            = INFO
+	levels           = make(map[string]Level)
+	rawIRC bool
+)
+
+// Level is the severity of log entries
+type Level int
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns level of the given name
+func ParseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return l, nil
+		}
+	}
+
+	return INFO, ErrInvalidLevel
+}
+
+// Conf holds log settings. Format and RawIRC settings are only read
+// from the root log section, and component sections can only override
+// the level.
+type Conf struct {
+	Format string
+	Level  string
+	// enables logging of raw irc traffic at debug level
+	RawIRC bool
+}
+
+// Logger writes leveled log entries. Entry messages are formatted
+// in fmt.Printf manner.
+type Logger interface {
+	Debug(format string, args ...interface{})
+	Info(format string, args ...interface{})
+	Warn(format string, args ...interface{})
+	Error(format string, args ...interface{})
+
+	// New returns a logger which adds the given field to all its entries
+	New(key string, value interface{}) Logger
+}
+
+// Configure sets format and levels. Settings of the root section are
+// stored with empty key, and rest of the keys are component names.
+func Configure(confs map[string]*Conf) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	levels = make(map[string]Level)
+	for component, c := range confs {
+		if c == nil {
+			continue
+		}
+
+		if component == "" {
+			switch c.Format {
+			case "":
+			case FORMAT_TEXT, FORMAT_JSON:
+				format = c.Format
+			default:
+				return ErrInvalidFormat
+			}
+			rawIRC = c.RawIRC
+		}
+
+		if c.Level == "" {
+			continue
+		}
+
+		l, err := ParseLevel(c.Level)
+		if err != nil {
+			return err
+		}
+
+		if component == "" {
+			level = l
+		} else {
+			levels[component] = l
+		}
+	}
+
+	return nil
+}
+
+// SetOutput sets the writer of all loggers
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	out = w
+}
+
+// RawIRC returns true when raw irc traffic logging is enabled
+func RawIRC() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return rawIRC
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+type logger struct {
+	component string
+	fields    []field
+}
+
+// New creates a logger for the given component
+func New(component string) Logger {
+	return &logger{component: component}
+}
+
+func (l *logger) Debug(format string, args ...interface{}) {
+	l.write(DEBUG, format, args)
+}
+
+func (l *logger) Info(format string, args ...interface{}) {
+	l.write(INFO, format, args)
+}
+
+func (l *logger) Warn(format string, args ...interface{}) {
+	l.write(WARNING, format, args)
+}
+
+func (l *logger) Error(format string, args ...interface{}) {
+	l.write(ERROR, format, args)
+}
+
+func (l *logger) New(key string, value interface{}) Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+
+	return &logger{
+		component: l.component,
+		fields:    append(fields, field{key, value}),
+	}
+}
+
+func (l *logger) write(lvl Level, msgFormat string, args []interface{}) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	min, ok := levels[l.component]
+	if !ok {
+		min = level
+	}
+
+	if lvl < min {
+		return
+	}
+
+	msg := fmt.Sprintf(msgFormat, args...)
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	if format == FORMAT_JSON {
+		entry := map[string]interface{}{
+			"time":      now,
+			"level":     lvl.String(),
+			"component": l.component,
+			"msg":       msg,
+		}
+		for _, f := range l.fields {
+			entry[f.key] = jsonValue(f.value)
+		}
+
+		data, err := json.Marshal(entry)
+		if err != nil {
+			data = []byte(fmt.Sprintf(`{"level":"error","msg":%q}`, err.Error()))
+		}
+		fmt.Fprintf(out, "%s\n", data)
+
+		return
+	}
+
+	fields := make([]string, len(l.fields))
+	for i, f := range l.fields {
+		fields[i] = fmt.Sprintf("%s=%v", f.key, f.value)
+	}
+	sort.Strings(fields)
+
+	line := fmt.Sprintf("%s %s [%s]", now, strings.ToUpper(lvl.String()), l.component)
+	if len(fields) > 0 {
+		line += " " + strings.Join(fields, " ")
+	}
+	fmt.Fprintf(out, "%s %s\n", line, msg)
+}
+
+// jsonValue converts errors and stringers into strings, since they are
+// mostly marshalled as empty objects
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case fmt.Stringer:
+		return t.String()
+	}
+
+	return v
+}
@@ -0,0 +1,93 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func tearUp(confs map[string]*Conf) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	SetOutput(buf)
+	if err := Configure(confs); err != nil {
+		panic(err)
+	}
+
+	return buf
+}
+
+func TestTextFormat(t *testing.T) {
+	buf := tearUp(map[string]*Conf{"": {Format: FORMAT_TEXT, Level: "info"}})
+
+	l := New("client").New("nickname", "kermit").New("channel", "muppets")
+	l.Debug("not logged")
+	l.Info("joined %s", "muppets")
+
+	out := buf.String()
+	if strings.Contains(out, "not logged") {
+		t.Errorf("Expected debug entry to be filtered but got %s", out)
+	}
+
+	if !strings.Contains(out, "INFO [client] channel=muppets nickname=kermit joined muppets\n") {
+		t.Errorf("Unexpected log entry: %s", out)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	buf := tearUp(map[string]*Conf{"": {Format: FORMAT_JSON}})
+
+	New("feeder").New("bot", "momo-1").Error("could not join: %s", errors.New("banned"))
+
+	entry := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	expected := map[string]string{
+		"level":     "error",
+		"component": "feeder",
+		"bot":       "momo-1",
+		"msg":       "could not join: banned",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("Expected %s as %s but got %v", value, key, entry[key])
+		}
+	}
+}
+
+func TestComponentLevels(t *testing.T) {
+	buf := tearUp(map[string]*Conf{
+		"":       {Level: "warning"},
+		"client": {Level: "debug"},
+	})
+
+	New("client").Debug("client debug")
+	New("feeder").Info("feeder info")
+	New("feeder").Warn("feeder warning")
+
+	out := buf.String()
+	if !strings.Contains(out, "client debug") {
+		t.Errorf("Expected client debug entry but got %s", out)
+	}
+
+	if strings.Contains(out, "feeder info") {
+		t.Errorf("Expected feeder info entry to be filtered but got %s", out)
+	}
+
+	if !strings.Contains(out, "feeder warning") {
+		t.Errorf("Expected feeder warning entry but got %s", out)
+	}
+}
+
+func TestInvalidConf(t *testing.T) {
+	if err := Configure(map[string]*Conf{"": {Level: "loud"}}); err != ErrInvalidLevel {
+		t.Errorf("Expected %s but got %s", ErrInvalidLevel, err)
+	}
+
+	if err := Configure(map[string]*Conf{"": {Format: "xml"}}); err != ErrInvalidFormat {
+		t.Errorf("Expected %s but got %s", ErrInvalidFormat, err)
+	}
+}
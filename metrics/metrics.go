@@ -0,0 +1,408 @@
+// Package metrics provides counters, gauges and histograms, and exposes
+// them in prometheus text format via an http handler.
+//
+// Metrics are registered to DefaultRegistry when they are created with
+// package level constructors. Each metric can have a fixed set of labels,
+// and label values are given in the same order while updating the metric.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	TYPE_COUNTER   = "counter"
+	TYPE_GAUGE     = "gauge"
+	TYPE_HISTOGRAM = "histogram"
+
+	// content type of prometheus text exposition format
+	CONTENT_TYPE = "text/plain; version=0.0.4"
+)
+
+var (
+	// DefaultRegistry holds all metrics created by package level constructors
+	DefaultRegistry = NewRegistry()
+
+	// DefBuckets are default histogram buckets in seconds
+	DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// collector is implemented by all metric types
+type collector interface {
+	Name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics by their names
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]collector
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// Register adds metric to registry. It panics when a metric with the same
+// name is already registered.
+func (r *Registry) Register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.collectors[c.Name()]; ok {
+		panic(fmt.Sprintf("metric %s is already registered", c.Name()))
+	}
+
+	r.collectors[c.Name()] = c
+}
+
+// ServeHTTP writes all registered metrics ordered by name
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	collectors := make([]collector, len(names))
+	for i, name := range names {
+		collectors[i] = r.collectors[name]
+	}
+	r.mu.RUnlock()
+
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	bw.Flush()
+}
+
+// Handler returns http handler of DefaultRegistry
+func Handler() http.Handler {
+	return DefaultRegistry
+}
+
+// desc holds metric definition
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) Name() string {
+	return d.name
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+// key joins label values for using them as series key. it panics when
+// label value count does not match with metric labels
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values but got %d",
+			d.name, len(d.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats labels as {name="value",...}. extra label pair is
+// appended when it is not empty
+func (d *desc) labelPairs(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(d.labels)+1)
+	for i, label := range d.labels {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", label, escapeLabel(values[i])))
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extra[0], escapeLabel(extra[1])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// series holds value of a metric for a label value combination
+type series struct {
+	values []string
+	value  float64
+}
+
+// vec holds all series of counters and gauges
+type vec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newVec(name, help, typ string, labels []string) vec {
+	return vec{
+		desc:   desc{name: name, help: help, typ: typ, labels: labels},
+		series: make(map[string]*series),
+	}
+}
+
+func (v *vec) update(values []string, f func(float64) float64) {
+	key := v.key(values)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		v.series[key] = s
+	}
+	s.value = f(s.value)
+}
+
+// Value returns current value of series with given label values
+func (v *vec) Value(values ...string) float64 {
+	key := v.key(values)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[key]; ok {
+		return s.value
+	}
+
+	return 0
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelPairs(s.values), formatFloat(s.value))
+	}
+}
+
+// Counter is a monotonically increasing metric
+type Counter struct {
+	vec
+}
+
+// NewCounter creates a counter and registers it to DefaultRegistry
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newVec(name, help, TYPE_COUNTER, labels)}
+	DefaultRegistry.Register(c)
+
+	return c
+}
+
+// Inc increments counter by one
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increments counter by given positive delta
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.name))
+	}
+
+	c.update(values, func(v float64) float64 { return v + delta })
+}
+
+// Gauge is a metric which can go up and down
+type Gauge struct {
+	vec
+}
+
+// NewGauge creates a gauge and registers it to DefaultRegistry
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newVec(name, help, TYPE_GAUGE, labels)}
+	DefaultRegistry.Register(g)
+
+	return g
+}
+
+// Set sets gauge to given value
+func (g *Gauge) Set(value float64, values ...string) {
+	g.update(values, func(float64) float64 { return value })
+}
+
+// Inc increments gauge by one
+func (g *Gauge) Inc(values ...string) {
+	g.Add(1, values...)
+}
+
+// Dec decrements gauge by one
+func (g *Gauge) Dec(values ...string) {
+	g.Add(-1, values...)
+}
+
+// Add adds given delta to gauge
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.update(values, func(v float64) float64 { return v + delta })
+}
+
+// GaugeFunc is a gauge whose value is computed while metrics are collected
+type GaugeFunc struct {
+	desc
+	f func() float64
+}
+
+// NewGaugeFunc creates a gauge func and registers it to DefaultRegistry
+func NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help, typ: TYPE_GAUGE}, f: f}
+	DefaultRegistry.Register(g)
+
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.f()))
+}
+
+// histogramSeries holds bucket counts of a label value combination
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Histogram counts observations in configurable buckets
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// NewHistogram creates a histogram and registers it to DefaultRegistry.
+// When buckets are nil, DefBuckets are used.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &Histogram{
+		desc:    desc{name: name, help: help, typ: TYPE_HISTOGRAM, labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	DefaultRegistry.Register(h)
+
+	return h
+}
+
+// Observe adds given value to histogram
+func (h *Histogram) Observe(value float64, values ...string) {
+	key := h.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			values: append([]string(nil), values...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// Count returns observation count of series with given label values
+func (h *Histogram) Count(values ...string) uint64 {
+	key := h.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[key]; ok {
+		return s.count
+	}
+
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+				h.labelPairs(s.values, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+			h.labelPairs(s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(s.values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(s.values), s.count)
+	}
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch t := m.(type) {
+	case map[string]*series:
+		for key := range t {
+			keys = append(keys, key)
+		}
+	case map[string]*histogramSeries:
+		for key := range t {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
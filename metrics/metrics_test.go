@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Errorf("Expected %d but got %d", 200, w.Code)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != CONTENT_TYPE {
+		t.Errorf("Expected %s but got %s", CONTENT_TYPE, ct)
+	}
+
+	return w.Body.String()
+}
+
+func expectLines(t *testing.T, body string, lines ...string) {
+	for _, line := range lines {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected line %q in\n%s", line, body)
+		}
+	}
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_messages_total", "Received messages", "channel")
+	c.Inc("muppets")
+	c.Add(2, "muppets")
+	c.Inc(`kitchen"s`)
+
+	if c.Value("muppets") != 3 {
+		t.Errorf("Expected %d but got %f", 3, c.Value("muppets"))
+	}
+
+	expectLines(t, scrape(t),
+		"# HELP test_messages_total Received messages",
+		"# TYPE test_messages_total counter",
+		`test_messages_total{channel="muppets"} 3`,
+		`test_messages_total{channel="kitchen\"s"} 1`,
+	)
+}
+
+func TestCounterLabelMismatch(t *testing.T) {
+	c := NewCounter("test_mismatch_total", "Mismatched labels", "channel")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic but got nil")
+		}
+	}()
+
+	c.Inc()
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGauge("test_connections", "Active connections", "nickname")
+	g.Inc("kermit")
+	g.Inc("kermit")
+	g.Dec("kermit")
+	g.Set(5, "piggy")
+
+	NewGaugeFunc("test_queue_length", "Queue length", func() float64 { return 7 })
+
+	expectLines(t, scrape(t),
+		"# TYPE test_connections gauge",
+		`test_connections{nickname="kermit"} 1`,
+		`test_connections{nickname="piggy"} 5`,
+		"test_queue_length 7",
+	)
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_latency_seconds", "Request latency", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/join")
+	h.Observe(0.5, "/join")
+	h.Observe(3, "/join")
+
+	if h.Count("/join") != 3 {
+		t.Errorf("Expected %d but got %d", 3, h.Count("/join"))
+	}
+
+	expectLines(t, scrape(t),
+		"# TYPE test_latency_seconds histogram",
+		`test_latency_seconds_bucket{route="/join",le="0.1"} 1`,
+		`test_latency_seconds_bucket{route="/join",le="1"} 2`,
+		`test_latency_seconds_bucket{route="/join",le="+Inf"} 3`,
+		`test_latency_seconds_sum{route="/join"} 3.55`,
+		`test_latency_seconds_count{route="/join"} 3`,
+	)
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	NewCounter("test_duplicate_total", "Duplicate")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic but got nil")
+		}
+	}()
+
+	NewCounter("test_duplicate_total", "Duplicate")
+}
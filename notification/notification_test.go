@@ -0,0 +1,110 @@
+package notification
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		rule   Rule
+		m      common.Message
+		reason string
+		match  bool
+	}{
+		{Rule{Nickname: "kermit"}, common.Message{Nickname: "piggy", Body: "Kermit: lunch?"}, REASON_MENTION, true},
+		{Rule{Nickname: "kermit"}, common.Message{Nickname: "kermit", Body: "kermit here"}, "", false},
+		{Rule{Nickname: "kermit"}, common.Message{Nickname: "piggy", Body: "kermitt"}, "", false},
+		{Rule{Keywords: []string{"deploy"}}, common.Message{Body: "starting DEPLOY now"}, REASON_KEYWORD, true},
+		{Rule{Keywords: []string{"build failed"}}, common.Message{Body: "Build  failed, build failed!"}, REASON_KEYWORD, true},
+		{Rule{Keywords: []string{"c++"}}, common.Message{Body: "who knows c++?"}, REASON_KEYWORD, true},
+		{Rule{Keywords: []string{"deploy"}}, common.Message{Body: "redeployed"}, "", false},
+		{Rule{Pattern: `outage|incident`}, common.Message{Body: "new incident opened"}, REASON_PATTERN, true},
+		{Rule{Pattern: `outage`, Channels: []string{"#ops"}}, common.Message{Channel: "dev", Body: "outage"}, "", false},
+		{Rule{Pattern: `outage`, Channels: []string{"#Ops"}}, common.Message{Channel: "ops", Body: "outage"}, REASON_PATTERN, true},
+	}
+
+	for _, test := range tests {
+		reason, match := test.rule.Match(test.m)
+		if match != test.match || reason != test.reason {
+			t.Errorf("Expected %s %t but got %s %t for %+v", test.reason, test.match, reason, match, test.rule)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		err  error
+	}{
+		{Rule{}, ErrEmptyRule},
+		{Rule{Pattern: "("}, ErrInvalidPattern},
+		{Rule{Nickname: "kermit", Quiet: &QuietHours{Start: "25:00", End: "07:00"}}, ErrInvalidQuietHours},
+		{Rule{Nickname: "kermit", Quiet: &QuietHours{Start: "22:00", End: "07:00", Timezone: "Europe/Istanbul"}}, nil},
+	}
+
+	for _, test := range tests {
+		if err := test.rule.Validate(); err != test.err {
+			t.Errorf("Expected %v but got %v", test.err, err)
+		}
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	q := &QuietHours{Start: "22:00", End: "07:00"}
+	tests := []struct {
+		clock string
+		quiet bool
+	}{
+		{"21:59", false},
+		{"22:00", true},
+		{"03:00", true},
+		{"07:00", false},
+	}
+
+	for _, test := range tests {
+		now, _ := time.Parse(CLOCK_LAYOUT, test.clock)
+		if quiet := q.Contains(now); quiet != test.quiet {
+			t.Errorf("Expected %t but got %t for %s", test.quiet, quiet, test.clock)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	conf := &common.RedisConf{Server: "localhost", Port: "6379", DB: 3}
+	if env := os.Getenv("REDIS_HOST"); env != "" {
+		conf.Server = env
+	}
+
+	if env := os.Getenv("REDIS_PORT"); env != "" {
+		conf.Port = env
+	}
+
+	redisConn := common.NewRedis(conf)
+	defer redisConn.Close()
+
+	s := NewStore(redisConn)
+	r := &Rule{User: "kermit-test", Nickname: "kermit"}
+	if err := s.Add(r); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+	defer s.Remove(r.ID)
+	defer redisConn.Del(streamKey(r.User))
+
+	n := NewNotifier(s)
+	if err := n.Notify(common.Message{Nickname: "piggy", Channel: "muppets", Body: "kermit!"}); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	notifications, err := s.Notifications(r.User, 10)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if len(notifications) != 1 || notifications[0].Rule != r.ID {
+		t.Errorf("Expected a notification of %s but got %v", r.ID, notifications)
+	}
+}
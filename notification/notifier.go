@@ -0,0 +1,141 @@
+package notification
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/follow"
+	"github.com/canthefason/irc-k/logger"
+	"github.com/canthefason/irc-k/metrics"
+	"github.com/canthefason/irc-k/webhook"
+)
+
+// rules are reloaded from redis in this interval, so rule changes take
+// effect with this delay
+const RULES_REFRESH = 30 * time.Second
+
+var (
+	sent = metrics.NewCounter("irck_notifications_total",
+		"Notifications by reason", "reason")
+)
+
+// Notifier evaluates published messages against rules of all users
+type Notifier struct {
+	Log logger.Logger
+
+	// Hooks delivers notifications to rule webhooks. Webhooks are not
+	// notified when it is nil
+	Hooks *webhook.Dispatcher
+
+	// Follows limits rules to the channels followed by their owners.
+	// All channels are matched when it is nil
+	Follows *follow.Store
+
+	store *Store
+
+	mu    sync.Mutex
+	rules []*Rule
+	// followed channels of rule owners
+	followed map[string]map[string]bool
+	loadedAt time.Time
+}
+
+// NewNotifier creates a notifier with rules of the given store
+func NewNotifier(s *Store) *Notifier {
+	return &Notifier{
+		Log:   logger.New("notification"),
+		store: s,
+	}
+}
+
+// Notify pushes notifications of rules matching message
+func (n *Notifier) Notify(m common.Message) error {
+	rules, followed, err := n.load()
+	if err != nil {
+		return err
+	}
+
+	channel := common.NormalizeChannel(m.Channel)
+	for _, r := range rules {
+		if followed != nil && !followed[r.User][channel] {
+			continue
+		}
+
+		reason, ok := r.Match(m)
+		if !ok {
+			continue
+		}
+
+		notification := &Notification{
+			ID:        common.NewID(8),
+			Rule:      r.ID,
+			User:      r.User,
+			Reason:    reason,
+			Message:   m,
+			Quiet:     r.Quiet != nil && r.Quiet.Contains(time.Now()),
+			CreatedAt: time.Now().UTC(),
+		}
+		if err := n.store.Push(notification); err != nil {
+			return err
+		}
+		sent.Inc(reason)
+
+		if r.Webhook == "" || notification.Quiet || n.Hooks == nil {
+			continue
+		}
+
+		payload, err := json.Marshal(notification)
+		if err != nil {
+			return err
+		}
+
+		n.Hooks.Send(&webhook.Hook{ID: "notification:" + r.ID, URL: r.Webhook, Secret: r.Secret}, payload)
+	}
+
+	return nil
+}
+
+// load returns cached rules and followed channels of their owners, and
+// reloads them when they are stale. Followed channels are nil when
+// follows are not checked
+func (n *Notifier) load() ([]*Rule, map[string]map[string]bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.rules != nil && time.Since(n.loadedAt) < RULES_REFRESH {
+		return n.rules, n.followed, nil
+	}
+
+	rules, err := n.store.List("")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var followed map[string]map[string]bool
+	if n.Follows != nil {
+		followed = make(map[string]map[string]bool)
+		for _, r := range rules {
+			if _, ok := followed[r.User]; ok {
+				continue
+			}
+
+			follows, err := n.Follows.List(r.User)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			followed[r.User] = make(map[string]bool, len(follows))
+			for _, f := range follows {
+				followed[r.User][f.Channel] = true
+			}
+		}
+	}
+
+	n.rules = rules
+	n.followed = followed
+	n.loadedAt = time.Now()
+
+	return rules, followed, nil
+}
@@ -0,0 +1,209 @@
+// Package notification alerts users when messages published by feeders
+// match their rules.
+//
+// A rule matches mentions of a nickname, keywords or a regular
+// expression in the channels followed by its owner, and it can be
+// limited to some of them. Matching messages
+// are pushed to the notification stream of the rule owner, and they are
+// posted to the webhook of the rule when it has one. Notifications in
+// quiet hours are only stored in the stream.
+package notification
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/webhook"
+)
+
+const (
+	REASON_MENTION = "mention"
+	REASON_KEYWORD = "keyword"
+	REASON_PATTERN = "pattern"
+
+	// layout of quiet hour boundaries
+	CLOCK_LAYOUT = "15:04"
+)
+
+var (
+	ErrNotFound          = errors.New("rule not found")
+	ErrEmptyRule         = errors.New("nickname, keywords or pattern must be set")
+	ErrInvalidPattern    = errors.New("invalid pattern")
+	ErrInvalidQuietHours = errors.New("invalid quiet hours")
+)
+
+// QuietHours is a daily interval. Start can be later than end for
+// intervals spanning midnight
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Timezone is an IANA zone name. UTC is used when it is empty
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// Validate checks clocks and timezone of quiet hours
+func (q *QuietHours) Validate() error {
+	if _, err := time.Parse(CLOCK_LAYOUT, q.Start); err != nil {
+		return ErrInvalidQuietHours
+	}
+
+	if _, err := time.Parse(CLOCK_LAYOUT, q.End); err != nil {
+		return ErrInvalidQuietHours
+	}
+
+	if _, err := time.LoadLocation(q.Timezone); err != nil {
+		return ErrInvalidQuietHours
+	}
+
+	return nil
+}
+
+// Contains checks whether t is in quiet hours
+func (q *QuietHours) Contains(t time.Time) bool {
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		return false
+	}
+
+	clock := t.In(loc).Format(CLOCK_LAYOUT)
+	if q.Start <= q.End {
+		return clock >= q.Start && clock < q.End
+	}
+
+	return clock >= q.Start || clock < q.End
+}
+
+// Rule describes messages a user is notified about
+type Rule struct {
+	ID   string `json:"id"`
+	User string `json:"user,omitempty"`
+	// Nickname is matched when it is mentioned as a separate word.
+	// Messages sent by nickname are ignored
+	Nickname string `json:"nickname,omitempty"`
+	// Keywords are matched as case insensitive words or phrases
+	Keywords []string `json:"keywords,omitempty"`
+	// Pattern is a regular expression matched against message bodies
+	Pattern string `json:"pattern,omitempty"`
+	// Channels limits the rule. All channels are matched when it is empty
+	Channels []string    `json:"channels,omitempty"`
+	Quiet    *QuietHours `json:"quietHours,omitempty"`
+	// Webhook is notified with matching messages
+	Webhook   string    `json:"webhook,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	pattern *regexp.Regexp
+}
+
+// Validate checks matchers, quiet hours and webhook of rule
+func (r *Rule) Validate() error {
+	if r.Nickname == "" && len(r.Keywords) == 0 && r.Pattern == "" {
+		return ErrEmptyRule
+	}
+
+	if r.Pattern != "" {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return ErrInvalidPattern
+		}
+		r.pattern = pattern
+	}
+
+	if r.Quiet != nil {
+		if err := r.Quiet.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if r.Webhook != "" && !strings.HasPrefix(r.Webhook, "http://") && !strings.HasPrefix(r.Webhook, "https://") {
+		return webhook.ErrInvalidURL
+	}
+
+	return nil
+}
+
+// Match checks message against rule, and returns the matching reason
+func (r *Rule) Match(m common.Message) (string, bool) {
+	if !r.watches(m.Channel) {
+		return "", false
+	}
+
+	if r.Nickname != "" && strings.EqualFold(r.Nickname, m.Nickname) {
+		return "", false
+	}
+
+	if r.Nickname != "" && common.Mentions(m.Body, r.Nickname) {
+		return REASON_MENTION, true
+	}
+
+	body := strings.ToLower(m.Body)
+	for _, keyword := range r.Keywords {
+		if containsPhrase(body, strings.ToLower(keyword)) {
+			return REASON_KEYWORD, true
+		}
+	}
+
+	if r.Pattern == "" {
+		return "", false
+	}
+
+	if r.pattern == nil {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return "", false
+		}
+		r.pattern = pattern
+	}
+
+	return REASON_PATTERN, r.pattern.MatchString(m.Body)
+}
+
+// containsPhrase checks whether body contains phrase at word boundaries,
+// so that keywords do not match in the middle of words
+func containsPhrase(body, phrase string) bool {
+	phrase = strings.TrimSpace(phrase)
+	if phrase == "" {
+		return false
+	}
+
+	for offset := 0; ; {
+		i := strings.Index(body[offset:], phrase)
+		if i == -1 {
+			return false
+		}
+
+		start, end := offset+i, offset+i+len(phrase)
+		before, _ := utf8.DecodeLastRuneInString(body[:start])
+		after, _ := utf8.DecodeRuneInString(body[end:])
+		if (start == 0 || !isWordRune(before)) && (end == len(body) || !isWordRune(after)) {
+			return true
+		}
+
+		offset = start + 1
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// watches checks channel filter of rule
+func (r *Rule) watches(channel string) bool {
+	if len(r.Channels) == 0 {
+		return true
+	}
+
+	channel = common.NormalizeChannel(channel)
+	for _, c := range r.Channels {
+		if common.NormalizeChannel(c) == channel {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,161 @@
+package notification
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"gopkg.in/redis.v2"
+)
+
+const (
+	// used for storing rules in a hash by their ids
+	RULES_KEY = "notification-rules"
+
+	// prefix of user notification lists and their pubsub channels
+	STREAM_KEY = "notifications"
+
+	// maximum number of stored notifications of a user
+	STREAM_SIZE = 500
+)
+
+// Notification is a message matching a rule
+type Notification struct {
+	ID      string         `json:"id"`
+	Rule    string         `json:"rule"`
+	User    string         `json:"user,omitempty"`
+	Reason  string         `json:"reason"`
+	Message common.Message `json:"message"`
+	// Quiet notifications are matched in quiet hours. They are only
+	// stored in the stream
+	Quiet     bool      `json:"quiet,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Store keeps rules and notification streams in redis
+type Store struct {
+	redisConn *redis.Client
+}
+
+// NewStore creates a store with the given redis connection
+func NewStore(r *redis.Client) *Store {
+	return &Store{redisConn: r}
+}
+
+// Add validates and stores rule. Id is generated
+func (s *Store) Add(r *Rule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	r.ID = common.NewID(8)
+	r.CreatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return s.redisConn.HSet(common.KeyWithPrefix(RULES_KEY), r.ID, string(data)).Err()
+}
+
+// Get returns rule with the given id
+func (s *Store) Get(id string) (*Rule, error) {
+	res := s.redisConn.HGet(common.KeyWithPrefix(RULES_KEY), id)
+	if res.Err() == redis.Nil {
+		return nil, ErrNotFound
+	}
+
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	r := new(Rule)
+	if err := json.Unmarshal([]byte(res.Val()), r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Remove deletes rule with the given id
+func (s *Store) Remove(id string) error {
+	res := s.redisConn.HDel(common.KeyWithPrefix(RULES_KEY), id)
+	if res.Err() != nil {
+		return res.Err()
+	}
+
+	if res.Val() == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// List returns rules of user. All rules are returned for empty user
+func (s *Store) List(user string) ([]*Rule, error) {
+	res := s.redisConn.HVals(common.KeyWithPrefix(RULES_KEY))
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	rules := make([]*Rule, 0)
+	for _, data := range res.Val() {
+		r := new(Rule)
+		if err := json.Unmarshal([]byte(data), r); err != nil {
+			return nil, err
+		}
+
+		if user == "" || r.User == user {
+			rules = append(rules, r)
+		}
+	}
+
+	return rules, nil
+}
+
+// Push adds notification to the stream of its user. Notifications
+// which are not quiet are also published to the stream channel
+func (s *Store) Push(n *Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	key := streamKey(n.User)
+	_, err = s.redisConn.Pipelined(func(p *redis.Pipeline) error {
+		p.LPush(key, string(data))
+		p.LTrim(key, 0, STREAM_SIZE-1)
+		if !n.Quiet {
+			p.Publish(key, string(data))
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Notifications returns the newest notifications of user
+func (s *Store) Notifications(user string, count int) ([]*Notification, error) {
+	res := s.redisConn.LRange(streamKey(user), 0, int64(count-1))
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	notifications := make([]*Notification, 0, len(res.Val()))
+	for _, data := range res.Val() {
+		n := new(Notification)
+		if err := json.Unmarshal([]byte(data), n); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	return notifications, nil
+}
+
+// streamKey returns list key and pubsub channel of user notifications
+func streamKey(user string) string {
+	return common.KeyWithPrefix(STREAM_KEY + ":" + user)
+}
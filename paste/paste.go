@@ -0,0 +1,128 @@
+// Package paste stores long outbound messages, so that a link can be sent
+// to irc channels instead of flooding them.
+package paste
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound    = errors.New("paste not found")
+	ErrDirNotSet   = errors.New("paste directory not set")
+	ErrURLNotSet   = errors.New("paste url not set")
+	ErrInvalidDays = errors.New("invalid retention days")
+)
+
+// Conf holds paste store settings
+type Conf struct {
+	// pastes are stored as files under this directory
+	Dir string
+	// base url of pastes. paste ids are appended to it
+	URL string
+	// Retention is the number of days pastes are kept. Zero keeps them
+	// forever
+	Retention int
+}
+
+// Store saves message bodies and returns their links
+type Store interface {
+	Save(body string) (string, error)
+}
+
+// FileStore stores pastes as text files
+type FileStore struct {
+	Dir       string
+	URL       string
+	Retention int
+}
+
+// NewFileStore creates a file store and its directory
+func NewFileStore(c *Conf) (*FileStore, error) {
+	if c.Dir == "" {
+		return nil, ErrDirNotSet
+	}
+
+	if c.URL == "" {
+		return nil, ErrURLNotSet
+	}
+
+	if c.Retention < 0 {
+		return nil, ErrInvalidDays
+	}
+
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{Dir: c.Dir, URL: strings.TrimRight(c.URL, "/"), Retention: c.Retention}, nil
+}
+
+// Save writes body into a new paste file and returns its link
+func (f *FileStore) Save(body string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	if err := ioutil.WriteFile(f.path(id), []byte(body), 0644); err != nil {
+		return "", err
+	}
+
+	return f.URL + "/" + id, nil
+}
+
+// Get returns body of the paste with given id
+func (f *FileStore) Get(id string) (string, error) {
+	// ids are validated for preventing path traversal
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return "", ErrNotFound
+	}
+
+	data, err := ioutil.ReadFile(f.path(id))
+	if os.IsNotExist(err) {
+		return "", ErrNotFound
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// Prune removes pastes older than retention days
+func (f *FileStore) Prune() error {
+	if f.Retention == 0 {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(f.Dir)
+	if err != nil {
+		return err
+	}
+
+	expiry := time.Now().AddDate(0, 0, -f.Retention)
+	for _, info := range files {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".txt") || !info.ModTime().Before(expiry) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(f.Dir, info.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *FileStore) path(id string) string {
+	return filepath.Join(f.Dir, id+".txt")
+}
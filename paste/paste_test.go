@@ -0,0 +1,92 @@
+package paste
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paste")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := NewFileStore(&Conf{Dir: dir, URL: "http://localhost:3000/pastes/"})
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	link, err := f.Save("hi ho\nkermit the frog here")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if !strings.HasPrefix(link, "http://localhost:3000/pastes/") {
+		t.Errorf("Expected paste url but got %s", link)
+	}
+
+	id := strings.TrimPrefix(link, "http://localhost:3000/pastes/")
+	body, err := f.Get(id)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if body != "hi ho\nkermit the frog here" {
+		t.Errorf("Expected %s but got %s", "hi ho\nkermit the frog here", body)
+	}
+
+	if _, err := f.Get("../paste"); err != ErrNotFound {
+		t.Errorf("Expected %s but got %v", ErrNotFound, err)
+	}
+}
+
+func TestNewFileStore(t *testing.T) {
+	if _, err := NewFileStore(&Conf{URL: "http://localhost"}); err != ErrDirNotSet {
+		t.Errorf("Expected %s but got %v", ErrDirNotSet, err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paste")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := NewFileStore(&Conf{Dir: dir, URL: "http://localhost:3000/pastes", Retention: 7})
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	old, err := f.Save("old")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	recent, err := f.Save("recent")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	oldID := strings.TrimPrefix(old, f.URL+"/")
+	tm := time.Now().AddDate(0, 0, -8)
+	if err := os.Chtimes(f.path(oldID), tm, tm); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if err := f.Prune(); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if _, err := f.Get(oldID); err != ErrNotFound {
+		t.Errorf("Expected %s but got %v", ErrNotFound, err)
+	}
+
+	if _, err := f.Get(strings.TrimPrefix(recent, f.URL+"/")); err != nil {
+		t.Errorf("Expected nil but got %s", err)
+	}
+}
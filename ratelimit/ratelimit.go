@@ -0,0 +1,232 @@
+// Package ratelimit provides token bucket rate limiting of outbound
+// messages. Limits are defined per scope (api user, nickname, channel
+// and network), and a message consumes one token from the bucket of each
+// scope it belongs to.
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	SCOPE_USER     = "user"
+	SCOPE_NICKNAME = "nickname"
+	SCOPE_CHANNEL  = "channel"
+	SCOPE_NETWORK  = "network"
+
+	// rate limited messages are rejected
+	MODE_REJECT = "reject"
+
+	// rate limited messages are queued and delivered later
+	MODE_QUEUE = "queue"
+
+	// full buckets are removed after this many reservations
+	PRUNE_INTERVAL = 1000
+)
+
+var (
+	ErrRateLimited  = errors.New("rate limit exceeded")
+	ErrInvalidMode  = errors.New("invalid rate limit mode")
+	ErrInvalidLimit = errors.New("invalid rate limit")
+)
+
+// Conf holds rate limit settings. Mode and MaxDelay are only read from
+// the root section, and scope sections define Rate and Burst.
+type Conf struct {
+	Mode string
+	// maximum delay of queued messages in seconds. messages which must
+	// wait longer are rejected
+	MaxDelay int
+	// allowed messages per second
+	Rate float64
+	// maximum number of messages sent at once
+	Burst int
+}
+
+// Limit holds rate and burst of a scope
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Key identifies a bucket in a scope
+type Key struct {
+	Scope string
+	Name  string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter holds token buckets of all scopes
+type Limiter struct {
+	// Mode is either reject or queue
+	Mode string
+	// MaxDelay is the maximum waiting duration of queued messages
+	MaxDelay time.Duration
+
+	mu      sync.Mutex
+	limits  map[string]Limit
+	buckets map[Key]*bucket
+	calls   int
+
+	// used for mocking time in tests
+	now func() time.Time
+}
+
+// NewLimiter creates a limiter from rate limit settings keyed by scope.
+// Root settings are stored with empty key. Scopes without settings are
+// not limited.
+func NewLimiter(confs map[string]*Conf) (*Limiter, error) {
+	l := &Limiter{
+		Mode:    MODE_REJECT,
+		limits:  make(map[string]Limit),
+		buckets: make(map[Key]*bucket),
+		now:     time.Now,
+	}
+
+	for scope, c := range confs {
+		if c == nil {
+			continue
+		}
+
+		if scope == "" {
+			switch c.Mode {
+			case "", MODE_REJECT:
+			case MODE_QUEUE:
+				l.Mode = MODE_QUEUE
+			default:
+				return nil, ErrInvalidMode
+			}
+			l.MaxDelay = time.Duration(c.MaxDelay) * time.Second
+			continue
+		}
+
+		if c.Rate <= 0 || c.Burst <= 0 {
+			return nil, ErrInvalidLimit
+		}
+		l.limits[scope] = Limit{Rate: c.Rate, Burst: c.Burst}
+	}
+
+	return l, nil
+}
+
+// Reserve takes a token from each bucket of given keys. When all buckets
+// have tokens, it returns zero duration. Otherwise the returned duration
+// is the required waiting time until all buckets have tokens.
+//
+// In reject mode, tokens are only taken when there is no need to wait,
+// and ErrRateLimited is returned with the waiting time.
+// In queue mode, tokens are taken in advance and the caller must delay
+// sending the message for the returned duration. When the required delay
+// exceeds MaxDelay, nothing is reserved and ErrRateLimited is returned.
+func (l *Limiter) Reserve(keys ...Key) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	var wait time.Duration
+	buckets := make([]*bucket, 0, len(keys))
+	for _, key := range keys {
+		limit, ok := l.limits[key.Scope]
+		if !ok {
+			continue
+		}
+
+		b := l.fill(key, limit, now)
+		if w := waitFor(b.tokens, limit.Rate); w > wait {
+			wait = w
+		}
+		buckets = append(buckets, b)
+	}
+
+	if wait > 0 && (l.Mode == MODE_REJECT || wait > l.MaxDelay) {
+		return wait, ErrRateLimited
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+
+	return wait, nil
+}
+
+// Release gives back the tokens taken from buckets of given keys by
+// Reserve. It is used when reserved message is not sent
+func (l *Limiter) Release(keys ...Key) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for _, key := range keys {
+		limit, ok := l.limits[key.Scope]
+		if !ok {
+			continue
+		}
+
+		b := l.fill(key, limit, now)
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+1)
+	}
+}
+
+// fill returns bucket of the key after adding tokens earned since its
+// last update
+func (l *Limiter) fill(key Key, limit Limit, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+		return b
+	}
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+		b.last = now
+	}
+
+	return b
+}
+
+// prune periodically removes full buckets since they are identical
+// to newly created ones
+func (l *Limiter) prune(now time.Time) {
+	l.calls++
+	if l.calls < PRUNE_INTERVAL {
+		return
+	}
+	l.calls = 0
+
+	for key, b := range l.buckets {
+		limit, ok := l.limits[key.Scope]
+		if !ok {
+			delete(l.buckets, key)
+			continue
+		}
+
+		if b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// waitFor returns the duration until bucket has at least one token
+func waitFor(tokens, rate float64) time.Duration {
+	if tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - tokens) / rate * float64(time.Second))
+}
+
+// RetryAfter converts waiting duration to whole seconds for
+// Retry-After headers
+func RetryAfter(wait time.Duration) int {
+	return int(math.Ceil(wait.Seconds()))
+}
@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func tearUp(t *testing.T, mode string) (*Limiter, *time.Time) {
+	l, err := NewLimiter(map[string]*Conf{
+		"":             {Mode: mode, MaxDelay: 10},
+		SCOPE_NICKNAME: {Rate: 1, Burst: 2},
+		SCOPE_CHANNEL:  {Rate: 0.5, Burst: 1},
+	})
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+
+	return l, &now
+}
+
+func TestNewLimiter(t *testing.T) {
+	if _, err := NewLimiter(map[string]*Conf{"": {Mode: "ignore"}}); err != ErrInvalidMode {
+		t.Errorf("Expected %s but got %s", ErrInvalidMode, err)
+	}
+
+	if _, err := NewLimiter(map[string]*Conf{SCOPE_USER: {Rate: 1}}); err != ErrInvalidLimit {
+		t.Errorf("Expected %s but got %s", ErrInvalidLimit, err)
+	}
+}
+
+func TestReserveReject(t *testing.T) {
+	l, now := tearUp(t, MODE_REJECT)
+	kermit := Key{SCOPE_NICKNAME, "kermit"}
+
+	for i := 0; i < 2; i++ {
+		if wait, err := l.Reserve(kermit); wait != 0 || err != nil {
+			t.Errorf("Expected no wait but got %s, %v", wait, err)
+		}
+	}
+
+	wait, err := l.Reserve(kermit)
+	if err != ErrRateLimited {
+		t.Errorf("Expected %s but got %v", ErrRateLimited, err)
+	}
+
+	if wait != time.Second {
+		t.Errorf("Expected %s but got %s", time.Second, wait)
+	}
+
+	// other nicknames have their own buckets
+	if wait, err := l.Reserve(Key{SCOPE_NICKNAME, "piggy"}); wait != 0 || err != nil {
+		t.Errorf("Expected no wait but got %s, %v", wait, err)
+	}
+
+	*now = now.Add(time.Second)
+	if wait, err := l.Reserve(kermit); wait != 0 || err != nil {
+		t.Errorf("Expected no wait but got %s, %v", wait, err)
+	}
+}
+
+func TestReserveMultipleScopes(t *testing.T) {
+	l, _ := tearUp(t, MODE_REJECT)
+	kermit := Key{SCOPE_NICKNAME, "kermit"}
+	muppets := Key{SCOPE_CHANNEL, "muppets"}
+
+	if _, err := l.Reserve(kermit, muppets); err != nil {
+		t.Errorf("Expected nil but got %s", err)
+	}
+
+	// channel bucket is empty, so nickname token must not be consumed
+	wait, err := l.Reserve(kermit, muppets)
+	if err != ErrRateLimited || wait != 2*time.Second {
+		t.Errorf("Expected 2s wait but got %s, %v", wait, err)
+	}
+
+	if wait, err := l.Reserve(kermit); wait != 0 || err != nil {
+		t.Errorf("Expected no wait but got %s, %v", wait, err)
+	}
+
+	// unknown scopes are not limited
+	for i := 0; i < 5; i++ {
+		if _, err := l.Reserve(Key{SCOPE_USER, "kermit"}); err != nil {
+			t.Errorf("Expected nil but got %s", err)
+		}
+	}
+}
+
+func TestReserveQueue(t *testing.T) {
+	l, _ := tearUp(t, MODE_QUEUE)
+	muppets := Key{SCOPE_CHANNEL, "muppets"}
+
+	expected := []time.Duration{0, 2 * time.Second, 4 * time.Second}
+	for _, e := range expected {
+		wait, err := l.Reserve(muppets)
+		if err != nil || wait != e {
+			t.Errorf("Expected %s wait but got %s, %v", e, wait, err)
+		}
+	}
+
+	// waiting more than max delay is rejected
+	for i := 0; i < 3; i++ {
+		l.Reserve(muppets)
+	}
+	if _, err := l.Reserve(muppets); err != ErrRateLimited {
+		t.Errorf("Expected %s but got %v", ErrRateLimited, err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	l, _ := tearUp(t, MODE_REJECT)
+	kermit := Key{SCOPE_NICKNAME, "kermit"}
+
+	for i := 0; i < 2; i++ {
+		l.Reserve(kermit)
+	}
+
+	l.Release(kermit)
+	if wait, err := l.Reserve(kermit); wait != 0 || err != nil {
+		t.Errorf("Expected no wait but got %s, %v", wait, err)
+	}
+
+	// released tokens do not exceed burst
+	l.Release(kermit, kermit, kermit)
+	for i := 0; i < 2; i++ {
+		l.Reserve(kermit)
+	}
+	if _, err := l.Reserve(kermit); err != ErrRateLimited {
+		t.Errorf("Expected %s but got %v", ErrRateLimited, err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if r := RetryAfter(1500 * time.Millisecond); r != 2 {
+		t.Errorf("Expected %d but got %d", 2, r)
+	}
+}
@@ -0,0 +1,137 @@
+package search
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/formatting"
+)
+
+// date layout of before: and after: filters. RFC3339 times are also
+// accepted
+const DATE_LAYOUT = "2006-01-02"
+
+var (
+	ErrEmptyQuery  = errors.New("empty query")
+	ErrInvalidDate = errors.New("invalid date")
+)
+
+// Query holds parsed search terms and filters. Messages must contain all
+// terms and phrases
+type Query struct {
+	Terms   []string
+	Phrases []string
+	From    string
+	In      string
+	Before  time.Time
+	After   time.Time
+}
+
+// ParseQuery parses queries like
+//
+//	deploy "rolled back" from:kermit in:#ops after:2015-06-01
+//
+// before: and after: filters are exclusive, and dates are in UTC
+func ParseQuery(q string) (*Query, error) {
+	query := new(Query)
+
+	for _, token := range splitQuery(q) {
+		if strings.HasPrefix(token, `"`) {
+			phrase := strings.Join(Tokenize(token), " ")
+			if phrase != "" {
+				query.Phrases = append(query.Phrases, phrase)
+			}
+			continue
+		}
+
+		pair := strings.SplitN(token, ":", 2)
+		if len(pair) == 2 && pair[1] != "" {
+			var err error
+			switch strings.ToLower(pair[0]) {
+			case "from":
+				query.From = strings.ToLower(pair[1])
+				continue
+			case "in":
+				query.In = common.NormalizeChannel(pair[1])
+				continue
+			case "before":
+				query.Before, err = parseDate(pair[1])
+				if err != nil {
+					return nil, err
+				}
+				continue
+			case "after":
+				query.After, err = parseDate(pair[1])
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		query.Terms = append(query.Terms, Tokenize(token)...)
+	}
+
+	if len(query.Terms) == 0 && len(query.Phrases) == 0 && query.From == "" &&
+		query.In == "" && query.Before.IsZero() && query.After.IsZero() {
+		return nil, ErrEmptyQuery
+	}
+
+	return query, nil
+}
+
+// splitQuery splits query into words and quoted phrases. Phrases keep
+// their leading quote
+func splitQuery(q string) []string {
+	tokens := make([]string, 0)
+	for {
+		q = strings.TrimLeftFunc(q, unicode.IsSpace)
+		if q == "" {
+			return tokens
+		}
+
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			if end == -1 {
+				return append(tokens, q)
+			}
+			tokens = append(tokens, q[:end+1])
+			q = q[end+2:]
+			continue
+		}
+
+		end := strings.IndexFunc(q, unicode.IsSpace)
+		if end == -1 {
+			return append(tokens, q)
+		}
+		tokens = append(tokens, q[:end])
+		q = q[end:]
+	}
+}
+
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(DATE_LAYOUT, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, ErrInvalidDate
+	}
+
+	return t.UTC(), nil
+}
+
+// Tokenize lowers text and splits it into words. Formatting codes are
+// removed and words are separated by any rune other than letters and
+// digits
+func Tokenize(text string) []string {
+	isSeparator := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+
+	return strings.FieldsFunc(strings.ToLower(formatting.Strip(text)), isSeparator)
+}
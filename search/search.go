@@ -0,0 +1,356 @@
+// Package search indexes channel messages and finds them with queries.
+//
+// Messages are stored in a redis hash by their ids, and ids are indexed
+// in sorted sets scored by message time: one set for all messages, and
+// a set for each channel, nickname and term. Queries intersect the sets
+// of their filters and terms, and phrases are matched against bodies of
+// the found messages.
+package search
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"gopkg.in/redis.v2"
+)
+
+const (
+	// maximum number of returned messages
+	MAX_LIMIT = 100
+
+	// intersection results are removed after this duration
+	RESULT_TTL = 30 * time.Second
+
+	// used for storing messages in a hash
+	MESSAGES_KEY = "search:messages"
+
+	// used for generating message ids
+	NEXT_ID_KEY = "search:next-id"
+
+	// used for storing ids of messages by their server given msgids
+	MSGIDS_KEY = "search:msgids"
+)
+
+var ErrNotFound = errors.New("message not found")
+
+// counts channel messages after the given score, excluding the messages
+// which are also in the nickname set
+var countScript = `
+local own = 0
+if KEYS[2] then
+	for _, id in ipairs(redis.call("ZRANGEBYSCORE", KEYS[2], ARGV[1], "+inf")) do
+		if redis.call("ZSCORE", KEYS[1], id) then
+			own = own + 1
+		end
+	end
+end
+return redis.call("ZCOUNT", KEYS[1], ARGV[1], "+inf") - own`
+
+// Hit is an indexed message with its channel
+type Hit struct {
+	common.Message
+	Channel string `json:"channel"`
+}
+
+// Index stores and searches messages
+type Index struct {
+	Retention time.Duration
+
+	redisConn *redis.Client
+}
+
+// NewIndex creates an index with the given redis connection
+func NewIndex(r *redis.Client, c *common.SearchConf) *Index {
+	return &Index{
+		Retention: time.Duration(c.Retention) * 24 * time.Hour,
+		redisConn: r,
+	}
+}
+
+// Add stores message and indexes it by its channel, sender and terms
+func (i *Index) Add(m common.Message) error {
+	res := i.redisConn.Incr(common.KeyWithPrefix(NEXT_ID_KEY))
+	if res.Err() != nil {
+		return res.Err()
+	}
+	id := strconv.FormatInt(res.Val(), 10)
+
+	data, err := json.Marshal(Hit{Message: m, Channel: common.NormalizeChannel(m.Channel)})
+	if err != nil {
+		return err
+	}
+
+	member := redis.Z{Score: score(m.Time), Member: id}
+	_, err = i.redisConn.Pipelined(func(p *redis.Pipeline) error {
+		p.HSet(common.KeyWithPrefix(MESSAGES_KEY), id, string(data))
+		if m.MsgID != "" {
+			p.HSet(common.KeyWithPrefix(MSGIDS_KEY), m.MsgID, id)
+		}
+		for _, key := range keysOf(m) {
+			p.ZAdd(key, member)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Search returns the newest messages matching query
+func (i *Index) Search(q *Query, limit int) ([]Hit, error) {
+	if limit <= 0 || limit > MAX_LIMIT {
+		limit = MAX_LIMIT
+	}
+
+	keys := queryKeys(q)
+	key := keys[0]
+	if len(keys) > 1 {
+		key = common.KeyWithPrefix(fmt.Sprintf("search:result:%d", time.Now().UnixNano()))
+		// all sets have the same score for a message, so max keeps it
+		res := i.redisConn.ZInterStore(key, redis.ZStore{Aggregate: "MAX"}, keys...)
+		if res.Err() != nil {
+			return nil, res.Err()
+		}
+		defer i.redisConn.Del(key)
+		i.redisConn.Expire(key, RESULT_TTL)
+	}
+
+	opt := redis.ZRangeByScore{Min: "-inf", Max: "+inf", Count: int64(limit)}
+	if !q.After.IsZero() {
+		opt.Min = "(" + formatScore(q.After)
+	}
+	if !q.Before.IsZero() {
+		opt.Max = "(" + formatScore(q.Before)
+	}
+
+	hits := make([]Hit, 0, limit)
+	for len(hits) < limit {
+		res := i.redisConn.ZRevRangeByScore(key, opt)
+		if res.Err() != nil {
+			return nil, res.Err()
+		}
+
+		ids := res.Val()
+		if len(ids) == 0 {
+			break
+		}
+		opt.Offset += int64(len(ids))
+
+		found, _, err := i.load(ids)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, hit := range found {
+			if matchPhrases(hit.Body, q.Phrases) && len(hits) < limit {
+				hits = append(hits, hit)
+			}
+		}
+	}
+
+	return hits, nil
+}
+
+// Prune removes messages older than retention
+func (i *Index) Prune() error {
+	if i.Retention == 0 {
+		return nil
+	}
+
+	max := formatScore(time.Now().Add(-i.Retention))
+	for {
+		res := i.redisConn.ZRangeByScore(common.KeyWithPrefix("search:all"),
+			redis.ZRangeByScore{Min: "-inf", Max: max, Count: MAX_LIMIT})
+		if res.Err() != nil {
+			return res.Err()
+		}
+
+		ids := res.Val()
+		if len(ids) == 0 {
+			return nil
+		}
+
+		hits, found, err := i.load(ids)
+		if err != nil {
+			return err
+		}
+
+		_, err = i.redisConn.Pipelined(func(p *redis.Pipeline) error {
+			for j, hit := range hits {
+				for _, key := range keysOf(hit.Message) {
+					p.ZRem(key, found[j])
+				}
+				if hit.MsgID != "" {
+					p.HDel(common.KeyWithPrefix(MSGIDS_KEY), hit.MsgID)
+				}
+			}
+			p.ZRem(common.KeyWithPrefix("search:all"), ids...)
+			p.HDel(common.KeyWithPrefix(MESSAGES_KEY), ids...)
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// load returns stored messages of ids in the same order, and ids of
+// the found messages
+func (i *Index) load(ids []string) ([]Hit, []string, error) {
+	res := i.redisConn.HMGet(common.KeyWithPrefix(MESSAGES_KEY), ids...)
+	if res.Err() != nil {
+		return nil, nil, res.Err()
+	}
+
+	hits := make([]Hit, 0, len(ids))
+	found := make([]string, 0, len(ids))
+	for j, v := range res.Val() {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		var hit Hit
+		if err := json.Unmarshal([]byte(data), &hit); err != nil {
+			return nil, nil, err
+		}
+		hits = append(hits, hit)
+		found = append(found, ids[j])
+	}
+
+	return hits, found, nil
+}
+
+// keysOf returns sorted set keys of message
+func keysOf(m common.Message) []string {
+	keys := []string{
+		common.KeyWithPrefix("search:all"),
+		channelKey(m.Channel),
+		nickKey(m.Nickname),
+	}
+
+	seen := make(map[string]bool)
+	for _, term := range Tokenize(m.Body) {
+		if !seen[term] {
+			seen[term] = true
+			keys = append(keys, termKey(term))
+		}
+	}
+
+	return keys
+}
+
+// queryKeys returns sorted set keys which are intersected for query.
+// Phrase terms are also intersected for narrowing down the candidates
+func queryKeys(q *Query) []string {
+	keys := make([]string, 0)
+	if q.In != "" {
+		keys = append(keys, channelKey(q.In))
+	}
+	if q.From != "" {
+		keys = append(keys, nickKey(q.From))
+	}
+
+	terms := append([]string{}, q.Terms...)
+	for _, phrase := range q.Phrases {
+		terms = append(terms, strings.Fields(phrase)...)
+	}
+
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			keys = append(keys, termKey(term))
+		}
+	}
+
+	if len(keys) == 0 {
+		keys = append(keys, common.KeyWithPrefix("search:all"))
+	}
+
+	return keys
+}
+
+// matchPhrases checks whether body contains all phrases
+func matchPhrases(body string, phrases []string) bool {
+	text := " " + strings.Join(Tokenize(body), " ") + " "
+	for _, phrase := range phrases {
+		if !strings.Contains(text, " "+phrase+" ") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Count returns the number of indexed messages of channel sent after t.
+// Messages of nickname are not counted when it is not empty
+func (i *Index) Count(channel, nickname string, t time.Time) (int64, error) {
+	keys := []string{channelKey(channel)}
+	if nickname != "" {
+		keys = append(keys, nickKey(nickname))
+	}
+	min := "(" + strconv.FormatFloat(score(t), 'f', 0, 64)
+
+	res := i.redisConn.Eval(countScript, keys, []string{min})
+	if res.Err() != nil {
+		return 0, res.Err()
+	}
+
+	count, ok := res.Val().(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count: %v", res.Val())
+	}
+
+	return count, nil
+}
+
+// Time returns the time of indexed message with the given server msgid
+func (i *Index) Time(msgID string) (time.Time, error) {
+	res := i.redisConn.HGet(common.KeyWithPrefix(MSGIDS_KEY), msgID)
+	if res.Err() == redis.Nil {
+		return time.Time{}, ErrNotFound
+	}
+
+	if res.Err() != nil {
+		return time.Time{}, res.Err()
+	}
+
+	hits, _, err := i.load([]string{res.Val()})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if len(hits) == 0 {
+		return time.Time{}, ErrNotFound
+	}
+
+	return hits[0].Time, nil
+}
+
+func channelKey(channel string) string {
+	return common.KeyWithPrefix("search:channel:" + common.NormalizeChannel(channel))
+}
+
+func nickKey(nickname string) string {
+	return common.KeyWithPrefix("search:nick:" + strings.ToLower(nickname))
+}
+
+func termKey(term string) string {
+	return common.KeyWithPrefix("search:term:" + term)
+}
+
+// score converts time into sorted set score in milliseconds
+func score(t time.Time) float64 {
+	return float64(t.UnixNano() / int64(time.Millisecond))
+}
+
+func formatScore(t time.Time) string {
+	return strconv.FormatFloat(score(t), 'f', -1, 64)
+}
@@ -0,0 +1,137 @@
+package search
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery(`Deploy "rolled  BACK" from:Kermit in:#Ops after:2015-06-01 before:2015-06-08T12:00:00Z`)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	expected := &Query{
+		Terms:   []string{"deploy"},
+		Phrases: []string{"rolled back"},
+		From:    "kermit",
+		In:      "ops",
+		After:   time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC),
+		Before:  time.Date(2015, 6, 8, 12, 0, 0, 0, time.UTC),
+	}
+
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, q)
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	if _, err := ParseQuery("   "); err != ErrEmptyQuery {
+		t.Errorf("Expected %s but got %v", ErrEmptyQuery, err)
+	}
+
+	if _, err := ParseQuery("deploy before:yesterday"); err != ErrInvalidDate {
+		t.Errorf("Expected %s but got %v", ErrInvalidDate, err)
+	}
+
+	// unknown filters are searched as terms
+	q, err := ParseQuery("http://example.com")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if !reflect.DeepEqual(q.Terms, []string{"http", "example", "com"}) {
+		t.Errorf("Expected %v but got %v", []string{"http", "example", "com"}, q.Terms)
+	}
+}
+
+func TestMatchPhrases(t *testing.T) {
+	body := "The deploy was \x02rolled back\x02, sorry!"
+	if !matchPhrases(body, []string{"rolled back", "sorry"}) {
+		t.Errorf("Expected %s to match", body)
+	}
+
+	if matchPhrases(body, []string{"deploy rolled"}) {
+		t.Errorf("Expected %s not to match", body)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	conf := &common.RedisConf{Server: "localhost", Port: "6379", DB: 3}
+	if env := os.Getenv("REDIS_HOST"); env != "" {
+		conf.Server = env
+	}
+
+	if env := os.Getenv("REDIS_PORT"); env != "" {
+		conf.Port = env
+	}
+
+	redisConn := common.NewRedis(conf)
+	defer redisConn.Close()
+
+	index := NewIndex(redisConn, &common.SearchConf{Retention: 1})
+	now := time.Now()
+	messages := []common.Message{
+		{Nickname: "kermit", Channel: "ops", Body: "starting the deploy", Time: now.Add(-time.Hour)},
+		{Nickname: "piggy", Channel: "ops", Body: "deploy rolled back", Time: now.Add(-30 * time.Minute), MsgID: "msg-1"},
+		{Nickname: "kermit", Channel: "muppets", Body: "deploy party", Time: now},
+	}
+
+	for _, m := range messages {
+		if err := index.Add(m); err != nil {
+			t.Fatalf("Expected nil but got %s", err)
+		}
+	}
+
+	q, _ := ParseQuery("deploy in:ops")
+	hits, err := index.Search(q, 10)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if len(hits) != 2 || hits[0].Nickname != "piggy" {
+		t.Errorf("Expected %d hits but got %v", 2, hits)
+	}
+
+	q, _ = ParseQuery(`"rolled back"`)
+	hits, err = index.Search(q, 10)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if len(hits) != 1 || hits[0].Channel != "ops" {
+		t.Errorf("Expected %d hit but got %v", 1, hits)
+	}
+
+	msgTime, err := index.Time("msg-1")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if !msgTime.Equal(messages[1].Time) {
+		t.Errorf("Expected %s but got %s", messages[1].Time, msgTime)
+	}
+
+	count, err := index.Count("ops", "kermit", now.Add(-2*time.Hour))
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if count != 1 {
+		t.Errorf("Expected %d but got %d", 1, count)
+	}
+
+	index.Retention = time.Nanosecond
+	if err := index.Prune(); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+	redisConn.Del(common.KeyWithPrefix(NEXT_ID_KEY))
+
+	if _, err := index.Time("msg-1"); err != ErrNotFound {
+		t.Errorf("Expected %s but got %v", ErrNotFound, err)
+	}
+}
@@ -0,0 +1,243 @@
+// Package unread keeps read markers of users, and counts their unread
+// messages and mentions in each channel.
+//
+// Users become readers of a channel when they mark it read for the
+// first time. Counters of readers are increased as feeders publish
+// messages, and they are reset when channel is marked read. Feeders
+// index messages before counting them, so that the messages counted
+// by feeders while a channel is marked read are recounted from index.
+package unread
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/search"
+	"gopkg.in/redis.v2"
+)
+
+const (
+	// hash of channel -> read marker of a user
+	MARKERS_KEY = "read-markers"
+
+	// hash of channel -> unread message count of a user
+	UNREAD_KEY = "unread"
+
+	// hash of channel -> unread mention count of a user
+	MENTIONS_KEY = "unread-mentions"
+
+	// hash of user -> nickname of channel readers
+	READERS_KEY = "readers"
+
+	// maximum number of attempts for marking channel read while its
+	// counters are changing
+	MAX_MARK_ATTEMPTS = 5
+)
+
+var ErrConflict = errors.New("unread counts are changing")
+
+// Marker is the last read message of a channel
+type Marker struct {
+	MsgID string    `json:"msgid,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Count holds unread counters and read marker of a channel
+type Count struct {
+	Channel  string  `json:"channel"`
+	Unread   int64   `json:"unread"`
+	Mentions int64   `json:"mentions"`
+	Marker   *Marker `json:"marker,omitempty"`
+}
+
+// Store keeps markers and counters in redis
+type Store struct {
+	// Index is used for counting messages sent after read markers.
+	// Unread count is reset when it is nil
+	Index *search.Index
+
+	redisConn *redis.Client
+}
+
+// NewStore creates a store with the given redis connection
+func NewStore(r *redis.Client) *Store {
+	return &Store{redisConn: r}
+}
+
+// MarkRead sets read marker of user in channel. Mentions of nickname
+// are counted separately. Previous nickname is kept when it is empty.
+// Marker time is resolved from index when its msgid is set
+func (s *Store) MarkRead(user, nickname, channel string, marker *Marker) error {
+	channel = common.NormalizeChannel(channel)
+	if channel == "" {
+		return common.ErrChannelNotSet
+	}
+
+	if marker.MsgID != "" && s.Index != nil {
+		t, err := s.Index.Time(marker.MsgID)
+		if err != nil {
+			return err
+		}
+		marker.Time = t
+	}
+
+	if marker.Time.IsZero() {
+		marker.Time = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < MAX_MARK_ATTEMPTS; i++ {
+		err := s.markRead(user, nickname, channel, marker.Time, string(data))
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+
+	return ErrConflict
+}
+
+// markRead recounts unread messages of channel, and resets counters of
+// user in a transaction. The transaction fails when feeders change the
+// counters in the meantime
+func (s *Store) markRead(user, nickname, channel string, t time.Time, marker string) error {
+	multi := s.redisConn.Multi()
+	defer multi.Close()
+
+	if err := multi.Watch(userKey(UNREAD_KEY, user)).Err(); err != nil {
+		return err
+	}
+
+	var unread int64
+	if s.Index != nil {
+		sender := nickname
+		if sender == "" {
+			res := multi.HGet(readersKey(channel), user)
+			if res.Err() != nil && res.Err() != redis.Nil {
+				return res.Err()
+			}
+			sender = res.Val()
+		}
+
+		count, err := s.Index.Count(channel, sender, t)
+		if err != nil {
+			return err
+		}
+		unread = count
+	}
+
+	_, err := multi.Exec(func() error {
+		multi.HSet(userKey(MARKERS_KEY, user), channel, marker)
+		multi.HSet(userKey(UNREAD_KEY, user), channel, strconv.FormatInt(unread, 10))
+		multi.HDel(userKey(MENTIONS_KEY, user), channel)
+		if nickname != "" {
+			multi.HSet(readersKey(channel), user, nickname)
+		} else {
+			multi.HSetNX(readersKey(channel), user, "")
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Remove stops counting messages of channel for user
+func (s *Store) Remove(user, channel string) error {
+	channel = common.NormalizeChannel(channel)
+	_, err := s.redisConn.Pipelined(func(p *redis.Pipeline) error {
+		p.HDel(userKey(MARKERS_KEY, user), channel)
+		p.HDel(userKey(UNREAD_KEY, user), channel)
+		p.HDel(userKey(MENTIONS_KEY, user), channel)
+		p.HDel(readersKey(channel), user)
+
+		return nil
+	})
+
+	return err
+}
+
+// Add increases counters of channel readers for a published message.
+// Messages sent by readers are not counted for themselves
+func (s *Store) Add(m common.Message) error {
+	channel := common.NormalizeChannel(m.Channel)
+	res := s.redisConn.HGetAllMap(readersKey(channel))
+	if res.Err() != nil {
+		return res.Err()
+	}
+
+	if len(res.Val()) == 0 {
+		return nil
+	}
+
+	_, err := s.redisConn.Pipelined(func(p *redis.Pipeline) error {
+		for user, nickname := range res.Val() {
+			if nickname != "" && strings.EqualFold(nickname, m.Nickname) {
+				continue
+			}
+
+			p.HIncrBy(userKey(UNREAD_KEY, user), channel, 1)
+			if nickname != "" && common.Mentions(m.Body, nickname) {
+				p.HIncrBy(userKey(MENTIONS_KEY, user), channel, 1)
+			}
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Counts returns counters of all channels read by user
+func (s *Store) Counts(user string) ([]*Count, error) {
+	var markers, unread, mentions *redis.StringStringMapCmd
+	_, err := s.redisConn.Pipelined(func(p *redis.Pipeline) error {
+		markers = p.HGetAllMap(userKey(MARKERS_KEY, user))
+		unread = p.HGetAllMap(userKey(UNREAD_KEY, user))
+		mentions = p.HGetAllMap(userKey(MENTIONS_KEY, user))
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]*Count, 0, len(markers.Val()))
+	for channel, data := range markers.Val() {
+		marker := new(Marker)
+		if err := json.Unmarshal([]byte(data), marker); err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, &Count{
+			Channel:  channel,
+			Unread:   parseCount(unread.Val()[channel]),
+			Mentions: parseCount(mentions.Val()[channel]),
+			Marker:   marker,
+		})
+	}
+
+	return counts, nil
+}
+
+// parseCount returns zero for missing counters
+func parseCount(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+
+	return n
+}
+
+func userKey(key, user string) string {
+	return common.KeyWithPrefix(key + ":" + user)
+}
+
+func readersKey(channel string) string {
+	return common.KeyWithPrefix(READERS_KEY + ":" + channel)
+}
@@ -0,0 +1,65 @@
+package unread
+
+import (
+	"os"
+	"testing"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+func TestCounts(t *testing.T) {
+	conf := &common.RedisConf{Server: "localhost", Port: "6379", DB: 3}
+	if env := os.Getenv("REDIS_HOST"); env != "" {
+		conf.Server = env
+	}
+
+	if env := os.Getenv("REDIS_PORT"); env != "" {
+		conf.Port = env
+	}
+
+	redisConn := common.NewRedis(conf)
+	defer redisConn.Close()
+
+	user := "kermit-test"
+	defer redisConn.Del(userKey(MARKERS_KEY, user), userKey(UNREAD_KEY, user),
+		userKey(MENTIONS_KEY, user), readersKey("muppets"))
+
+	s := NewStore(redisConn)
+	if err := s.MarkRead(user, "kermit", "#Muppets", &Marker{}); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	messages := []common.Message{
+		{Nickname: "piggy", Channel: "muppets", Body: "kermit: lunch?"},
+		{Nickname: "fozzie", Channel: "muppets", Body: "wocka wocka"},
+		{Nickname: "kermit", Channel: "muppets", Body: "sure"},
+		{Nickname: "fozzie", Channel: "kitchen", Body: "kermit?"},
+	}
+	for _, m := range messages {
+		if err := s.Add(m); err != nil {
+			t.Fatalf("Expected nil but got %s", err)
+		}
+	}
+
+	counts, err := s.Counts(user)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if len(counts) != 1 {
+		t.Fatalf("Expected %d but got %d", 1, len(counts))
+	}
+
+	if counts[0].Unread != 2 || counts[0].Mentions != 1 {
+		t.Errorf("Expected 2 unread and 1 mention but got %d and %d", counts[0].Unread, counts[0].Mentions)
+	}
+
+	if err := s.MarkRead(user, "", "muppets", &Marker{}); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	counts, _ = s.Counts(user)
+	if counts[0].Unread != 0 || counts[0].Mentions != 0 {
+		t.Errorf("Expected no unread messages but got %d and %d", counts[0].Unread, counts[0].Mentions)
+	}
+}
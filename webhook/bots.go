@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"strings"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/r2dq"
+	"gopkg.in/redis.v2"
+)
+
+const (
+	// used for storing the feeder bot which joined each channel in a hash
+	CHANNEL_BOTS_KEY = "outbox:channels"
+
+	// used as key prefix of running marks of bot names
+	RUNNING_KEY = "outbox:running"
+
+	// running marks expire unless feeders refresh them in this duration
+	RUNNING_TTL = 2 * time.Minute
+)
+
+// removes channel owner only when it is still the given bot
+var releaseScript = `
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return 0`
+
+// Bots tracks bot names consumed by running feeders, and the channels
+// joined by each feeder bot. Feeder bots are named as "<bot>-<n>", and
+// each of them has its own outbox besides the shared outbox of bot, so
+// that messages are sent by the bot which joined their channel
+type Bots struct {
+	redisConn *redis.Client
+}
+
+// NewBots creates bots with the given redis connection
+func NewBots(r *redis.Client) *Bots {
+	return &Bots{redisConn: r}
+}
+
+// Heartbeat marks bot as consumed by a running feeder
+func (b *Bots) Heartbeat(bot string) error {
+	return b.redisConn.SetEx(runningKey(bot), RUNNING_TTL, "1").Err()
+}
+
+// IsRunning checks whether a running feeder consumes outbox of bot
+func (b *Bots) IsRunning(bot string) (bool, error) {
+	res := b.redisConn.Exists(runningKey(bot))
+
+	return res.Val(), res.Err()
+}
+
+// Join records feeder bot as the sender of channel messages
+func (b *Bots) Join(channel, feederBot string) error {
+	return b.redisConn.HSet(common.KeyWithPrefix(CHANNEL_BOTS_KEY), common.NormalizeChannel(channel), feederBot).Err()
+}
+
+// Part removes feeder bot from channel, unless another bot joined it
+func (b *Bots) Part(channel, feederBot string) error {
+	keys := []string{common.KeyWithPrefix(CHANNEL_BOTS_KEY)}
+	args := []string{common.NormalizeChannel(channel), feederBot}
+
+	return b.redisConn.Eval(releaseScript, keys, args).Err()
+}
+
+// Route returns the outbox name of channel messages posted via bot.
+// It is the feeder bot which joined channel, or bot itself when none
+// of its feeders joined channel
+func (b *Bots) Route(bot, channel string) (string, error) {
+	res := b.redisConn.HGet(common.KeyWithPrefix(CHANNEL_BOTS_KEY), common.NormalizeChannel(channel))
+	if res.Err() == redis.Nil {
+		return bot, nil
+	}
+
+	if res.Err() != nil {
+		return "", res.Err()
+	}
+
+	if !strings.HasPrefix(res.Val(), bot+"-") {
+		return bot, nil
+	}
+
+	return res.Val(), nil
+}
+
+// Move moves waiting messages from the outbox of from to the outbox of
+// to. It is used for handing messages of stopped feeder bots over
+func (b *Bots) Move(from, to string) error {
+	src, dst := waitingKey(from), waitingKey(to)
+	for {
+		res := b.redisConn.RPopLPush(src, dst)
+		if res.Err() == redis.Nil {
+			return nil
+		}
+
+		if res.Err() != nil {
+			return res.Err()
+		}
+	}
+}
+
+func runningKey(bot string) string {
+	return common.KeyWithPrefix(RUNNING_KEY + ":" + bot)
+}
+
+// waitingKey returns the queue key of waiting outbox messages of bot
+func waitingKey(bot string) string {
+	return common.KeyWithPrefix(OUTBOX_KEY+":"+bot) + ":" + r2dq.WAITING_QUEUE
+}
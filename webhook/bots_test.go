@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"os"
+	"testing"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+func TestBots(t *testing.T) {
+	conf := &common.RedisConf{Server: "localhost", Port: "6379", DB: 3}
+	if env := os.Getenv("REDIS_HOST"); env != "" {
+		conf.Server = env
+	}
+
+	if env := os.Getenv("REDIS_PORT"); env != "" {
+		conf.Port = env
+	}
+
+	redisConn := common.NewRedis(conf)
+	defer redisConn.Close()
+	defer redisConn.Del(common.KeyWithPrefix(CHANNEL_BOTS_KEY), runningKey("muppet"))
+
+	b := NewBots(redisConn)
+	if running, err := b.IsRunning("muppet"); err != nil || running {
+		t.Errorf("Expected bot not to be running but got %t, %v", running, err)
+	}
+
+	if err := b.Heartbeat("muppet"); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if running, err := b.IsRunning("muppet"); err != nil || !running {
+		t.Errorf("Expected bot to be running but got %t, %v", running, err)
+	}
+
+	if err := b.Join("#Muppets", "muppet-2"); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	tests := []struct {
+		bot      string
+		expected string
+	}{
+		{"muppet", "muppet-2"},
+		{"swedish", "swedish"},
+	}
+
+	for _, test := range tests {
+		bot, err := b.Route(test.bot, "muppets")
+		if err != nil {
+			t.Fatalf("Expected nil but got %s", err)
+		}
+
+		if bot != test.expected {
+			t.Errorf("Expected %s but got %s", test.expected, bot)
+		}
+	}
+
+	// channel is joined by another bot afterwards
+	if err := b.Join("muppets", "muppet-3"); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if err := b.Part("muppets", "muppet-2"); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if bot, _ := b.Route("muppet", "muppets"); bot != "muppet-3" {
+		t.Errorf("Expected %s but got %s", "muppet-3", bot)
+	}
+}
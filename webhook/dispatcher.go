@@ -0,0 +1,265 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/logger"
+	"github.com/canthefason/irc-k/metrics"
+)
+
+const (
+	// delivery attempts of a payload, including the first one
+	MAX_ATTEMPTS = 5
+
+	// waiting duration before the first retry. it is doubled after
+	// each attempt
+	BACKOFF = time.Second
+
+	// maximum number of concurrent deliveries
+	MAX_CONCURRENCY = 16
+
+	DELIVERY_TIMEOUT = 10 * time.Second
+
+	// hooks of channels are cached this long, so registered and removed
+	// hooks take effect in at most this duration
+	HOOK_CACHE_TTL = 30 * time.Second
+)
+
+var (
+	deliveries = metrics.NewCounter("irck_webhook_deliveries_total",
+		"Webhook deliveries by result", "result")
+)
+
+// Payload is posted to hook urls
+type Payload struct {
+	Hook    string         `json:"hook"`
+	Channel string         `json:"channel"`
+	Message common.Message `json:"message"`
+}
+
+// channelHooks holds cached hooks of a channel
+type channelHooks struct {
+	hooks   []*Hook
+	expires time.Time
+}
+
+// Dispatcher delivers messages to matching hooks
+type Dispatcher struct {
+	// Client does not connect to private addresses by default
+	Client      *http.Client
+	Log         logger.Logger
+	MaxAttempts int
+	Backoff     time.Duration
+
+	store *Store
+	// limits concurrent delivery attempts
+	slots chan struct{}
+
+	mu    sync.Mutex
+	cache map[string]*channelHooks
+
+	// called when delivery of a payload ends, used in tests
+	done func(attempts int, err error)
+}
+
+// NewDispatcher creates a dispatcher with hooks of the given store
+func NewDispatcher(s *Store) *Dispatcher {
+	return &Dispatcher{
+		Client:      NewClient(DELIVERY_TIMEOUT),
+		Log:         logger.New("webhook"),
+		MaxAttempts: MAX_ATTEMPTS,
+		Backoff:     BACKOFF,
+		store:       s,
+		slots:       make(chan struct{}, MAX_CONCURRENCY),
+		cache:       make(map[string]*channelHooks),
+	}
+}
+
+// NewClient creates an http client which cannot connect to private
+// addresses. It is used for posting to urls given by users
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: publicOnly}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{Dial: dialer.Dial},
+	}
+}
+
+// publicOnly prevents connections to private addresses, since resolved
+// addresses of hook urls can change after registration
+func publicOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || isPrivate(ip) {
+		return ErrPrivateAddress
+	}
+
+	return nil
+}
+
+// Dispatch delivers message to matching hooks of its channel in
+// background
+func (d *Dispatcher) Dispatch(m common.Message) error {
+	hooks, err := d.hooksOf(m.Channel)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hooks {
+		if !h.Match(m) {
+			continue
+		}
+
+		payload, err := json.Marshal(Payload{Hook: h.ID, Channel: m.Channel, Message: m})
+		if err != nil {
+			return err
+		}
+
+		d.Send(h, payload)
+	}
+
+	return nil
+}
+
+// hooksOf returns cached hooks of channel. Hooks are read from the
+// store when they are not cached or the cache is expired
+func (d *Dispatcher) hooksOf(channel string) ([]*Hook, error) {
+	channel = common.NormalizeChannel(channel)
+
+	d.mu.Lock()
+	ch, ok := d.cache[channel]
+	d.mu.Unlock()
+
+	if ok && time.Now().Before(ch.expires) {
+		return ch.hooks, nil
+	}
+
+	hooks, err := d.store.ForChannel(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.cache[channel] = &channelHooks{hooks: hooks, expires: time.Now().Add(HOOK_CACHE_TTL)}
+	d.mu.Unlock()
+
+	return hooks, nil
+}
+
+// Send delivers payload to the url of hook in background
+func (d *Dispatcher) Send(h *Hook, payload []byte) {
+	// slow endpoints must not block message handling, so payloads
+	// are dead lettered when all slots are busy
+	select {
+	case d.slots <- struct{}{}:
+		go d.attempt(h, payload, 1, d.Backoff)
+	default:
+		d.finish(h, payload, 0, ErrBusy)
+	}
+}
+
+// attempt posts payload while holding a slot. The slot is released
+// before waiting for the next attempt, so that retries of slow
+// endpoints do not block other deliveries. Backoff is doubled after
+// each attempt
+func (d *Dispatcher) attempt(h *Hook, payload []byte, attempt int, backoff time.Duration) {
+	err := d.send(h, payload)
+	<-d.slots
+
+	if err == nil || attempt >= d.MaxAttempts {
+		d.finish(h, payload, attempt, err)
+		return
+	}
+
+	time.AfterFunc(backoff, func() {
+		d.slots <- struct{}{}
+		d.attempt(h, payload, attempt+1, backoff*2)
+	})
+}
+
+// finish records result of a delivery. Failed deliveries are stored as
+// dead letters
+func (d *Dispatcher) finish(h *Hook, payload []byte, attempts int, err error) {
+	if err == nil {
+		deliveries.Inc("success")
+	} else {
+		d.fail(h, payload, attempts, err)
+	}
+
+	if d.done != nil {
+		d.done(attempts, err)
+	}
+}
+
+// fail stores payload as dead letter
+func (d *Dispatcher) fail(h *Hook, payload []byte, attempts int, err error) {
+	deliveries.Inc("failure")
+	log := d.Log.New("hook", h.ID)
+	log.Warn("Could not deliver payload after %d attempts: %s", attempts, err)
+
+	letter := &DeadLetter{
+		ID:       common.NewID(8),
+		Hook:     h.ID,
+		URL:      h.URL,
+		Payload:  payload,
+		Error:    err.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now().UTC(),
+	}
+	if err := d.store.AddDeadLetter(letter); err != nil {
+		log.Error("Could not store dead letter: %s", err)
+	}
+}
+
+// Replay removes dead letter with the given id and delivers its payload
+// again to its hook
+func (d *Dispatcher) Replay(id string) error {
+	letter, err := d.store.TakeDeadLetter(id)
+	if err != nil {
+		return err
+	}
+
+	h, err := d.store.Get(letter.Hook)
+	if err != nil {
+		return err
+	}
+
+	d.Send(h, letter.Payload)
+
+	return nil
+}
+
+// send makes a single delivery attempt
+func (d *Dispatcher) send(h *Hook, payload []byte) error {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Irck-Hook", h.ID)
+	req.Header.Set("X-Irck-Signature", "sha256="+Sign(h.Secret, payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+// Package webhook delivers channel messages to http endpoints registered
+// by users. Hooks are registered per channel, and they can be filtered
+// by a body pattern, a sender nickname or a mentioned nickname.
+//
+// Payloads are signed with the secret of their hook, and the signature
+// is sent in X-Irck-Signature header as "sha256=<hex hmac>". Failed
+// deliveries are retried with exponential backoff, and they are pushed
+// into a dead-letter list when all attempts fail.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+var (
+	ErrNotFound       = errors.New("webhook not found")
+	ErrInvalidURL     = errors.New("invalid webhook url")
+	ErrInvalidPattern = errors.New("invalid pattern")
+	ErrBusy           = errors.New("dispatcher busy")
+	ErrPrivateAddress = errors.New("private webhook address")
+)
+
+// Hook is an http endpoint which receives messages of a channel
+type Hook struct {
+	ID      string `json:"id"`
+	User    string `json:"user,omitempty"`
+	Channel string `json:"channel"`
+	URL     string `json:"url"`
+	// Secret is used for signing payloads
+	Secret string `json:"secret"`
+	// Pattern is a regular expression matched against message bodies
+	Pattern string `json:"pattern,omitempty"`
+	// Nickname filters messages by their senders
+	Nickname string `json:"nickname,omitempty"`
+	// Mention filters messages mentioning the given nickname
+	Mention   string    `json:"mention,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	pattern *regexp.Regexp
+}
+
+// Validate checks channel, url and pattern of hook. Urls of loopback
+// and private addresses are not allowed
+func (h *Hook) Validate() error {
+	if h.Channel == "" {
+		return common.ErrChannelNotSet
+	}
+
+	if err := ValidateURL(h.URL); err != nil {
+		return err
+	}
+
+	if h.Pattern == "" {
+		return nil
+	}
+
+	pattern, err := regexp.Compile(h.Pattern)
+	if err != nil {
+		return ErrInvalidPattern
+	}
+	h.pattern = pattern
+
+	return nil
+}
+
+// ValidateURL checks whether raw is an http url of a public address.
+// Urls with host names are checked again while connecting by the
+// clients of NewClient, since their addresses can change
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidURL
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateAddress
+	}
+
+	if ip := net.ParseIP(host); ip != nil && isPrivate(ip) {
+		return ErrPrivateAddress
+	}
+
+	return nil
+}
+
+// Match checks whether message passes filters of hook
+func (h *Hook) Match(m common.Message) bool {
+	if common.NormalizeChannel(h.Channel) != common.NormalizeChannel(m.Channel) {
+		return false
+	}
+
+	if h.Nickname != "" && !strings.EqualFold(h.Nickname, m.Nickname) {
+		return false
+	}
+
+	if h.Mention != "" && !common.Mentions(m.Body, h.Mention) {
+		return false
+	}
+
+	if h.Pattern == "" {
+		return true
+	}
+
+	// hooks are shared by deliveries, so pattern is only compiled here
+	// for hooks which are not validated or decoded
+	pattern := h.pattern
+	if pattern == nil {
+		var err error
+		if pattern, err = regexp.Compile(h.Pattern); err != nil {
+			return false
+		}
+	}
+
+	return pattern.MatchString(m.Body)
+}
+
+// isPrivate checks whether ip is a loopback, private, link local or
+// unspecified address
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// Sign returns the hex encoded HMAC-SHA256 signature of payload
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
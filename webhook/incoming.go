@@ -0,0 +1,163 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/irc-k/formatting"
+)
+
+const (
+	// TEMPLATE_TEXT renders text field of json payloads
+	TEMPLATE_TEXT = "text"
+
+	// TEMPLATE_GITHUB renders push, pull request and issue events
+	TEMPLATE_GITHUB = "github"
+
+	// TEMPLATE_ALERTMANAGER renders alert notifications
+	TEMPLATE_ALERTMANAGER = "alertmanager"
+
+	// maximum accepted payload size in bytes
+	MAX_PAYLOAD_SIZE = 1 << 20
+)
+
+var (
+	ErrInvalidTemplate = errors.New("invalid template")
+	ErrInvalidPayload  = errors.New("invalid payload")
+	ErrPayloadTooLarge = errors.New("payload too large")
+	ErrEmptyMessage    = errors.New("empty message")
+)
+
+// templates are built-in templates of json payloads
+var templates = map[string]string{
+	TEMPLATE_TEXT: "{{.text}}",
+	TEMPLATE_GITHUB: "{{with .repository}}[{{.full_name}}] {{end}}{{with .sender}}{{.login}} {{end}}" +
+		"{{if .commits}}pushed {{len .commits}} commit(s) to {{branch .ref}}" +
+		"{{range .commits}}\n{{short .id}} {{firstLine .message}}{{end}}" +
+		"{{else if .pull_request}}{{.action}} pull request #{{.pull_request.number}}: " +
+		"{{.pull_request.title}} {{.pull_request.html_url}}" +
+		"{{else if .issue}}{{.action}} issue #{{.issue.number}}: {{.issue.title}} {{.issue.html_url}}" +
+		"{{else}}{{.action}}{{end}}",
+	TEMPLATE_ALERTMANAGER: "[{{upper .status}}{{if .alerts}}:{{len .alerts}}{{end}}] " +
+		"{{with .commonLabels}}{{.alertname}}{{end}}" +
+		"{{range .alerts}}\n{{with .labels}}{{.severity}} {{end}}" +
+		"{{with .annotations}}{{or .summary .description}}{{end}}{{end}}",
+}
+
+var funcs = template.FuncMap{
+	"upper":     strings.ToUpper,
+	"firstLine": firstLine,
+	"branch": func(ref string) string {
+		return strings.TrimPrefix(ref, "refs/heads/")
+	},
+	"short": func(id string) string {
+		if len(id) > 7 {
+			return id[:7]
+		}
+
+		return id
+	},
+}
+
+// Incoming is a tokenized endpoint which posts received payloads to
+// a channel via feeder bot
+type Incoming struct {
+	Token   string `json:"token"`
+	User    string `json:"user,omitempty"`
+	Channel string `json:"channel"`
+	// Bot is the bot name of the feeder which sends messages
+	Bot string `json:"bot"`
+	// Template is either a built-in template name or a text/template
+	// rendering json payloads. TEMPLATE_TEXT is used when it is empty
+	Template string `json:"template,omitempty"`
+	// Format of rendered messages. See formatting.ToIRC
+	Format    string    `json:"format,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	tmpl *template.Template
+}
+
+// Validate checks channel, bot, format and template of hook
+func (h *Incoming) Validate() error {
+	if h.Channel == "" {
+		return common.ErrChannelNotSet
+	}
+
+	if h.Bot == "" {
+		return common.ErrNicknameNotSet
+	}
+
+	// html bodies cannot be converted into irc messages
+	if _, err := formatting.ToIRC("", h.Format); err != nil {
+		return err
+	}
+
+	text := h.Template
+	if text == "" {
+		text = TEMPLATE_TEXT
+	}
+
+	if builtin, ok := templates[text]; ok {
+		text = builtin
+	}
+
+	tmpl, err := template.New("incoming").Funcs(funcs).Parse(text)
+	if err != nil {
+		return ErrInvalidTemplate
+	}
+	h.tmpl = tmpl
+
+	return nil
+}
+
+// Render converts payload into message body. Json payloads are
+// rendered with the template of hook, and others are used as plain text
+func (h *Incoming) Render(contentType string, payload []byte) (string, error) {
+	if len(payload) > MAX_PAYLOAD_SIZE {
+		return "", ErrPayloadTooLarge
+	}
+
+	body := string(payload)
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/json" {
+		if h.tmpl == nil {
+			if err := h.Validate(); err != nil {
+				return "", err
+			}
+		}
+
+		var data interface{}
+		if err := json.Unmarshal(payload, &data); err != nil {
+			return "", ErrInvalidPayload
+		}
+
+		var buf bytes.Buffer
+		if err := h.tmpl.Execute(&buf, data); err != nil {
+			return "", ErrInvalidPayload
+		}
+
+		// missing fields of payloads are not rendered
+		body = strings.Replace(buf.String(), "<no value>", "", -1)
+	}
+
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", ErrEmptyMessage
+	}
+
+	return formatting.ToIRC(body, h.Format)
+}
+
+// firstLine returns the first line of s
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+
+	return s
+}
@@ -0,0 +1,70 @@
+package webhook
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		template    string
+		contentType string
+		payload     string
+		expected    string
+	}{
+		{"", "text/plain", "build passed\n", "build passed"},
+		{"", "application/json; charset=utf-8", `{"text":"deployed"}`, "deployed"},
+		{"{{.name}} is {{.state}}", "application/json", `{"name":"api","state":"down"}`, "api is down"},
+		{
+			TEMPLATE_GITHUB,
+			"application/json",
+			`{"ref":"refs/heads/master","repository":{"full_name":"koding/irc-k"},"sender":{"login":"kermit"},
+			"commits":[{"id":"0123456789abcdef","message":"Fix parser\n\nlong description"}]}`,
+			"[koding/irc-k] kermit pushed 1 commit(s) to master\n0123456 Fix parser",
+		},
+		{
+			TEMPLATE_GITHUB,
+			"application/json",
+			`{"action":"opened","repository":{"full_name":"koding/irc-k"},"sender":{"login":"piggy"},
+			"pull_request":{"number":42,"title":"Add hooks","html_url":"https://github.com/koding/irc-k/pull/42"}}`,
+			"[koding/irc-k] piggy opened pull request #42: Add hooks https://github.com/koding/irc-k/pull/42",
+		},
+		{
+			TEMPLATE_ALERTMANAGER,
+			"application/json",
+			`{"status":"firing","commonLabels":{"alertname":"DiskFull"},
+			"alerts":[{"labels":{"severity":"critical"},"annotations":{"summary":"disk is full"}}]}`,
+			"[FIRING:1] DiskFull\ncritical disk is full",
+		},
+	}
+
+	for _, test := range tests {
+		h := &Incoming{Channel: "muppets", Bot: "koding-bot", Template: test.template}
+		body, err := h.Render(test.contentType, []byte(test.payload))
+		if err != nil {
+			t.Errorf("Expected nil but got %s", err)
+			continue
+		}
+
+		if body != test.expected {
+			t.Errorf("Expected %q but got %q", test.expected, body)
+		}
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	h := &Incoming{Channel: "muppets", Bot: "koding-bot", Template: "{{.text"}
+	if err := h.Validate(); err != ErrInvalidTemplate {
+		t.Errorf("Expected %s but got %v", ErrInvalidTemplate, err)
+	}
+
+	h = &Incoming{Channel: "muppets", Bot: "koding-bot"}
+	if _, err := h.Render("application/json", []byte("{")); err != ErrInvalidPayload {
+		t.Errorf("Expected %s but got %v", ErrInvalidPayload, err)
+	}
+
+	if _, err := h.Render("application/json", []byte(`{"other":"field"}`)); err != ErrEmptyMessage {
+		t.Errorf("Expected %s but got %v", ErrEmptyMessage, err)
+	}
+
+	if _, err := h.Render("text/plain", make([]byte, MAX_PAYLOAD_SIZE+1)); err != ErrPayloadTooLarge {
+		t.Errorf("Expected %s but got %v", ErrPayloadTooLarge, err)
+	}
+}
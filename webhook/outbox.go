@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/canthefason/irc-k/common"
+	"github.com/canthefason/r2dq"
+)
+
+// Used as queue prefix of outbox messages. Each feeder bot name has its
+// own outbox
+const OUTBOX_KEY = "outbox"
+
+var (
+	ErrInvalidMessage = errors.New("invalid message")
+	ErrUnknownBot     = errors.New("unknown bot")
+)
+
+// Outbox queues messages which are sent by feeder bots. Messages are
+// acknowledged after they are sent, so they are not lost when a feeder
+// stops
+type Outbox struct {
+	queue *r2dq.Queue
+}
+
+// NewOutbox creates outbox of the feeder bots with the given name
+func NewOutbox(r *common.RedisConf, bot string) *Outbox {
+	addr := fmt.Sprintf("%s:%s", r.Server, r.Port)
+
+	return &Outbox{
+		queue: r2dq.NewQueue(addr, r.DB, common.KeyWithPrefix(OUTBOX_KEY+":"+bot)),
+	}
+}
+
+// Post queues message
+func (o *Outbox) Post(m *common.Message) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return o.queue.Queue(string(data))
+}
+
+// Receive blocks until a message is queued. Returned raw value is used
+// for acknowledging the message. Invalid messages are acknowledged
+// and ErrInvalidMessage is returned
+func (o *Outbox) Receive() (*common.Message, string, error) {
+	data, err := o.queue.Dequeue()
+	if err != nil {
+		return nil, "", err
+	}
+
+	m := new(common.Message)
+	if err := json.Unmarshal([]byte(data), m); err != nil {
+		o.queue.Ack(data)
+		return nil, "", ErrInvalidMessage
+	}
+
+	return m, data, nil
+}
+
+// Ack removes a sent message from outbox
+func (o *Outbox) Ack(raw string) error {
+	return o.queue.Ack(raw)
+}
+
+// NAck requeues a message which could not be sent
+func (o *Outbox) NAck(raw string) error {
+	return o.queue.NAck(raw)
+}
+
+// Len returns the number of waiting messages
+func (o *Outbox) Len() (int64, error) {
+	return o.queue.Len()
+}
+
+// StopReceive stops waiting for messages. Receive returns
+// r2dq.ErrConnClosed afterwards
+func (o *Outbox) StopReceive() {
+	o.queue.StopDequeue()
+}
+
+// Close requeues received but unacknowledged messages, and closes
+// redis connections
+func (o *Outbox) Close() {
+	o.queue.Close()
+}
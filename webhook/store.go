@@ -0,0 +1,323 @@
+package webhook
+
+import (
+	"encoding/json"
+	"regexp"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+	"gopkg.in/redis.v2"
+)
+
+const (
+	// used for storing hooks in a hash by their ids
+	HOOKS_KEY = "webhooks"
+
+	// used for storing incoming hooks in a hash by their tokens
+	INCOMING_KEY = "webhooks:incoming"
+
+	// used for storing failed deliveries in a list
+	DEAD_LETTER_KEY = "webhooks:dead-letter"
+
+	// maximum number of stored failed deliveries
+	DEAD_LETTER_SIZE = 1000
+)
+
+// DeadLetter is a delivery which failed after all attempts
+type DeadLetter struct {
+	ID       string          `json:"id"`
+	Hook     string          `json:"hook"`
+	URL      string          `json:"url"`
+	Payload  json.RawMessage `json:"payload"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+	FailedAt time.Time       `json:"failedAt"`
+}
+
+// Store keeps hooks in redis. Hook ids of each channel are stored in
+// a set for finding hooks of received messages
+type Store struct {
+	redisConn *redis.Client
+}
+
+// NewStore creates a store with the given redis connection
+func NewStore(r *redis.Client) *Store {
+	return &Store{redisConn: r}
+}
+
+// Register validates and stores hook. Id and secret are generated
+func (s *Store) Register(h *Hook) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+
+	h.ID = common.NewID(8)
+	if h.Secret == "" {
+		h.Secret = common.NewID(16)
+	}
+	h.Channel = common.NormalizeChannel(h.Channel)
+	h.CreatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redisConn.Pipelined(func(p *redis.Pipeline) error {
+		p.HSet(common.KeyWithPrefix(HOOKS_KEY), h.ID, string(data))
+		p.SAdd(channelKey(h.Channel), h.ID)
+
+		return nil
+	})
+
+	return err
+}
+
+// Get returns hook with the given id
+func (s *Store) Get(id string) (*Hook, error) {
+	res := s.redisConn.HGet(common.KeyWithPrefix(HOOKS_KEY), id)
+	if res.Err() == redis.Nil {
+		return nil, ErrNotFound
+	}
+
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	return decode(res.Val())
+}
+
+// Remove deletes hook with the given id
+func (s *Store) Remove(id string) error {
+	h, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redisConn.Pipelined(func(p *redis.Pipeline) error {
+		p.HDel(common.KeyWithPrefix(HOOKS_KEY), id)
+		p.SRem(channelKey(h.Channel), id)
+
+		return nil
+	})
+
+	return err
+}
+
+// List returns hooks of user. All hooks are returned for empty user
+func (s *Store) List(user string) ([]*Hook, error) {
+	res := s.redisConn.HVals(common.KeyWithPrefix(HOOKS_KEY))
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	hooks := make([]*Hook, 0)
+	for _, data := range res.Val() {
+		h, err := decode(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if user == "" || h.User == user {
+			hooks = append(hooks, h)
+		}
+	}
+
+	return hooks, nil
+}
+
+// ForChannel returns hooks of channel
+func (s *Store) ForChannel(channel string) ([]*Hook, error) {
+	ids := s.redisConn.SMembers(channelKey(channel))
+	if ids.Err() != nil {
+		return nil, ids.Err()
+	}
+
+	if len(ids.Val()) == 0 {
+		return nil, nil
+	}
+
+	res := s.redisConn.HMGet(common.KeyWithPrefix(HOOKS_KEY), ids.Val()...)
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	hooks := make([]*Hook, 0, len(ids.Val()))
+	for _, v := range res.Val() {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		h, err := decode(data)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+
+	return hooks, nil
+}
+
+// RegisterIncoming validates and stores incoming hook. Token is generated
+func (s *Store) RegisterIncoming(h *Incoming) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+
+	h.Token = common.NewID(16)
+	h.Channel = common.NormalizeChannel(h.Channel)
+	h.CreatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	return s.redisConn.HSet(common.KeyWithPrefix(INCOMING_KEY), h.Token, string(data)).Err()
+}
+
+// GetIncoming returns incoming hook with the given token
+func (s *Store) GetIncoming(token string) (*Incoming, error) {
+	res := s.redisConn.HGet(common.KeyWithPrefix(INCOMING_KEY), token)
+	if res.Err() == redis.Nil {
+		return nil, ErrNotFound
+	}
+
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	h := new(Incoming)
+	if err := json.Unmarshal([]byte(res.Val()), h); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// RemoveIncoming deletes incoming hook with the given token
+func (s *Store) RemoveIncoming(token string) error {
+	res := s.redisConn.HDel(common.KeyWithPrefix(INCOMING_KEY), token)
+	if res.Err() != nil {
+		return res.Err()
+	}
+
+	if res.Val() == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// ListIncoming returns incoming hooks of user. All hooks are returned
+// for empty user
+func (s *Store) ListIncoming(user string) ([]*Incoming, error) {
+	res := s.redisConn.HVals(common.KeyWithPrefix(INCOMING_KEY))
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	hooks := make([]*Incoming, 0)
+	for _, data := range res.Val() {
+		h := new(Incoming)
+		if err := json.Unmarshal([]byte(data), h); err != nil {
+			return nil, err
+		}
+
+		if user == "" || h.User == user {
+			hooks = append(hooks, h)
+		}
+	}
+
+	return hooks, nil
+}
+
+// AddDeadLetter stores a failed delivery. Only the last DEAD_LETTER_SIZE
+// deliveries are kept
+func (s *Store) AddDeadLetter(d *DeadLetter) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.redisConn.Pipelined(func(p *redis.Pipeline) error {
+		p.LPush(common.KeyWithPrefix(DEAD_LETTER_KEY), string(data))
+		p.LTrim(common.KeyWithPrefix(DEAD_LETTER_KEY), 0, DEAD_LETTER_SIZE-1)
+
+		return nil
+	})
+
+	return err
+}
+
+// DeadLetters returns the newest failed deliveries
+func (s *Store) DeadLetters(count int) ([]*DeadLetter, error) {
+	res := s.redisConn.LRange(common.KeyWithPrefix(DEAD_LETTER_KEY), 0, int64(count-1))
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	letters := make([]*DeadLetter, 0, len(res.Val()))
+	for _, data := range res.Val() {
+		d := new(DeadLetter)
+		if err := json.Unmarshal([]byte(data), d); err != nil {
+			return nil, err
+		}
+		letters = append(letters, d)
+	}
+
+	return letters, nil
+}
+
+// TakeDeadLetter removes and returns the failed delivery with the given id
+func (s *Store) TakeDeadLetter(id string) (*DeadLetter, error) {
+	key := common.KeyWithPrefix(DEAD_LETTER_KEY)
+	res := s.redisConn.LRange(key, 0, -1)
+	if res.Err() != nil {
+		return nil, res.Err()
+	}
+
+	for _, data := range res.Val() {
+		d := new(DeadLetter)
+		if err := json.Unmarshal([]byte(data), d); err != nil {
+			return nil, err
+		}
+
+		if d.ID != id {
+			continue
+		}
+
+		removed := s.redisConn.LRem(key, 1, data)
+		if removed.Err() != nil {
+			return nil, removed.Err()
+		}
+
+		// letter is replayed by another request
+		if removed.Val() == 0 {
+			return nil, ErrNotFound
+		}
+
+		return d, nil
+	}
+
+	return nil, ErrNotFound
+}
+
+// decode unmarshals hook and compiles its pattern
+func decode(data string) (*Hook, error) {
+	h := new(Hook)
+	if err := json.Unmarshal([]byte(data), h); err != nil {
+		return nil, err
+	}
+
+	if h.Pattern != "" {
+		h.pattern, _ = regexp.Compile(h.Pattern)
+	}
+
+	return h, nil
+}
+
+func channelKey(channel string) string {
+	return common.KeyWithPrefix("webhooks:channel:" + common.NormalizeChannel(channel))
+}
@@ -0,0 +1,205 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/canthefason/irc-k/common"
+)
+
+func TestMatch(t *testing.T) {
+	m := common.Message{Nickname: "Kermit", Channel: "muppets", Body: "piggy: deploy is done"}
+
+	tests := []struct {
+		hook  Hook
+		match bool
+	}{
+		{Hook{Channel: "#Muppets"}, true},
+		{Hook{Channel: "kitchen"}, false},
+		{Hook{Channel: "muppets", Nickname: "kermit"}, true},
+		{Hook{Channel: "muppets", Nickname: "fozzie"}, false},
+		{Hook{Channel: "muppets", Mention: "Piggy"}, true},
+		{Hook{Channel: "muppets", Mention: "pig"}, false},
+		{Hook{Channel: "muppets", Pattern: `deploy (is|was) done`}, true},
+		{Hook{Channel: "muppets", Pattern: `^deploy`}, false},
+	}
+
+	for _, test := range tests {
+		if match := test.hook.Match(m); match != test.match {
+			t.Errorf("Expected %t but got %t for %+v", test.match, match, test.hook)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	h := &Hook{Channel: "muppets", URL: "ftp://example.com"}
+	if err := h.Validate(); err != ErrInvalidURL {
+		t.Errorf("Expected %s but got %v", ErrInvalidURL, err)
+	}
+
+	h = &Hook{Channel: "muppets", URL: "http://example.com", Pattern: "("}
+	if err := h.Validate(); err != ErrInvalidPattern {
+		t.Errorf("Expected %s but got %v", ErrInvalidPattern, err)
+	}
+
+	for _, u := range []string{"http://localhost:3000/hook", "http://127.0.0.1/hook",
+		"https://10.0.0.1/hook", "http://[::1]:80/hook", "http://169.254.169.254/latest"} {
+		h = &Hook{Channel: "muppets", URL: u}
+		if err := h.Validate(); err != ErrPrivateAddress {
+			t.Errorf("Expected %s but got %v for %s", ErrPrivateAddress, err, u)
+		}
+	}
+}
+
+func TestPublicOnly(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+
+	d := NewDispatcher(nil)
+	if _, err := d.Client.Get(server.URL); err == nil {
+		t.Error("Expected private address to be rejected")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if sig := req.Header.Get("X-Irck-Signature"); sig != "sha256="+Sign("secret", body) {
+			t.Errorf("Expected valid signature but got %s", sig)
+		}
+
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(500)
+			return
+		}
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(nil)
+	d.Client = http.DefaultClient
+	d.Backoff = time.Millisecond
+	// a single slot is enough, since it is released between attempts
+	d.slots = make(chan struct{}, 1)
+	done := make(chan int, 1)
+	d.done = func(attempts int, err error) {
+		if err != nil {
+			t.Errorf("Expected nil but got %s", err)
+		}
+		done <- attempts
+	}
+
+	d.Send(&Hook{ID: "1", URL: server.URL, Secret: "secret"}, []byte(`{"hook":"1"}`))
+
+	select {
+	case attempts := <-done:
+		if attempts != 3 {
+			t.Errorf("Expected %d but got %d", 3, attempts)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected delivery but timed out")
+	}
+}
+
+func TestStore(t *testing.T) {
+	conf := &common.RedisConf{Server: "localhost", Port: "6379", DB: 3}
+	if env := os.Getenv("REDIS_HOST"); env != "" {
+		conf.Server = env
+	}
+
+	if env := os.Getenv("REDIS_PORT"); env != "" {
+		conf.Port = env
+	}
+
+	redisConn := common.NewRedis(conf)
+	defer redisConn.Close()
+
+	s := NewStore(redisConn)
+	h := &Hook{User: "kermit", Channel: "#Muppets", URL: "http://example.com/hook"}
+	if err := s.Register(h); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+	defer s.Remove(h.ID)
+
+	hooks, err := s.ForChannel("muppets")
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if len(hooks) != 1 || hooks[0].ID != h.ID || hooks[0].Secret == "" {
+		t.Errorf("Expected hook %s but got %v", h.ID, hooks)
+	}
+
+	if err := s.Remove(h.ID); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if _, err := s.Get(h.ID); err != ErrNotFound {
+		t.Errorf("Expected %s but got %v", ErrNotFound, err)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	conf := &common.RedisConf{Server: "localhost", Port: "6379", DB: 3}
+	if env := os.Getenv("REDIS_HOST"); env != "" {
+		conf.Server = env
+	}
+
+	if env := os.Getenv("REDIS_PORT"); env != "" {
+		conf.Port = env
+	}
+
+	redisConn := common.NewRedis(conf)
+	defer redisConn.Close()
+	defer redisConn.Del(common.KeyWithPrefix(DEAD_LETTER_KEY))
+
+	s := NewStore(redisConn)
+	h := &Hook{Channel: "muppets", URL: "http://example.com/hook"}
+	if err := s.Register(h); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+	defer s.Remove(h.ID)
+
+	d := NewDispatcher(s)
+	d.Client = http.DefaultClient
+	d.Backoff = time.Millisecond
+	d.MaxAttempts = 2
+	done := make(chan error, 1)
+	d.done = func(attempts int, err error) { done <- err }
+
+	h.URL = server.URL
+	d.Send(h, []byte("{}"))
+	if err := <-done; err == nil {
+		t.Fatal("Expected error but got nil")
+	}
+
+	letters, err := s.DeadLetters(1)
+	if err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if len(letters) != 1 || letters[0].Hook != h.ID || letters[0].Attempts != 2 {
+		t.Fatalf("Expected dead letter of %s but got %v", h.ID, letters)
+	}
+
+	if err := d.Replay(letters[0].ID); err != nil {
+		t.Fatalf("Expected nil but got %s", err)
+	}
+
+	if err := d.Replay(letters[0].ID); err != ErrNotFound {
+		t.Errorf("Expected %s but got %v", ErrNotFound, err)
+	}
+}